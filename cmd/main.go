@@ -1,22 +1,136 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"ethparser/internal/api"
 	"ethparser/internal/parser"
+	"ethparser/internal/rpc"
+	"ethparser/internal/storage"
+	"ethparser/internal/webhook"
 )
 
 func main() {
+	storageType := flag.String("storage", "memory", "storage backend: memory or file")
+	dataDir := flag.String("data-dir", "data", "directory used by the file storage backend")
+	fromBlock := flag.Int("from-block", 0, "default block new subscriptions are backfilled from (0 disables backfill)")
+	workers := flag.Int("workers", parser.DefaultWorkers, "maximum number of concurrent block batch fetches")
+	batchSize := flag.Int("batch-size", parser.DefaultBatchSize, "number of blocks requested in one JSON-RPC batch")
+	rpcEndpoints := flag.String("rpc", "https://ethereum-rpc.publicnode.com", "comma-separated Ethereum JSON-RPC endpoints, in order of preference")
+	rpcQuorum := flag.Int("rpc-quorum", 0, "endpoints that must agree on a block hash (0 disables quorum mode)")
+	rpcMaxLag := flag.Int("rpc-max-lag", rpc.DefaultMultiConfig().MaxLag, "blocks an endpoint may trail the others before it is avoided")
+	wsEndpoint := flag.String("ws", "", "WebSocket JSON-RPC endpoint for new head notifications (polls when empty)")
+	rpcRetries := flag.Int("rpc-retries", rpc.DefaultClientConfig().MaxRetries, "retries for failed RPC calls")
+	rpcRateLimit := flag.Float64("rpc-rate-limit", 0, "maximum RPC requests per second (0 disables the limit)")
+	rpcBurst := flag.Int("rpc-burst", rpc.DefaultClientConfig().Burst, "RPC requests allowed at once before the rate limit applies")
+	traceMode := flag.String("trace", parser.TraceModeOff, "trace blocks for internal transactions: debug (debug_traceBlockByNumber) or parity (trace_block), empty disables tracing")
+	followDeployments := flag.Bool("follow-deployments", false, "subscribe contracts deployed by subscribed addresses")
+	webhookSecret := flag.String("webhook-secret", os.Getenv("WEBHOOK_SECRET"), "key signing webhook payloads of subscriptions created without their own secret (defaults to $WEBHOOK_SECRET)")
+	webhookAllowPrivate := flag.Bool("webhook-allow-private", false, "allow webhooks on loopback, link-local and private addresses")
+	webhookAttempts := flag.Int("webhook-attempts", webhook.DefaultConfig().MaxAttempts, "failed deliveries after which a webhook is moved to the dead-letter list")
+	adminKey := flag.String("admin-key", os.Getenv("ADMIN_API_KEY"), "key of the API key management endpoints, required unless -insecure-no-auth is set (defaults to $ADMIN_API_KEY)")
+	insecureNoAuth := flag.Bool("insecure-no-auth", false, "serve the API without API keys to anyone who can reach it, for local development only")
+	corsOrigins := flag.String("cors-origins", "", "comma-separated origins browsers may call the API from, * for any (CORS is disabled when empty)")
+	confirmations := flag.Int("confirmations", parser.DefaultConfirmations, "blocks required on top of a transaction before it is confirmed")
+	flag.Parse()
+
 	logger := log.New(os.Stdout, "ethparser: ", log.LstdFlags|log.Lshortfile)
 
+	// Fail closed: without keys anyone reaching the port could read every tracked wallet
+	if *adminKey == "" && !*insecureNoAuth {
+		logger.Fatalf("no admin key set, pass -admin-key (or $ADMIN_API_KEY), or -insecure-no-auth to serve the API without authentication")
+	}
+
 	logger.Printf("Starting Ethereum parser service ...")
 
+	// Initialize the storage
+	var store storage.Storage
+	switch *storageType {
+	case "memory":
+		store = storage.NewMemoryStorage(logger)
+	case "file":
+		fileStore, err := storage.NewFileStorage(*dataDir, logger)
+		if err != nil {
+			logger.Fatalf("failed to open file storage: %v", err)
+		}
+		store = fileStore
+
+		// Compact the journal on shutdown so the next start only loads a snapshot
+		go func() {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			<-signals
+			if err := fileStore.Close(); err != nil {
+				logger.Printf("failed to close file storage: %v", err)
+			}
+			os.Exit(0)
+		}()
+	default:
+		logger.Fatalf("unknown storage backend: %s", *storageType)
+	}
+	logger.Printf("Initialized %s storage", *storageType)
+
 	// Initialize the parser
-	ethParser := parser.NewEthParser("https://ethereum-rpc.publicnode.com", logger)
-	logger.Printf("Initialized parser with endpoint: https://ethereum-rpc.publicnode.com")
+	config := parser.DefaultConfig()
+	config.Confirmations = *confirmations
+	config.FromBlock = *fromBlock
+	config.Workers = *workers
+	config.BatchSize = *batchSize
+	config.WSEndpoint = *wsEndpoint
+	config.FollowDeployments = *followDeployments
+
+	switch *traceMode {
+	case parser.TraceModeOff, parser.TraceModeDebug, parser.TraceModeParity:
+		config.TraceMode = *traceMode
+	default:
+		logger.Fatalf("unknown trace mode: %s", *traceMode)
+	}
+
+	clientConfig := rpc.DefaultClientConfig()
+	clientConfig.MaxRetries = *rpcRetries
+	clientConfig.RateLimit = *rpcRateLimit
+	clientConfig.Burst = *rpcBurst
+
+	var urls []string
+	for _, url := range strings.Split(*rpcEndpoints, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	if len(urls) == 0 {
+		logger.Fatalf("no RPC endpoint given")
+	}
+	if *rpcQuorum > len(urls) {
+		logger.Fatalf("rpc quorum of %d needs at least as many endpoints, got %d", *rpcQuorum, len(urls))
+	}
+
+	var client rpc.RPCClient
+	if len(urls) == 1 {
+		client = rpc.NewClientWithConfig(urls[0], clientConfig)
+	} else {
+		multiConfig := rpc.DefaultMultiConfig()
+		multiConfig.Quorum = *rpcQuorum
+		multiConfig.MaxLag = *rpcMaxLag
+		client = rpc.NewMultiClientFromURLs(urls, clientConfig, multiConfig)
+	}
+
+	ethParser := parser.NewEthParser(client, store, config, logger)
+	logger.Printf("Initialized parser with endpoints: %s", strings.Join(urls, ", "))
+
+	// Deliver webhooks of subscriptions that have one
+	webhookConfig := webhook.DefaultConfig()
+	webhookConfig.Secret = *webhookSecret
+	webhookConfig.MaxAttempts = *webhookAttempts
+	webhookConfig.AllowPrivateNetworks = *webhookAllowPrivate
+	dispatcher := webhook.NewDispatcher(store, webhookConfig, logger)
+	ethParser.AddTransactionListener(dispatcher.Notify)
+	dispatcher.Start()
 
 	if err := ethParser.Start(); err != nil {
 		logger.Fatalf("failed to start parser: %v", err)
@@ -24,8 +138,17 @@ func main() {
 	logger.Printf("Parser started successfully")
 
 	// Start the API server
-	server := api.NewServer(ethParser)
-	server.RegisterRoutes()
+	serverConfig := api.DefaultConfig()
+	if *corsOrigins != "" {
+		serverConfig.CORSOrigins = strings.Split(*corsOrigins, ",")
+	}
+	serverConfig.AdminKey = *adminKey
+	serverConfig.InsecureNoAuth = *insecureNoAuth
+	if serverConfig.InsecureNoAuth {
+		logger.Printf("Authentication is off, the API is open to anyone who can reach it")
+	}
+	server := api.NewServer(ethParser, store, serverConfig)
+	ethParser.AddTransactionListener(server.Publish)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -33,7 +156,7 @@ func main() {
 	}
 
 	logger.Printf("starting server on :%s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	if err := http.ListenAndServe(":"+port, server.Handler()); err != nil {
 		logger.Fatalf("failed to start server: %v", err)
 	}
 }
@@ -0,0 +1,250 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ethparser/pkg/types"
+)
+
+// apiKeyPrefix starts every generated API key, so leaked keys are easy to spot
+const apiKeyPrefix = "ethp_"
+
+// KeyStore keeps the API keys of the tenants, implemented by storage.Storage
+type KeyStore interface {
+	AddAPIKey(key types.APIKey) types.APIKey
+	GetAPIKeys() []types.APIKey
+	GetAPIKeyByHash(hash string) (types.APIKey, bool)
+	RemoveAPIKey(id int) bool
+}
+
+// Access levels of the routes
+const (
+	accessTenant = iota // needs the API key of a tenant and only sees that tenant's data
+	accessAdmin         // needs the admin key
+	accessPublic        // needs no key
+)
+
+// validTenant restricts tenant names, as they appear in URLs and logs
+var validTenant = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+type CreateAPIKeyRequest struct {
+	Tenant string `json:"tenant"`
+	Name   string `json:"name,omitempty"`
+}
+
+// APIKeyResponse describes an API key; Key is only set when it is created, as
+// only its hash is kept
+type APIKeyResponse struct {
+	ID        int    `json:"id"`
+	Tenant    string `json:"tenant"`
+	Name      string `json:"name,omitempty"`
+	Prefix    string `json:"prefix"`
+	CreatedAt int64  `json:"createdAt"`
+	Key       string `json:"key,omitempty"`
+}
+
+type GetAPIKeysResponse struct {
+	Keys []APIKeyResponse `json:"keys"`
+}
+
+type RevokeAPIKeyResponse struct {
+	Success bool `json:"success"`
+}
+
+type tenantKey struct{}
+
+// tenantOf returns the tenant a request was authenticated for
+func tenantOf(r *http.Request) string {
+	tenant, _ := r.Context().Value(tenantKey{}).(string)
+	return tenant
+}
+
+// authEnabled reports whether requests need an API key, which is the case
+// unless authentication was explicitly turned off
+func (s *Server) authEnabled() bool {
+	return !s.config.InsecureNoAuth
+}
+
+// authorize wraps the handler of a route with its access check. Tenant routes
+// get the tenant of the key in the request context, DefaultTenant when
+// authentication is disabled.
+func (s *Server) authorize(rt route) http.HandlerFunc {
+	if rt.access == accessPublic {
+		return rt.handler
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.authEnabled() {
+			if rt.access == accessAdmin {
+				writeError(w, r, http.StatusForbidden, "Key management is disabled while authentication is off")
+				return
+			}
+			rt.handler(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, types.DefaultTenant)))
+			return
+		}
+
+		key := requestKey(r, rt.stream)
+		if key == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ethparser"`)
+			writeError(w, r, http.StatusUnauthorized, "API key required")
+			return
+		}
+
+		hash := hashKey(key)
+		if s.config.AdminKey != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(hashKey(s.config.AdminKey))) == 1 {
+			if rt.access != accessAdmin {
+				writeError(w, r, http.StatusForbidden, "The admin key can only manage API keys")
+				return
+			}
+			rt.handler(w, r)
+			return
+		}
+
+		apiKey, ok := s.keys.GetAPIKeyByHash(hash)
+		if !ok {
+			log.Printf("Rejected unknown API key %s", keyPrefix(key))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ethparser", error="invalid_token"`)
+			writeError(w, r, http.StatusUnauthorized, "Invalid API key")
+			return
+		}
+		if rt.access == accessAdmin {
+			writeError(w, r, http.StatusForbidden, "Admin key required")
+			return
+		}
+		rt.handler(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, apiKey.Tenant)))
+	}
+}
+
+// requestKey returns the API key of a request, sent as a bearer token or in
+// X-API-Key. Browsers cannot set headers on EventSource and WebSocket
+// connections, so streams also accept it in the apiKey query parameter.
+func requestKey(r *http.Request, stream bool) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if stream {
+		return r.URL.Query().Get("apiKey")
+	}
+	return ""
+}
+
+// randomToken returns prefix followed by 24 random bytes in hex
+func randomToken(prefix string) (string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(secret), nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// keyPrefix returns the start of a key, enough to tell keys apart in lists and logs
+func keyPrefix(key string) string {
+	return key[:min(len(key), len(apiKeyPrefix)+8)]
+}
+
+func (s *Server) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid request body: %v", err)
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !validTenant.MatchString(req.Tenant) {
+		log.Printf("Invalid tenant: %q", req.Tenant)
+		writeError(w, r, http.StatusBadRequest, "Tenant must be 1 to 64 lowercase letters, digits, '-' or '_'")
+		return
+	}
+	// The default tenant owns what was subscribed while the API was open, no
+	// key may act for it
+	if req.Tenant == types.DefaultTenant {
+		log.Printf("Refused API key for the reserved tenant %q", req.Tenant)
+		writeError(w, r, http.StatusBadRequest, "Tenant "+types.DefaultTenant+" is reserved")
+		return
+	}
+
+	key, err := randomToken(apiKeyPrefix)
+	if err != nil {
+		log.Printf("Failed to generate API key: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to generate API key")
+		return
+	}
+
+	apiKey := s.keys.AddAPIKey(types.APIKey{
+		Tenant:    req.Tenant,
+		Name:      req.Name,
+		Prefix:    keyPrefix(key),
+		Hash:      hashKey(key),
+		CreatedAt: time.Now().Unix(),
+	})
+	log.Printf("Created API key %d for tenant %s", apiKey.ID, apiKey.Tenant)
+
+	resp := newAPIKeyResponse(apiKey)
+	resp.Key = key
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleGetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	tenant := r.URL.Query().Get("tenant")
+
+	keys := make([]APIKeyResponse, 0)
+	for _, key := range s.keys.GetAPIKeys() {
+		if tenant == "" || key.Tenant == tenant {
+			keys = append(keys, newAPIKeyResponse(key))
+		}
+	}
+
+	resp := GetAPIKeysResponse{
+		Keys: keys,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Key id must be an integer")
+		return
+	}
+	if !s.keys.RemoveAPIKey(id) {
+		writeError(w, r, http.StatusNotFound, "API key not found")
+		return
+	}
+	log.Printf("Revoked API key %d", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(RevokeAPIKeyResponse{Success: true})
+}
+
+func newAPIKeyResponse(key types.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:        key.ID,
+		Tenant:    key.Tenant,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		CreatedAt: key.CreatedAt,
+	}
+}
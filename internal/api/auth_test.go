@@ -0,0 +1,196 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"ethparser/pkg/types"
+)
+
+func TestAuth(t *testing.T) {
+	const adminKey = "admin-secret"
+
+	mockParser := NewMockParser()
+	config := DefaultConfig()
+	config.AdminKey = adminKey
+	server := NewServer(mockParser, newKeyStore(), config)
+	handler := server.Handler()
+
+	serve := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	createKey := func(tenant string) APIKeyResponse {
+		t.Helper()
+		w := serve("POST", "/v1/admin/keys", adminKey, `{"tenant": "`+tenant+`", "name": "ci"}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status Created, got %v: %s", w.Code, w.Body.String())
+		}
+		var resp APIKeyResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if !strings.HasPrefix(resp.Key, apiKeyPrefix) || resp.Tenant != tenant || !strings.HasPrefix(resp.Key, resp.Prefix) {
+			t.Fatalf("Unexpected key %+v", resp)
+		}
+		return resp
+	}
+
+	acme := createKey("acme")
+	globex := createKey("globex")
+
+	t.Run("KeyRequired", func(t *testing.T) {
+		w := serve("GET", "/v1/subscriptions", "", "")
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Expected status Unauthorized, got %v", w.Code)
+		}
+
+		w = serve("GET", "/v1/subscriptions", "ethp_unknown", "")
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status Unauthorized for an unknown key, got %v", w.Code)
+		}
+
+		// The OpenAPI document stays public
+		if w = serve("GET", "/v1/openapi.json", "", ""); w.Code != http.StatusOK {
+			t.Errorf("Expected status OK, got %v", w.Code)
+		}
+	})
+
+	t.Run("TenantIsolation", func(t *testing.T) {
+		w := serve("POST", "/v1/subscribe", acme.Key, `{"address": "`+wallet+`"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status OK, got %v", w.Code)
+		}
+
+		// The same address is a separate subscription of each tenant
+		if w = serve("POST", "/v1/subscribe", globex.Key, `{"address": "`+wallet+`"}`); w.Code != http.StatusOK {
+			t.Errorf("Expected globex to subscribe too, got %v", w.Code)
+		}
+		if w = serve("POST", "/v1/unsubscribe", globex.Key, `{"address": "`+wallet+`"}`); w.Code != http.StatusOK {
+			t.Errorf("Expected globex to unsubscribe, got %v", w.Code)
+		}
+
+		var subs GetSubscriptionsResponse
+		w = serve("GET", "/v1/subscriptions", acme.Key, "")
+		_ = json.Unmarshal(w.Body.Bytes(), &subs)
+		if len(subs.Subscriptions) != 1 || subs.Subscriptions[0].Address != walletChecksum {
+			t.Errorf("Expected acme to keep its subscription, got %+v", subs.Subscriptions)
+		}
+		w = serve("GET", "/v1/subscriptions", globex.Key, "")
+		_ = json.Unmarshal(w.Body.Bytes(), &subs)
+		if len(subs.Subscriptions) != 0 {
+			t.Errorf("Expected no subscriptions for globex, got %+v", subs.Subscriptions)
+		}
+
+		// Reads are answered for the tenant of the key
+		serve("GET", "/v1/token-transfers?address="+wallet, globex.Key, "")
+		if mockParser.lastTenant != "globex" {
+			t.Errorf("Expected the read to be scoped to globex, got %q", mockParser.lastTenant)
+		}
+	})
+
+	t.Run("StreamKey", func(t *testing.T) {
+		// Browsers cannot set headers on EventSource, so streams take the key as a parameter
+		w := serve("GET", "/v1/stream?address="+wallet+"&cursor=abc&apiKey="+acme.Key, "", "")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected the key to be accepted, got %v", w.Code)
+		}
+		w = serve("GET", "/v1/subscriptions?apiKey="+acme.Key, "", "")
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected the parameter to be ignored outside streams, got %v", w.Code)
+		}
+	})
+
+	t.Run("AdminOnly", func(t *testing.T) {
+		if w := serve("GET", "/v1/admin/keys", acme.Key, ""); w.Code != http.StatusForbidden {
+			t.Errorf("Expected status Forbidden for a tenant key, got %v", w.Code)
+		}
+		if w := serve("GET", "/v1/subscriptions", adminKey, ""); w.Code != http.StatusForbidden {
+			t.Errorf("Expected status Forbidden for the admin key, got %v", w.Code)
+		}
+		if w := serve("POST", "/v1/admin/keys", adminKey, `{"tenant": "Not A Tenant"}`); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status Bad Request, got %v", w.Code)
+		}
+		if w := serve("POST", "/v1/admin/keys", adminKey, `{"tenant": "`+types.DefaultTenant+`"}`); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected the default tenant to be reserved, got %v", w.Code)
+		}
+	})
+
+	t.Run("ListAndRevoke", func(t *testing.T) {
+		var keys GetAPIKeysResponse
+		w := serve("GET", "/v1/admin/keys?tenant=acme", adminKey, "")
+		_ = json.Unmarshal(w.Body.Bytes(), &keys)
+		if len(keys.Keys) != 1 || keys.Keys[0].ID != acme.ID || keys.Keys[0].Key != "" {
+			t.Errorf("Expected the acme key without its secret, got %+v", keys.Keys)
+		}
+
+		if w = serve("DELETE", "/v1/admin/keys/"+strconv.Itoa(acme.ID), adminKey, ""); w.Code != http.StatusOK {
+			t.Errorf("Expected status OK, got %v", w.Code)
+		}
+		if w = serve("DELETE", "/v1/admin/keys/"+strconv.Itoa(acme.ID), adminKey, ""); w.Code != http.StatusNotFound {
+			t.Errorf("Expected status Not Found, got %v", w.Code)
+		}
+		if w = serve("GET", "/v1/subscriptions", acme.Key, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected the revoked key to be rejected, got %v", w.Code)
+		}
+	})
+}
+
+// newTenantKey stores an API key of tenant and returns it
+func newTenantKey(t *testing.T, keys KeyStore, tenant string) string {
+	t.Helper()
+	key, err := randomToken(apiKeyPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.AddAPIKey(types.APIKey{Tenant: tenant, Prefix: keyPrefix(key), Hash: hashKey(key)})
+	return key
+}
+
+func TestAuthWithoutAdminKey(t *testing.T) {
+	handler := NewServer(NewMockParser(), newKeyStore(), DefaultConfig()).Handler()
+
+	// Authentication stays on without an admin key, nothing but public routes answers
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/v1/subscribe", bytes.NewBufferString(`{"address": "`+wallet+`"}`)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized, got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/v1/admin/keys", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an empty admin key to be refused, got %v", w.Code)
+	}
+}
+
+func TestInsecureNoAuth(t *testing.T) {
+	mockParser := NewMockParser()
+	config := DefaultConfig()
+	config.InsecureNoAuth = true
+	handler := NewServer(mockParser, newKeyStore(), config).Handler()
+
+	// Without authentication everything belongs to the default tenant
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/v1/subscribe", bytes.NewBufferString(`{"address": "`+wallet+`"}`)))
+	if w.Code != http.StatusOK || !mockParser.subscribers[types.DefaultTenant][wallet] {
+		t.Errorf("Expected a default tenant subscription, got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/admin/keys", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected key management to be disabled, got %v", w.Code)
+	}
+}
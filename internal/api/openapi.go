@@ -0,0 +1,188 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// openAPIVersion is the version of the API described in the OpenAPI document
+const openAPIVersion = "1.0.0"
+
+// pathParamPattern finds the {name} parameters of a route path
+var pathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+// openAPIDocument generates an OpenAPI 3 description of the routes, with the
+// schemas of their bodies derived from the Go types
+func openAPIDocument(routes []route) map[string]interface{} {
+	schemas := &schemaGenerator{schemas: make(map[string]interface{})}
+	errorResponse := map[string]interface{}{"$ref": "#/components/responses/Error"}
+
+	paths := make(map[string]interface{})
+	for _, rt := range routes {
+		var parameters []interface{}
+		for _, match := range pathParamPattern.FindAllStringSubmatch(rt.path, -1) {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, p := range rt.params {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        p.name,
+				"in":          "query",
+				"description": p.description,
+				"required":    p.required,
+				"schema":      map[string]interface{}{"type": p.kind},
+			})
+		}
+
+		ok := map[string]interface{}{"description": "OK"}
+		switch {
+		case rt.stream:
+			ok["content"] = map[string]interface{}{
+				"text/event-stream": map[string]interface{}{"schema": schemas.schema(reflect.TypeOf(rt.response))},
+			}
+		case rt.response != nil:
+			ok["content"] = jsonContent(schemas.schema(reflect.TypeOf(rt.response)))
+		default:
+			ok["content"] = jsonContent(map[string]interface{}{"type": "object"})
+		}
+
+		operation := map[string]interface{}{
+			"operationId": rt.id,
+			"summary":     rt.summary,
+			"responses":   map[string]interface{}{"200": ok, "default": errorResponse},
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		switch rt.access {
+		case accessTenant:
+			operation["security"] = []interface{}{map[string]interface{}{"apiKey": []string{}}}
+		case accessAdmin:
+			operation["security"] = []interface{}{map[string]interface{}{"adminKey": []string{}}}
+		}
+		if rt.request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(schemas.schema(reflect.TypeOf(rt.request))),
+			}
+		}
+
+		item, _ := paths[rt.path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[rt.path] = item
+		}
+		item[strings.ToLower(rt.method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "ethparser API",
+			"version": openAPIVersion,
+		},
+		"servers": []interface{}{map[string]interface{}{"url": apiPrefix}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "API key of a tenant, created with the admin key",
+				},
+				"adminKey": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Admin key the service was started with",
+				},
+			},
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"description": "Error",
+					"content":     jsonContent(schemas.schema(reflect.TypeOf(ErrorResponse{}))),
+				},
+			},
+		},
+	}
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// schemaGenerator derives JSON schemas from Go types, collecting structs as
+// named component schemas
+type schemaGenerator struct {
+	schemas map[string]interface{}
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := g.schemas[t.Name()]; ok {
+			return ref
+		}
+		// Reserve the name first so recursive types terminate
+		g.schemas[t.Name()] = nil
+
+		properties := make(map[string]interface{})
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = g.schema(field.Type)
+			if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		g.schemas[t.Name()] = schema
+		return ref
+	}
+	return map[string]interface{}{}
+}
+
+func (s *Server) handleGetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.openAPI)
+}
@@ -0,0 +1,364 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	"ethparser/pkg/types"
+)
+
+// apiPrefix is the path every route of the current API version is served under
+const apiPrefix = "/v1"
+
+// route is an endpoint of the API. Routes are served under apiPrefix and
+// described in the OpenAPI document.
+type route struct {
+	method  string
+	path    string // relative to apiPrefix, with {name} path parameters
+	id      string
+	summary string
+	params  []param
+
+	// request and response are the JSON bodies, nil for none
+	request  interface{}
+	response interface{}
+
+	// stream is set for routes answering with an event stream
+	stream bool
+
+	// versionedOnly is set for routes that have no unversioned path
+	versionedOnly bool
+
+	// access - accessTenant, accessAdmin or accessPublic
+	access int
+
+	handler http.HandlerFunc
+}
+
+// param is a query parameter of a route
+type param struct {
+	name        string
+	kind        string // string, integer or boolean
+	description string
+	required    bool
+}
+
+var addressQueryParam = param{name: "address", kind: "string", description: "Subscribed address, checksummed or lowercase", required: true}
+
+func (s *Server) routes() []route {
+	return []route{
+		{method: http.MethodPost, path: "/subscribe", id: "subscribe", summary: "Subscribe to an address",
+			request: SubscribeRequest{}, response: SubscribeResponse{}, handler: s.handleSubscribe},
+		{method: http.MethodPost, path: "/unsubscribe", id: "unsubscribe", summary: "Unsubscribe from an address",
+			request: UnsubscribeRequest{}, response: UnsubscribeResponse{}, handler: s.handleUnsubscribe},
+		{method: http.MethodGet, path: "/subscriptions", id: "listSubscriptions", summary: "List subscriptions",
+			params:   []param{{name: "tag", kind: "string", description: "Only subscriptions with this tag"}},
+			response: GetSubscriptionsResponse{}, handler: s.handleGetSubscriptions},
+		{method: http.MethodGet, path: "/transactions", id: "getTransactions", summary: "Get a page of the transactions of an address",
+			params: []param{
+				addressQueryParam,
+				{name: "status", kind: "string", description: "pending, confirmed or finalized"},
+				{name: "direction", kind: "string", description: "in or out"},
+				{name: "order", kind: "string", description: "asc (default) or desc"},
+				{name: "limit", kind: "integer", description: "Page size, 100 by default and at most 1000"},
+				{name: "cursor", kind: "string", description: "nextCursor of the previous page"},
+				{name: "fromBlock", kind: "integer", description: "First block, inclusive"},
+				{name: "toBlock", kind: "integer", description: "Last block, inclusive"},
+				{name: "fromTime", kind: "integer", description: "Earliest block timestamp, inclusive"},
+				{name: "toTime", kind: "integer", description: "Latest block timestamp, inclusive"},
+				{name: "minValue", kind: "string", description: "Minimum value in wei, decimal or 0x hex"},
+				{name: "maxValue", kind: "string", description: "Maximum value in wei, decimal or 0x hex"},
+			},
+			response: GetTransactionsResponse{}, handler: s.handleGetTransactions},
+		{method: http.MethodGet, path: "/transactions/{hash}", id: "getTransaction", summary: "Look up a transaction by hash",
+			response: types.TransactionLookup{}, handler: s.handleGetTransaction},
+		{method: http.MethodGet, path: "/blocks/{number}", id: "getBlock", summary: "Get the subscribed transactions of a block",
+			response: types.BlockLookup{}, handler: s.handleGetBlock},
+		{method: http.MethodGet, path: "/internal-transactions", id: "getInternalTransactions", summary: "Get the internal transactions of an address",
+			params:   []param{addressQueryParam, {name: "status", kind: "string", description: "pending, confirmed or finalized"}},
+			response: GetInternalTransactionsResponse{}, handler: s.handleGetInternalTransactions},
+		{method: http.MethodGet, path: "/token-transfers", id: "getTokenTransfers", summary: "Get the ERC-20 transfers of an address",
+			params: []param{
+				addressQueryParam,
+				{name: "status", kind: "string", description: "pending, confirmed or finalized"},
+				{name: "token", kind: "string", description: "Only transfers of this token contract"},
+			},
+			response: GetTokenTransfersResponse{}, handler: s.handleGetTokenTransfers},
+		{method: http.MethodGet, path: "/nft-transfers", id: "getNFTTransfers", summary: "Get the NFT transfers of an address",
+			params:   []param{addressQueryParam, {name: "contract", kind: "string", description: "Only transfers of this collection"}},
+			response: GetNFTTransfersResponse{}, handler: s.handleGetNFTTransfers},
+		{method: http.MethodGet, path: "/nft-holdings", id: "getNFTHoldings", summary: "Get the NFTs held by an address",
+			params: []param{addressQueryParam}, response: GetNFTHoldingsResponse{}, handler: s.handleGetNFTHoldings},
+		{method: http.MethodGet, path: "/current-block", id: "getCurrentBlock", summary: "Get the last parsed block",
+			response: GetCurrentBlockResponse{}, handler: s.handleGetCurrentBlock},
+		{method: http.MethodGet, path: "/reorgs", id: "getReorgs", summary: "List recent chain reorganizations",
+			response: GetReorgsResponse{}, handler: s.handleGetReorgs},
+		{method: http.MethodGet, path: "/backfills", id: "getBackfills", summary: "List backfill jobs",
+			response: GetBackfillsResponse{}, handler: s.handleGetBackfills},
+		{method: http.MethodGet, path: "/failed-blocks", id: "getFailedBlocks", summary: "List blocks waiting to be retried",
+			response: GetFailedBlocksResponse{}, handler: s.handleGetFailedBlocks},
+		{method: http.MethodGet, path: "/webhook-deliveries", id: "getWebhookDeliveries", summary: "List queued webhook deliveries",
+			params:   []param{{name: "status", kind: "string", description: "pending or dead"}},
+			response: GetWebhookDeliveriesResponse{}, handler: s.handleGetWebhookDeliveries},
+		{method: http.MethodGet, path: "/stream", id: "streamTransactions", summary: "Stream transactions over Server-Sent Events or WebSocket",
+			params: []param{
+				addressQueryParam,
+				{name: "cursor", kind: "integer", description: "Block to replay stored transactions from"},
+			},
+			response: StreamEvent{}, stream: true, handler: s.handleStream},
+		{method: http.MethodGet, path: "/openapi.json", id: "getOpenAPI", summary: "Get this OpenAPI document",
+			versionedOnly: true, access: accessPublic, handler: s.handleGetOpenAPI},
+		{method: http.MethodPost, path: "/admin/keys", id: "createAPIKey", summary: "Create an API key for a tenant",
+			request: CreateAPIKeyRequest{}, response: APIKeyResponse{},
+			versionedOnly: true, access: accessAdmin, handler: s.handleCreateAPIKey},
+		{method: http.MethodGet, path: "/admin/keys", id: "listAPIKeys", summary: "List API keys",
+			params:   []param{{name: "tenant", kind: "string", description: "Only keys of this tenant"}},
+			response: GetAPIKeysResponse{}, versionedOnly: true, access: accessAdmin, handler: s.handleGetAPIKeys},
+		{method: http.MethodDelete, path: "/admin/keys/{id}", id: "revokeAPIKey", summary: "Revoke an API key",
+			response: RevokeAPIKeyResponse{}, versionedOnly: true, access: accessAdmin, handler: s.handleRevokeAPIKey},
+	}
+}
+
+// newMux registers the routes under apiPrefix, and under their former
+// unversioned paths for existing clients. Requests with a method a path does
+// not support, or for an unknown path, get a JSON error.
+func (s *Server) newMux(routes []route) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	allowed := make(map[string][]string)
+	unversioned := make(map[string]bool)
+	var paths []string
+	for _, rt := range routes {
+		handler := s.authorize(rt)
+		mux.HandleFunc(rt.method+" "+apiPrefix+rt.path, handler)
+		if !rt.versionedOnly {
+			mux.HandleFunc(rt.method+" "+rt.path, deprecated(handler))
+			unversioned[rt.path] = true
+		}
+
+		if _, ok := allowed[rt.path]; !ok {
+			paths = append(paths, rt.path)
+		}
+		allowed[rt.path] = append(allowed[rt.path], rt.method)
+	}
+
+	for _, path := range paths {
+		methods := strings.Join(allowed[path], ", ")
+		notAllowed := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Allow", methods)
+			writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+		mux.HandleFunc(apiPrefix+path, notAllowed)
+		if unversioned[path] {
+			mux.HandleFunc(path, notAllowed)
+		}
+	}
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusNotFound, "Not found")
+	})
+	return mux
+}
+
+// deprecated marks responses of the unversioned paths, which will be removed
+func deprecated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+apiPrefix+r.URL.Path+">; rel=\"successor-version\"")
+		next(w, r)
+	}
+}
+
+// ErrorResponse is the body of every error answered by the API
+type ErrorResponse struct {
+	Error     string `json:"error"` // status as a code, e.g. bad_request
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// writeError answers a request with a JSON error
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	resp := ErrorResponse{
+		Error:     strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"),
+		Message:   message,
+		RequestID: requestID(r.Context()),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+type requestIDKey struct{}
+
+// validRequestID restricts the request ids accepted from clients, as they end up in logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID returns the id assigned to a request by withRequestID
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID keeps the X-Request-ID of a request, or assigns a new one,
+// and returns it in the response
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			b := make([]byte, 8)
+			_, _ = rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// withAccessLog logs every request once it has been answered, as key=value pairs
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		log.Printf("request_id=%s method=%s path=%q status=%d bytes=%d duration=%s remote=%s",
+			requestID(r.Context()), r.Method, r.URL.Path, rec.statusCode(), rec.bytes, time.Since(start), r.RemoteAddr)
+	})
+}
+
+// withRecovery answers a panicking request with a 500 error instead of
+// dropping the connection
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			log.Printf("Panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, requestID(r.Context()), err, debug.Stack())
+			if rec.status == 0 && !rec.hijacked {
+				writeError(rec, r, http.StatusInternalServerError, "Internal server error")
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// withCORS allows browsers on the configured origins to call the API and
+// answers their preflight requests
+func (s *Server) withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || len(s.config.CORSOrigins) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch {
+		case slices.Contains(s.config.CORSOrigins, "*"):
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		case slices.Contains(s.config.CORSOrigins, origin):
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Request-ID, Last-Event-ID")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedOrigin reports whether browsers on origin may call the API
+func (s *Server) allowedOrigin(origin string) bool {
+	return slices.Contains(s.config.CORSOrigins, "*") || slices.Contains(s.config.CORSOrigins, origin)
+}
+
+// statusRecorder records the status and size of a response. It passes
+// flushes and hijacks through so streams keep working behind the middleware.
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	bytes    int
+	hijacked bool
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+func (rec *statusRecorder) Flush() {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		rec.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// statusCode returns the status answered, 101 for an upgraded connection
+func (rec *statusRecorder) statusCode() int {
+	switch {
+	case rec.hijacked:
+		return http.StatusSwitchingProtocols
+	case rec.status == 0:
+		return http.StatusOK
+	}
+	return rec.status
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	mockParser := NewMockParser()
+	config := DefaultConfig()
+	config.AdminKey = "admin-secret"
+	config.CORSOrigins = []string{"https://app.example.com"}
+	keys := newKeyStore()
+	server := NewServer(mockParser, keys, config)
+	handler := server.Handler()
+	key := newTenantKey(t, keys, "acme")
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		req.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Versioned", func(t *testing.T) {
+		w := serve(httptest.NewRequest("GET", "/v1/current-block", nil))
+		if w.Code != http.StatusOK || w.Header().Get("Deprecation") != "" {
+			t.Errorf("Expected status OK, got %v", w.Code)
+		}
+		if len(w.Header().Get("X-Request-ID")) != 16 {
+			t.Errorf("Expected a generated request id, got %q", w.Header().Get("X-Request-ID"))
+		}
+
+		// Former paths keep working but are marked deprecated
+		w = serve(httptest.NewRequest("GET", "/current-block", nil))
+		if w.Code != http.StatusOK || w.Header().Get("Deprecation") != "true" {
+			t.Errorf("Expected a deprecated response, got %v %v", w.Code, w.Header())
+		}
+	})
+
+	t.Run("JSONErrors", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/transactions?address=0x123", nil)
+		req.Header.Set("X-Request-ID", "client-id-1")
+		w := serve(req)
+
+		var resp ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Expected a JSON error body, got %q", w.Body.String())
+		}
+		if w.Code != http.StatusBadRequest || resp.Error != "bad_request" || resp.RequestID != "client-id-1" || resp.Message == "" {
+			t.Errorf("Unexpected error %v %+v", w.Code, resp)
+		}
+
+		w = serve(httptest.NewRequest("POST", "/v1/current-block", nil))
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET" || resp.Error != "method_not_allowed" {
+			t.Errorf("Expected Method Not Allowed, got %v %+v", w.Code, resp)
+		}
+
+		w = serve(httptest.NewRequest("GET", "/v2/current-block", nil))
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusNotFound || resp.Error != "not_found" {
+			t.Errorf("Expected Not Found, got %v %+v", w.Code, resp)
+		}
+	})
+
+	t.Run("PanicRecovery", func(t *testing.T) {
+		panicking := withRequestID(withRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		})))
+		w := httptest.NewRecorder()
+		panicking.ServeHTTP(w, httptest.NewRequest("GET", "/v1/current-block", nil))
+
+		var resp ErrorResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusInternalServerError || resp.RequestID == "" {
+			t.Errorf("Expected an internal server error, got %v %+v", w.Code, resp)
+		}
+	})
+
+	t.Run("CORS", func(t *testing.T) {
+		req := httptest.NewRequest("OPTIONS", "/v1/subscribe", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		w := serve(req)
+		if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+			t.Errorf("Expected an allowed preflight, got %v %v", w.Code, w.Header())
+		}
+
+		req = httptest.NewRequest("GET", "/v1/current-block", nil)
+		req.Header.Set("Origin", "https://evil.example.com")
+		w = serve(req)
+		if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("Expected no CORS headers for an unknown origin, got %v", w.Header())
+		}
+
+		// Without configured origins no origin is allowed
+		config := DefaultConfig()
+		config.AdminKey = "admin-secret"
+		req = httptest.NewRequest("GET", "/v1/current-block", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Authorization", "Bearer "+key)
+		w = httptest.NewRecorder()
+		NewServer(mockParser, keys, config).Handler().ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("Expected CORS to be disabled by default, got %v %v", w.Code, w.Header())
+		}
+	})
+
+	t.Run("OpenAPI", func(t *testing.T) {
+		w := serve(httptest.NewRequest("GET", "/v1/openapi.json", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status OK, got %v", w.Code)
+		}
+
+		var doc struct {
+			OpenAPI string `json:"openapi"`
+			Paths   map[string]map[string]struct {
+				OperationID string `json:"operationId"`
+				Parameters  []struct {
+					Name string `json:"name"`
+					In   string `json:"in"`
+				} `json:"parameters"`
+			} `json:"paths"`
+			Components struct {
+				Schemas map[string]json.RawMessage `json:"schemas"`
+			} `json:"components"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("Invalid OpenAPI document: %v", err)
+		}
+
+		operations := 0
+		for _, item := range doc.Paths {
+			operations += len(item)
+		}
+		if doc.OpenAPI != "3.0.3" || operations != len(server.routes()) {
+			t.Errorf("Expected every route to be documented, got %s with %d operations", doc.OpenAPI, operations)
+		}
+		lookup := doc.Paths["/transactions/{hash}"]["get"]
+		if lookup.OperationID != "getTransaction" || len(lookup.Parameters) != 1 || lookup.Parameters[0].In != "path" {
+			t.Errorf("Expected the hash path parameter, got %+v", lookup)
+		}
+		for _, name := range []string{"ParsedTransaction", "SubscribeRequest", "ErrorResponse"} {
+			if _, ok := doc.Components.Schemas[name]; !ok {
+				t.Errorf("Expected a %s schema", name)
+			}
+		}
+	})
+}
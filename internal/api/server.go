@@ -1,106 +1,684 @@
 package api
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math/big"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"ethparser/pkg/types"
 )
 
+// Page sizes of transaction listings
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// webhookSecretPrefix starts every generated webhook signing key
+const webhookSecretPrefix = "whsec_"
+
+// Config holds the settings of the API server
+type Config struct {
+	// CORSOrigins - origins browsers may call the API from, "*" for any; CORS is
+	// disabled when empty, the default
+	CORSOrigins []string
+
+	// AdminKey - key of the API key management endpoints. Every other request
+	// needs an API key created with it; without it only public routes answer.
+	AdminKey string
+
+	// InsecureNoAuth - serve every request without a key, as types.DefaultTenant.
+	// Only meant for local development.
+	InsecureNoAuth bool
+}
+
+// DefaultConfig returns the default server configuration
+func DefaultConfig() Config {
+	return Config{}
+}
+
 type Server struct {
-	parser types.Parser
+	parser  types.Parser
+	keys    KeyStore
+	config  Config
+	streams *streamHub
+	mux     *http.ServeMux
+	openAPI map[string]interface{}
 }
 
-func NewServer(parser types.Parser) *Server {
-	return &Server{
-		parser: parser,
+func NewServer(parser types.Parser, keys KeyStore, config Config) *Server {
+	s := &Server{
+		parser:  parser,
+		keys:    keys,
+		config:  config,
+		streams: newStreamHub(),
 	}
+	routes := s.routes()
+	s.mux = s.newMux(routes)
+	s.openAPI = openAPIDocument(routes)
+	return s
 }
 
 type SubscribeRequest struct {
-	Address string `json:"address"`
+	Address    string   `json:"address"`
+	FromBlock  *int     `json:"fromBlock,omitempty"`
+	Label      string   `json:"label,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	WebhookURL string   `json:"webhookUrl,omitempty"`
 }
 
+// SubscribeResponse carries the secret signing the webhook deliveries of a new
+// subscription; it is only returned here
 type SubscribeResponse struct {
+	Success       bool   `json:"success"`
+	Message       string `json:"message,omitempty"`
+	WebhookSecret string `json:"webhookSecret,omitempty"`
+}
+
+type UnsubscribeRequest struct {
+	Address string `json:"address"`
+	Purge   bool   `json:"purge,omitempty"`
+}
+
+type UnsubscribeResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message,omitempty"`
 }
 
+type GetSubscriptionsResponse struct {
+	Subscriptions []types.Subscription `json:"subscriptions"`
+}
+
 type GetTransactionsResponse struct {
 	Address      string                    `json:"address"`
 	Transactions []types.ParsedTransaction `json:"transactions"`
+	NextCursor   string                    `json:"nextCursor,omitempty"`
 }
 
-func (s *Server) RegisterRoutes() {
-	http.HandleFunc("/subscribe", s.handleSubscribe)
-	http.HandleFunc("/transactions", s.handleGetTransactions)
-	http.HandleFunc("/current-block", s.handleGetCurrentBlock)
+type GetInternalTransactionsResponse struct {
+	Address              string                      `json:"address"`
+	InternalTransactions []types.InternalTransaction `json:"internalTransactions"`
 }
 
-func (s *Server) handleSubscribe(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		log.Printf("Method not allowed: %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+type GetTokenTransfersResponse struct {
+	Address   string                `json:"address"`
+	Transfers []types.TokenTransfer `json:"transfers"`
+}
+
+type GetNFTTransfersResponse struct {
+	Address   string              `json:"address"`
+	Transfers []types.NFTTransfer `json:"transfers"`
+}
+
+type GetNFTHoldingsResponse struct {
+	Address  string             `json:"address"`
+	Holdings []types.NFTHolding `json:"holdings"`
+}
+
+type GetReorgsResponse struct {
+	Reorgs []types.ReorgEvent `json:"reorgs"`
+}
+
+type GetBackfillsResponse struct {
+	Backfills []types.BackfillJob `json:"backfills"`
+}
 
+type GetFailedBlocksResponse struct {
+	FailedBlocks []types.FailedBlock `json:"failedBlocks"`
+}
+
+type GetCurrentBlockResponse struct {
+	CurrentBlock int `json:"currentBlock"`
+}
+
+type GetWebhookDeliveriesResponse struct {
+	Deliveries []types.WebhookDelivery `json:"deliveries"`
+}
+
+// Handler returns the API, with request ids, access logs, panic recovery and
+// CORS applied to every route
+func (s *Server) Handler() http.Handler {
+	return withRequestID(withAccessLog(withRecovery(s.withCORS(s.mux))))
+}
+
+func (s *Server) handleSubscribe(w http.ResponseWriter, r *http.Request) {
 	var req SubscribeRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("Invalid request body: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	address, err := types.ParseAddress(req.Address)
+	if err != nil {
+		log.Printf("Invalid address: %v", err)
+		writeError(w, r, http.StatusBadRequest, "Address must be 0x followed by 40 hex digits, with a valid EIP-55 checksum if mixed case")
+		return
+	}
+
+	if req.FromBlock != nil && *req.FromBlock < 0 {
+		log.Printf("Invalid fromBlock: %d", *req.FromBlock)
+		writeError(w, r, http.StatusBadRequest, "fromBlock must not be negative")
 		return
 	}
 
+	if req.WebhookURL != "" && !isWebhookURL(req.WebhookURL) {
+		log.Printf("Invalid webhookUrl: %s", req.WebhookURL)
+		writeError(w, r, http.StatusBadRequest, "webhookUrl must be an absolute http or https URL")
+		return
+	}
+
+	// Every webhook gets its own signing key, so receivers cannot forge payloads
+	// for the webhooks of other subscriptions
+	var webhookSecret string
+	if req.WebhookURL != "" {
+		var err error
+		if webhookSecret, err = randomToken(webhookSecretPrefix); err != nil {
+			log.Printf("Failed to generate webhook secret: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to generate webhook secret")
+			return
+		}
+	}
+
 	log.Printf("Subscribing to address: %s", req.Address)
-	success := s.parser.Subscribe(req.Address)
+	success := s.parser.SubscribeWith(address.Hex(), types.SubscribeOptions{
+		Tenant:        tenantOf(r),
+		Label:         req.Label,
+		Tags:          req.Tags,
+		WebhookURL:    req.WebhookURL,
+		WebhookSecret: webhookSecret,
+		FromBlock:     req.FromBlock,
+	})
 	log.Printf("Subscription result for %s: %v", req.Address, success)
 	resp := SubscribeResponse{
 		Success: success,
 		Message: getSubscribeMessage(success),
 	}
+	if success {
+		resp.WebhookSecret = webhookSecret
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	var req UnsubscribeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid request body: %v", err)
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	address, err := types.ParseAddress(req.Address)
+	if err != nil {
+		log.Printf("Invalid address: %v", err)
+		writeError(w, r, http.StatusBadRequest, "Address must be 0x followed by 40 hex digits, with a valid EIP-55 checksum if mixed case")
+		return
+	}
+
+	log.Printf("Unsubscribing address: %s (purge: %v)", address, req.Purge)
+	success := s.parser.Unsubscribe(tenantOf(r), address.Hex(), req.Purge)
+	resp := UnsubscribeResponse{
+		Success: success,
+		Message: getUnsubscribeMessage(success),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleGetSubscriptions(w http.ResponseWriter, r *http.Request) {
+	tag := r.URL.Query().Get("tag")
+
+	subscriptions := make([]types.Subscription, 0)
+	for _, sub := range s.parser.ListSubscriptions(tenantOf(r)) {
+		if tag != "" && !hasTag(sub.Tags, tag) {
+			continue
+		}
+		sub.Address = types.ChecksumAddress(sub.Address)
+		sub.WebhookSecret = ""
+		subscriptions = append(subscriptions, sub)
+	}
+
+	resp := GetSubscriptionsResponse{
+		Subscriptions: subscriptions,
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
 func (s *Server) handleGetTransactions(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		log.Printf("Method not allowed: %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	address, ok := addressParam(w, r)
+	if !ok {
 		return
 	}
 
-	address := r.URL.Query().Get("address")
-	if address == "" {
-		log.Printf("Missing address parameter")
-		http.Error(w, "Address is required", http.StatusBadRequest)
+	query, err := transactionQuery(r)
+	if err != nil {
+		log.Printf("Invalid transaction query: %v", err)
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
+	query.Address = address.Hex()
+	query.Tenant = tenantOf(r)
 
 	log.Printf("Getting transactions for address: %s", address)
-	transactions := s.parser.GetTransactions(address)
+	page := s.parser.QueryTransactions(query)
+	transactions := make([]types.ParsedTransaction, 0, len(page.Transactions))
+	for _, tx := range page.Transactions {
+		transactions = append(transactions, checksumTransaction(tx))
+	}
 	log.Printf("Found %d transactions for address %s", len(transactions), address)
 
 	resp := GetTransactionsResponse{
-		Address:      address,
+		Address:      address.Checksum(),
 		Transactions: transactions,
 	}
+	if page.Next != nil {
+		resp.NextCursor = page.Next.String()
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
-func (s *Server) handleGetCurrentBlock(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func (s *Server) handleGetTransaction(w http.ResponseWriter, r *http.Request) {
+	hash := strings.ToLower(r.PathValue("hash"))
+	if !isTransactionHash(hash) {
+		log.Printf("Invalid transaction hash: %s", hash)
+		writeError(w, r, http.StatusBadRequest, "Hash must be 0x followed by 64 hex digits")
+		return
+	}
+
+	lookup, err := s.parser.GetTransaction(tenantOf(r), hash)
+	if errors.Is(err, types.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "Transaction not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up transaction %s: %v", hash, err)
+		writeError(w, r, http.StatusBadGateway, "Failed to look up the transaction on the node")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(checksumLookup(lookup))
+}
+
+func (s *Server) handleGetBlock(w http.ResponseWriter, r *http.Request) {
+	number, err := strconv.Atoi(r.PathValue("number"))
+	if err != nil || number < 0 {
+		log.Printf("Invalid block number: %s", r.PathValue("number"))
+		writeError(w, r, http.StatusBadRequest, "Block number must be a non-negative integer")
+		return
+	}
+
+	block, err := s.parser.GetBlock(tenantOf(r), number)
+	if errors.Is(err, types.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "Block not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up block %d: %v", number, err)
+		writeError(w, r, http.StatusBadGateway, "Failed to look up the block on the node")
+		return
+	}
+
+	for i := range block.Transactions {
+		block.Transactions[i] = checksumLookup(block.Transactions[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(block)
+}
+
+func (s *Server) handleGetInternalTransactions(w http.ResponseWriter, r *http.Request) {
+	address, ok := addressParam(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && !types.IsValidStatus(status) {
+		log.Printf("Invalid status parameter: %s", status)
+		writeError(w, r, http.StatusBadRequest, "Status must be one of pending, confirmed, finalized")
+		return
+	}
+
+	internal := make([]types.InternalTransaction, 0)
+	for _, tx := range s.parser.GetInternalTransactions(tenantOf(r), address.Hex()) {
+		if status == "" || tx.Status == status {
+			tx.From = types.ChecksumAddress(tx.From)
+			tx.To = types.ChecksumAddress(tx.To)
+			internal = append(internal, tx)
+		}
+	}
+	log.Printf("Found %d internal transactions for address %s", len(internal), address)
+
+	resp := GetInternalTransactionsResponse{
+		Address:              address.Checksum(),
+		InternalTransactions: internal,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleGetTokenTransfers(w http.ResponseWriter, r *http.Request) {
+	address, ok := addressParam(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && !types.IsValidStatus(status) {
+		log.Printf("Invalid status parameter: %s", status)
+		writeError(w, r, http.StatusBadRequest, "Status must be one of pending, confirmed, finalized")
+		return
+	}
+	token := r.URL.Query().Get("token")
+
+	log.Printf("Getting token transfers for address: %s", address)
+	transfers := make([]types.TokenTransfer, 0)
+	for _, transfer := range s.parser.GetTokenTransfers(tenantOf(r), address.Hex()) {
+		if status != "" && transfer.Status != status {
+			continue
+		}
+		if token != "" && !strings.EqualFold(transfer.Token, token) {
+			continue
+		}
+		transfer.Token = types.ChecksumAddress(transfer.Token)
+		transfer.From = types.ChecksumAddress(transfer.From)
+		transfer.To = types.ChecksumAddress(transfer.To)
+		transfers = append(transfers, transfer)
+	}
+	log.Printf("Found %d token transfers for address %s", len(transfers), address)
+
+	resp := GetTokenTransfersResponse{
+		Address:   address.Checksum(),
+		Transfers: transfers,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleGetNFTTransfers(w http.ResponseWriter, r *http.Request) {
+	address, ok := addressParam(w, r)
+	if !ok {
+		return
+	}
+	contract := r.URL.Query().Get("contract")
+
+	transfers := make([]types.NFTTransfer, 0)
+	for _, transfer := range s.parser.GetNFTTransfers(tenantOf(r), address.Hex()) {
+		if contract != "" && !strings.EqualFold(transfer.Contract, contract) {
+			continue
+		}
+		transfer.Contract = types.ChecksumAddress(transfer.Contract)
+		transfer.Operator = types.ChecksumAddress(transfer.Operator)
+		transfer.From = types.ChecksumAddress(transfer.From)
+		transfer.To = types.ChecksumAddress(transfer.To)
+		transfers = append(transfers, transfer)
+	}
+	log.Printf("Found %d NFT transfers for address %s", len(transfers), address)
+
+	resp := GetNFTTransfersResponse{
+		Address:   address.Checksum(),
+		Transfers: transfers,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleGetNFTHoldings(w http.ResponseWriter, r *http.Request) {
+	address, ok := addressParam(w, r)
+	if !ok {
 		return
 	}
 
+	holdings := s.parser.GetNFTHoldings(tenantOf(r), address.Hex())
+	for i := range holdings {
+		holdings[i].Contract = types.ChecksumAddress(holdings[i].Contract)
+	}
+
+	resp := GetNFTHoldingsResponse{
+		Address:  address.Checksum(),
+		Holdings: holdings,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleGetCurrentBlock(w http.ResponseWriter, r *http.Request) {
 	currentBlock := s.parser.GetCurrentBlock()
 
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]int{"currentBlock": currentBlock})
+	_ = json.NewEncoder(w).Encode(GetCurrentBlockResponse{CurrentBlock: currentBlock})
+}
+
+func (s *Server) handleGetReorgs(w http.ResponseWriter, r *http.Request) {
+	resp := GetReorgsResponse{
+		Reorgs: s.parser.GetReorgs(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleGetBackfills(w http.ResponseWriter, r *http.Request) {
+	backfills := s.parser.GetBackfills(tenantOf(r))
+	for i := range backfills {
+		backfills[i].Address = types.ChecksumAddress(backfills[i].Address)
+	}
+
+	resp := GetBackfillsResponse{
+		Backfills: backfills,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleGetFailedBlocks(w http.ResponseWriter, r *http.Request) {
+	resp := GetFailedBlocksResponse{
+		FailedBlocks: s.parser.GetFailedBlocks(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != types.DeliveryPending && status != types.DeliveryDead {
+		log.Printf("Invalid status parameter: %s", status)
+		writeError(w, r, http.StatusBadRequest, "Status must be pending or dead")
+		return
+	}
+
+	deliveries := s.parser.GetWebhookDeliveries(tenantOf(r), status)
+	for i := range deliveries {
+		deliveries[i].Address = types.ChecksumAddress(deliveries[i].Address)
+		deliveries[i].Transaction = checksumTransaction(deliveries[i].Transaction)
+		deliveries[i].Secret = ""
+	}
+
+	resp := GetWebhookDeliveriesResponse{
+		Deliveries: deliveries,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// transactionQuery reads the pagination, sorting and filter parameters of a
+// transaction listing
+func transactionQuery(r *http.Request) (types.TransactionQuery, error) {
+	params := r.URL.Query()
+	query := types.TransactionQuery{
+		Status:    params.Get("status"),
+		Direction: params.Get("direction"),
+		Order:     params.Get("order"),
+		Limit:     defaultPageSize,
+	}
+
+	if query.Status != "" && !types.IsValidStatus(query.Status) {
+		return query, errors.New("Status must be one of pending, confirmed, finalized")
+	}
+	if query.Direction != "" && query.Direction != types.DirectionIn && query.Direction != types.DirectionOut {
+		return query, errors.New("Direction must be in or out")
+	}
+	if query.Order == "" {
+		query.Order = types.SortAsc
+	}
+	if query.Order != types.SortAsc && query.Order != types.SortDesc {
+		return query, errors.New("Order must be asc or desc")
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageSize {
+			return query, fmt.Errorf("Limit must be between 1 and %d", maxPageSize)
+		}
+		query.Limit = n
+	}
+	if cursor := params.Get("cursor"); cursor != "" {
+		after, err := types.ParseTransactionCursor(cursor)
+		if err != nil {
+			return query, errors.New("Cursor must be the nextCursor of a previous page")
+		}
+		query.After = &after
+	}
+
+	for name, target := range map[string]*int64{
+		"fromBlock": &query.FromBlock,
+		"toBlock":   &query.ToBlock,
+		"fromTime":  &query.FromTime,
+		"toTime":    &query.ToTime,
+	} {
+		if value := params.Get(name); value != "" {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return query, fmt.Errorf("%s must be a non-negative integer", name)
+			}
+			*target = n
+		}
+	}
+
+	for name, target := range map[string]**big.Int{
+		"minValue": &query.MinValue,
+		"maxValue": &query.MaxValue,
+	} {
+		if value := params.Get(name); value != "" {
+			n, err := parseWei(value)
+			if err != nil {
+				return query, fmt.Errorf("%s must be a non-negative amount in wei", name)
+			}
+			*target = n
+		}
+	}
+	return query, nil
+}
+
+// parseWei decodes an amount in wei given in decimal or as 0x-prefixed hex
+func parseWei(s string) (*big.Int, error) {
+	if strings.HasPrefix(s, "0x") {
+		q, err := types.ParseQuantity(s)
+		if err != nil {
+			return nil, err
+		}
+		return q.Big(), nil
+	}
+
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 {
+		return nil, fmt.Errorf("invalid amount %q", s)
+	}
+	return n, nil
+}
+
+// checksumLookup returns a lookup with its addresses in checksummed form
+func checksumLookup(lookup types.TransactionLookup) types.TransactionLookup {
+	lookup.Transaction = checksumTransaction(lookup.Transaction)
+	addresses := make([]string, len(lookup.Addresses))
+	for i, address := range lookup.Addresses {
+		addresses[i] = types.ChecksumAddress(address)
+	}
+	lookup.Addresses = addresses
+	return lookup
+}
+
+// checksumTransaction returns tx with its addresses in checksummed form
+func checksumTransaction(tx types.ParsedTransaction) types.ParsedTransaction {
+	tx.From = types.ChecksumAddress(tx.From)
+	tx.To = types.ChecksumAddress(tx.To)
+	tx.ContractAddress = types.ChecksumAddress(tx.ContractAddress)
+	return tx
+}
+
+// addressParam returns the `address` query parameter, writing a 400 error when
+// it is missing or not a valid address
+func addressParam(w http.ResponseWriter, r *http.Request) (types.Address, bool) {
+	address := r.URL.Query().Get("address")
+	if address == "" {
+		log.Printf("Missing address parameter")
+		writeError(w, r, http.StatusBadRequest, "Address is required")
+		return types.Address{}, false
+	}
+
+	parsed, err := types.ParseAddress(address)
+	if err != nil {
+		log.Printf("Invalid address parameter: %v", err)
+		writeError(w, r, http.StatusBadRequest, "Address must be 0x followed by 40 hex digits, with a valid EIP-55 checksum if mixed case")
+		return types.Address{}, false
+	}
+	return parsed, true
+}
+
+// isTransactionHash reports whether s is a 0x-prefixed 32-byte hex hash
+func isTransactionHash(s string) bool {
+	if len(s) != 66 || !strings.HasPrefix(s, "0x") {
+		return false
+	}
+	_, err := hex.DecodeString(s[2:])
+	return err == nil
+}
+
+// isWebhookURL reports whether s is an absolute http or https URL
+func isWebhookURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func getUnsubscribeMessage(success bool) string {
+	if success {
+		return "Address unsubscribed successfully"
+	}
+	return "Address not subscribed"
 }
 
 func getSubscribeMessage(success bool) string {
@@ -3,26 +3,57 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 
+	"ethparser/internal/storage"
 	"ethparser/pkg/types"
 )
 
-// MockParser must implement all methods from the Parser interface
+const (
+	wallet         = "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"
+	walletChecksum = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+)
+
+// MockParser must implement all methods from the Parser interface. It keeps
+// subscriptions per tenant and records the tenant of the last read.
 type MockParser struct {
 	currentBlock int
-	subscribers  map[string]bool
+	subscribers  map[string]map[string]bool
+	lastTenant   string
+	labels       map[string]string
 	transactions map[string][]types.ParsedTransaction
+	internal     map[string][]types.InternalTransaction
+	transfers    map[string][]types.TokenTransfer
+	nfts         map[string][]types.NFTTransfer
+	reorgs       []types.ReorgEvent
+	fromBlocks   map[string]int
+	failedBlocks []types.FailedBlock
+	webhooks     map[string]string
+	secrets      map[string]string
+	lastQuery    types.TransactionQuery
+	lookupErr    error
+	deliveries   []types.WebhookDelivery
 }
 
 // NewMockParser creates a new mock parser
 func NewMockParser() *MockParser {
 	return &MockParser{
 		currentBlock: 0,
-		subscribers:  make(map[string]bool),
+		subscribers:  make(map[string]map[string]bool),
+		labels:       make(map[string]string),
 		transactions: make(map[string][]types.ParsedTransaction),
+		internal:     make(map[string][]types.InternalTransaction),
+		transfers:    make(map[string][]types.TokenTransfer),
+		nfts:         make(map[string][]types.NFTTransfer),
+		fromBlocks:   make(map[string]int),
+		webhooks:     make(map[string]string),
+		secrets:      make(map[string]string),
 	}
 }
 
@@ -32,24 +63,180 @@ func (m *MockParser) GetCurrentBlock() int {
 }
 
 func (m *MockParser) Subscribe(address string) bool {
-	if m.subscribers[address] {
+	return m.SubscribeWith(address, types.SubscribeOptions{})
+}
+
+func (m *MockParser) SubscribeFrom(address string, fromBlock int) bool {
+	return m.SubscribeWith(address, types.SubscribeOptions{FromBlock: &fromBlock})
+}
+
+func (m *MockParser) SubscribeWith(address string, opts types.SubscribeOptions) bool {
+	tenant := opts.Tenant
+	if tenant == "" {
+		tenant = types.DefaultTenant
+	}
+	if m.subscribers[tenant][address] {
 		return false
 	}
-	m.subscribers[address] = true
+	if m.subscribers[tenant] == nil {
+		m.subscribers[tenant] = make(map[string]bool)
+	}
+	m.subscribers[tenant][address] = true
+	if opts.FromBlock != nil {
+		m.fromBlocks[address] = *opts.FromBlock
+	}
+	m.labels[address] = opts.Label
+	m.webhooks[address] = opts.WebhookURL
+	m.secrets[address] = opts.WebhookSecret
 	return true
 }
 
+func (m *MockParser) Unsubscribe(tenant, address string, purge bool) bool {
+	if tenant == "" {
+		tenant = types.DefaultTenant
+	}
+	if !m.subscribers[tenant][address] {
+		return false
+	}
+	delete(m.subscribers[tenant], address)
+	if purge {
+		delete(m.transactions, address)
+	}
+	return true
+}
+
+func (m *MockParser) ListSubscriptions(tenant string) []types.Subscription {
+	subs := []types.Subscription{}
+	for subTenant, addresses := range m.subscribers {
+		if tenant != "" && subTenant != tenant {
+			continue
+		}
+		for address := range addresses {
+			subs = append(subs, types.Subscription{Tenant: subTenant, Address: address, Label: m.labels[address], StartBlock: m.fromBlocks[address],
+				WebhookURL: m.webhooks[address], WebhookSecret: m.secrets[address]})
+		}
+	}
+	return subs
+}
+
 func (m *MockParser) GetTransactions(address string) []types.ParsedTransaction {
 	return m.transactions[address]
 }
 
+func (m *MockParser) QueryTransactions(query types.TransactionQuery) types.TransactionPage {
+	m.lastQuery = query
+	page := types.TransactionPage{Transactions: []types.ParsedTransaction{}}
+	for _, tx := range m.transactions[query.Address] {
+		if !query.Matches(tx) {
+			continue
+		}
+		if after := query.After; after != nil {
+			if c := after.Compare(tx); (query.Order == types.SortDesc && c <= 0) || (query.Order != types.SortDesc && c >= 0) {
+				continue
+			}
+		}
+		if query.Limit > 0 && len(page.Transactions) == query.Limit {
+			next := types.CursorOf(page.Transactions[len(page.Transactions)-1])
+			page.Next = &next
+			break
+		}
+		page.Transactions = append(page.Transactions, tx)
+	}
+	return page
+}
+
+func (m *MockParser) GetTransaction(tenant, hash string) (types.TransactionLookup, error) {
+	m.lastTenant = tenant
+	if m.lookupErr != nil {
+		return types.TransactionLookup{}, m.lookupErr
+	}
+	for address, txs := range m.transactions {
+		for _, tx := range txs {
+			if tx.Hash == hash {
+				return types.TransactionLookup{Transaction: tx, Addresses: []string{address}, Indexed: true}, nil
+			}
+		}
+	}
+	return types.TransactionLookup{}, types.ErrNotFound
+}
+
+func (m *MockParser) GetBlock(tenant string, number int) (types.BlockLookup, error) {
+	m.lastTenant = tenant
+	if number > m.currentBlock {
+		return types.BlockLookup{}, types.ErrNotFound
+	}
+	block := types.BlockLookup{Number: int64(number), Indexed: true, Transactions: []types.TransactionLookup{}}
+	for address, txs := range m.transactions {
+		for _, tx := range txs {
+			if tx.BlockNumber == int64(number) {
+				block.Transactions = append(block.Transactions, types.TransactionLookup{Transaction: tx, Addresses: []string{address}, Indexed: true})
+			}
+		}
+	}
+	return block, nil
+}
+
+func (m *MockParser) GetInternalTransactions(tenant, address string) []types.InternalTransaction {
+	return m.internal[address]
+}
+
+func (m *MockParser) GetTokenTransfers(tenant, address string) []types.TokenTransfer {
+	m.lastTenant = tenant
+	return m.transfers[address]
+}
+
+func (m *MockParser) GetNFTTransfers(tenant, address string) []types.NFTTransfer {
+	return m.nfts[address]
+}
+
+func (m *MockParser) GetNFTHoldings(tenant, address string) []types.NFTHolding {
+	holdings := []types.NFTHolding{}
+	for _, transfer := range m.nfts[address] {
+		if transfer.To == address {
+			holdings = append(holdings, types.NFTHolding{Contract: transfer.Contract, TokenID: transfer.TokenID, Amount: transfer.Amount})
+		}
+	}
+	return holdings
+}
+
+func (m *MockParser) GetReorgs() []types.ReorgEvent {
+	return m.reorgs
+}
+
+func (m *MockParser) GetBackfills(tenant string) []types.BackfillJob {
+	jobs := []types.BackfillJob{}
+	for address, fromBlock := range m.fromBlocks {
+		jobs = append(jobs, types.BackfillJob{Address: address, FromBlock: fromBlock})
+	}
+	return jobs
+}
+
+func (m *MockParser) GetFailedBlocks() []types.FailedBlock {
+	return m.failedBlocks
+}
+
+func (m *MockParser) GetWebhookDeliveries(tenant, status string) []types.WebhookDelivery {
+	deliveries := []types.WebhookDelivery{}
+	for _, delivery := range m.deliveries {
+		if (tenant == "" || delivery.Tenant == tenant) && (status == "" || delivery.Status == status) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries
+}
+
+// newKeyStore returns an empty in-memory key store
+func newKeyStore() KeyStore {
+	return storage.NewMemoryStorage(log.New(os.Stdout, "test: ", log.LstdFlags))
+}
+
 func TestServer(t *testing.T) {
 	mockParser := NewMockParser()
-	server := NewServer(mockParser)
+	server := NewServer(mockParser, newKeyStore(), DefaultConfig())
 
 	t.Run("Subscribe", func(t *testing.T) {
 		// Test subscription
-		reqBody := SubscribeRequest{Address: "0x123"}
+		reqBody := SubscribeRequest{Address: wallet}
 		body, _ := json.Marshal(reqBody)
 		req := httptest.NewRequest("POST", "/subscribe", bytes.NewBuffer(body))
 		req.Header.Set("Content-Type", "application/json")
@@ -64,8 +251,33 @@ func TestServer(t *testing.T) {
 		}
 	})
 
+	t.Run("SubscribeFromBlock", func(t *testing.T) {
+		body := []byte(`{"address": "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359", "fromBlock": 900}`)
+		req := httptest.NewRequest("POST", "/subscribe", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+
+		server.handleSubscribe(w, req)
+
+		var resp SubscribeResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if !resp.Success {
+			t.Errorf("Expected successful subscription")
+		}
+
+		req = httptest.NewRequest("GET", "/backfills", nil)
+		w = httptest.NewRecorder()
+
+		server.handleGetBackfills(w, req)
+
+		var backfills GetBackfillsResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &backfills)
+		if len(backfills.Backfills) != 1 || backfills.Backfills[0].FromBlock != 900 {
+			t.Errorf("Expected a backfill from block 900, got %+v", backfills.Backfills)
+		}
+	})
+
 	t.Run("GetTransactions", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/transactions?address=0x123", nil)
+		req := httptest.NewRequest("GET", "/transactions?address="+walletChecksum, nil)
 		w := httptest.NewRecorder()
 
 		server.handleGetTransactions(w, req)
@@ -75,6 +287,361 @@ func TestServer(t *testing.T) {
 		}
 	})
 
+	t.Run("Unsubscribe", func(t *testing.T) {
+		address := "0xdbf03b407c01e7cd3cbea99509d93f8dddc8c6fb"
+		body := []byte(`{"address": "` + address + `", "label": "cold wallet", "tags": ["treasury"]}`)
+		req := httptest.NewRequest("POST", "/subscribe", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		server.handleSubscribe(w, req)
+
+		req = httptest.NewRequest("GET", "/subscriptions", nil)
+		w = httptest.NewRecorder()
+		server.handleGetSubscriptions(w, req)
+
+		var subs GetSubscriptionsResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &subs)
+		found := false
+		for _, sub := range subs.Subscriptions {
+			found = found || (sub.Address == "0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB" && sub.Label == "cold wallet")
+		}
+		if !found {
+			t.Errorf("Expected the labelled subscription, got %+v", subs.Subscriptions)
+		}
+
+		for _, expected := range []bool{true, false} {
+			body = []byte(`{"address": "` + address + `", "purge": true}`)
+			req = httptest.NewRequest("POST", "/unsubscribe", bytes.NewBuffer(body))
+			w = httptest.NewRecorder()
+			server.handleUnsubscribe(w, req)
+
+			var resp UnsubscribeResponse
+			_ = json.Unmarshal(w.Body.Bytes(), &resp)
+			if resp.Success != expected {
+				t.Errorf("Expected unsubscribe success %v, got %+v", expected, resp)
+			}
+		}
+
+		req = httptest.NewRequest("POST", "/unsubscribe", bytes.NewBufferString(`{"address": "0x123"}`))
+		w = httptest.NewRecorder()
+		server.handleUnsubscribe(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status BadRequest, got %v", w.Code)
+		}
+	})
+
+	t.Run("InvalidAddress", func(t *testing.T) {
+		for _, address := range []string{"0x123", "vitalik.eth", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD"} {
+			body, _ := json.Marshal(SubscribeRequest{Address: address})
+			req := httptest.NewRequest("POST", "/subscribe", bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+
+			server.handleSubscribe(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status BadRequest subscribing %s, got %v", address, w.Code)
+			}
+
+			req = httptest.NewRequest("GET", "/transactions?address="+address, nil)
+			w = httptest.NewRecorder()
+
+			server.handleGetTransactions(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status BadRequest for transactions of %s, got %v", address, w.Code)
+			}
+		}
+	})
+
+	t.Run("GetTransactionsByStatus", func(t *testing.T) {
+		mockParser.transactions[wallet] = []types.ParsedTransaction{
+			{Hash: "0x1", Status: types.StatusPending},
+			{Hash: "0x2", From: wallet, Status: types.StatusFinalized},
+		}
+
+		req := httptest.NewRequest("GET", "/transactions?address="+walletChecksum+"&status=finalized", nil)
+		w := httptest.NewRecorder()
+
+		server.handleGetTransactions(w, req)
+
+		var resp GetTransactionsResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.Transactions) != 1 || resp.Transactions[0].Hash != "0x2" {
+			t.Errorf("Expected only the finalized transaction, got %+v", resp.Transactions)
+		}
+		if resp.Address != walletChecksum || resp.Transactions[0].From != walletChecksum {
+			t.Errorf("Expected checksummed addresses, got %s and %+v", resp.Address, resp.Transactions)
+		}
+
+		req = httptest.NewRequest("GET", "/transactions?address="+walletChecksum+"&status=settled", nil)
+		w = httptest.NewRecorder()
+
+		server.handleGetTransactions(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status BadRequest, got %v", w.Code)
+		}
+	})
+
+	t.Run("GetTransactionsPage", func(t *testing.T) {
+		mockParser.transactions[wallet] = []types.ParsedTransaction{
+			{Hash: "0x1", From: wallet, Value: "0x1", BlockNumber: 100, Timestamp: 5},
+			{Hash: "0x2", From: wallet, Value: "0x2", BlockNumber: 101, Timestamp: 6},
+		}
+
+		req := httptest.NewRequest("GET", "/transactions?address="+wallet+
+			"&limit=1&order=desc&direction=out&fromBlock=100&toBlock=200&fromTime=5&toTime=6&minValue=1&maxValue=0x10&cursor=150:0xabc", nil)
+		w := httptest.NewRecorder()
+
+		server.handleGetTransactions(w, req)
+
+		query := mockParser.lastQuery
+		if query.Address != wallet || query.Limit != 1 || query.Order != types.SortDesc || query.Direction != types.DirectionOut ||
+			query.FromBlock != 100 || query.ToBlock != 200 || query.FromTime != 5 || query.ToTime != 6 ||
+			query.MinValue.Int64() != 1 || query.MaxValue.Int64() != 16 || query.After == nil || query.After.BlockNumber != 150 {
+			t.Errorf("Unexpected query %+v", query)
+		}
+
+		var resp GetTransactionsResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.Transactions) != 1 || resp.NextCursor != "100:0x1" {
+			t.Errorf("Expected one transaction and a next cursor, got %+v", resp)
+		}
+
+		// Without a limit a default page size applies
+		w = httptest.NewRecorder()
+		server.handleGetTransactions(w, httptest.NewRequest("GET", "/transactions?address="+wallet, nil))
+		if mockParser.lastQuery.Limit != defaultPageSize || mockParser.lastQuery.Order != types.SortAsc {
+			t.Errorf("Expected the default page, got %+v", mockParser.lastQuery)
+		}
+
+		for _, params := range []string{"limit=0", "limit=1001", "order=up", "direction=both", "fromBlock=-1", "minValue=abc", "maxValue=-5", "cursor=abc"} {
+			w := httptest.NewRecorder()
+			server.handleGetTransactions(w, httptest.NewRequest("GET", "/transactions?address="+wallet+"&"+params, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status BadRequest for %s, got %v", params, w.Code)
+			}
+		}
+	})
+
+	t.Run("GetTransactionByHash", func(t *testing.T) {
+		hash := "0x" + strings.Repeat("ab", 32)
+		mockParser.transactions[wallet] = []types.ParsedTransaction{{Hash: hash, From: wallet, BlockNumber: 100}}
+
+		req := httptest.NewRequest("GET", "/transactions/"+strings.ToUpper(hash[2:]), nil)
+		req.SetPathValue("hash", "0x"+strings.ToUpper(hash[2:]))
+		w := httptest.NewRecorder()
+		server.handleGetTransaction(w, req)
+
+		var lookup types.TransactionLookup
+		_ = json.Unmarshal(w.Body.Bytes(), &lookup)
+		if w.Code != http.StatusOK || lookup.Transaction.Hash != hash || !lookup.Indexed ||
+			len(lookup.Addresses) != 1 || lookup.Addresses[0] != walletChecksum {
+			t.Errorf("Expected the stored transaction with its checksummed address, got %v %+v", w.Code, lookup)
+		}
+
+		for hash, code := range map[string]int{
+			"0x1234":                        http.StatusBadRequest,
+			"0x" + strings.Repeat("cd", 32): http.StatusNotFound,
+		} {
+			req := httptest.NewRequest("GET", "/transactions/"+hash, nil)
+			req.SetPathValue("hash", hash)
+			w := httptest.NewRecorder()
+			server.handleGetTransaction(w, req)
+			if w.Code != code {
+				t.Errorf("Expected status %d for %s, got %v", code, hash, w.Code)
+			}
+		}
+
+		mockParser.lookupErr = errors.New("connection refused")
+		defer func() { mockParser.lookupErr = nil }()
+		req = httptest.NewRequest("GET", "/transactions/"+hash, nil)
+		req.SetPathValue("hash", hash)
+		w = httptest.NewRecorder()
+		server.handleGetTransaction(w, req)
+		if w.Code != http.StatusBadGateway {
+			t.Errorf("Expected status Bad Gateway when the node fails, got %v", w.Code)
+		}
+	})
+
+	t.Run("GetBlock", func(t *testing.T) {
+		mockParser.currentBlock = 150
+		mockParser.transactions[wallet] = []types.ParsedTransaction{{Hash: "0x1", To: wallet, BlockNumber: 120}}
+
+		for number, code := range map[string]int{"120": http.StatusOK, "200": http.StatusNotFound, "-1": http.StatusBadRequest, "latest": http.StatusBadRequest} {
+			req := httptest.NewRequest("GET", "/blocks/"+number, nil)
+			req.SetPathValue("number", number)
+			w := httptest.NewRecorder()
+			server.handleGetBlock(w, req)
+			if w.Code != code {
+				t.Errorf("Expected status %d for block %s, got %v", code, number, w.Code)
+			}
+			if code != http.StatusOK {
+				continue
+			}
+
+			var block types.BlockLookup
+			_ = json.Unmarshal(w.Body.Bytes(), &block)
+			if block.Number != 120 || len(block.Transactions) != 1 || block.Transactions[0].Transaction.To != walletChecksum {
+				t.Errorf("Expected the stored transaction of block 120, got %+v", block)
+			}
+		}
+		mockParser.currentBlock = 0
+	})
+
+	t.Run("GetInternalTransactions", func(t *testing.T) {
+		mockParser.internal[wallet] = []types.InternalTransaction{
+			{TxHash: "0xabc", TraceAddress: "0", Type: "call", From: "0xsafe", To: wallet, Value: "0xde0b6b3a7640000", Status: types.StatusFinalized},
+			{TxHash: "0xdef", TraceAddress: "1.0", Type: "call", From: "0xsafe", To: wallet, Value: "0x1", Status: types.StatusPending},
+		}
+		req := httptest.NewRequest("GET", "/internal-transactions?address="+walletChecksum+"&status=finalized", nil)
+		w := httptest.NewRecorder()
+
+		server.handleGetInternalTransactions(w, req)
+
+		var resp GetInternalTransactionsResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.InternalTransactions) != 1 || resp.InternalTransactions[0].TxHash != "0xabc" {
+			t.Errorf("Expected the finalized internal transaction, got %+v", resp.InternalTransactions)
+		}
+	})
+
+	t.Run("GetTokenTransfers", func(t *testing.T) {
+		mockParser.transfers[wallet] = []types.TokenTransfer{
+			{TxHash: "0xabc", Token: "0xdac17f958d2ee523a2206206994597c13d831ec7", To: wallet, Amount: "1000000", Status: types.StatusPending},
+			{TxHash: "0xdef", Token: "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", From: wallet, Amount: "5", Status: types.StatusPending},
+		}
+		req := httptest.NewRequest("GET", "/token-transfers?address="+walletChecksum+"&token=0xDAC17F958D2EE523A2206206994597C13D831EC7", nil)
+		w := httptest.NewRecorder()
+
+		server.handleGetTokenTransfers(w, req)
+
+		var resp GetTokenTransfersResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.Transfers) != 1 || resp.Transfers[0].Amount != "1000000" {
+			t.Errorf("Expected the USDT transfer, got %+v", resp.Transfers)
+		}
+	})
+
+	t.Run("GetNFTTransfers", func(t *testing.T) {
+		mockParser.nfts[wallet] = []types.NFTTransfer{
+			{TxHash: "0xabc", Contract: "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d", Standard: types.StandardERC721, To: wallet, TokenID: "42", Amount: "1"},
+			{TxHash: "0xdef", Contract: "0x76be3b62873462d2142405439777e971754e8e77", Standard: types.StandardERC1155, From: wallet, TokenID: "7", Amount: "3"},
+		}
+
+		req := httptest.NewRequest("GET", "/nft-transfers?address="+walletChecksum+"&contract=0xBC4CA0EDA7647A8AB7C2061C2E118A18A936F13D", nil)
+		w := httptest.NewRecorder()
+		server.handleGetNFTTransfers(w, req)
+
+		var transfers GetNFTTransfersResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &transfers)
+		if len(transfers.Transfers) != 1 || transfers.Transfers[0].TokenID != "42" {
+			t.Errorf("Expected the ERC-721 transfer, got %+v", transfers.Transfers)
+		}
+
+		req = httptest.NewRequest("GET", "/nft-holdings?address="+walletChecksum, nil)
+		w = httptest.NewRecorder()
+		server.handleGetNFTHoldings(w, req)
+
+		var holdings GetNFTHoldingsResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &holdings)
+		if len(holdings.Holdings) != 1 || holdings.Holdings[0].TokenID != "42" {
+			t.Errorf("Expected one holding, got %+v", holdings.Holdings)
+		}
+
+		req = httptest.NewRequest("GET", "/nft-holdings", nil)
+		w = httptest.NewRecorder()
+		server.handleGetNFTHoldings(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status BadRequest, got %v", w.Code)
+		}
+	})
+
+	t.Run("GetReorgs", func(t *testing.T) {
+		mockParser.reorgs = []types.ReorgEvent{{CommonAncestor: 99, OldHead: 101, Depth: 2}}
+		req := httptest.NewRequest("GET", "/reorgs", nil)
+		w := httptest.NewRecorder()
+
+		server.handleGetReorgs(w, req)
+
+		var resp GetReorgsResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.Reorgs) != 1 || resp.Reorgs[0].CommonAncestor != 99 {
+			t.Errorf("Expected the reorg event, got %+v", resp.Reorgs)
+		}
+	})
+
+	t.Run("GetFailedBlocks", func(t *testing.T) {
+		mockParser.failedBlocks = []types.FailedBlock{{Number: 105, Attempts: 2, LastError: "timeout"}}
+		req := httptest.NewRequest("GET", "/failed-blocks", nil)
+		w := httptest.NewRecorder()
+
+		server.handleGetFailedBlocks(w, req)
+
+		var resp GetFailedBlocksResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.FailedBlocks) != 1 || resp.FailedBlocks[0].Attempts != 2 {
+			t.Errorf("Expected the failed block, got %+v", resp.FailedBlocks)
+		}
+	})
+
+	t.Run("SubscribeWithWebhook", func(t *testing.T) {
+		const other = "0x00000000000000000000000000000000000000aa"
+
+		body, _ := json.Marshal(SubscribeRequest{Address: other, WebhookURL: "ftp://example.com/hook"})
+		w := httptest.NewRecorder()
+		server.handleSubscribe(w, httptest.NewRequest("POST", "/subscribe", bytes.NewBuffer(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status Bad Request for a non-http webhook, got %v", w.Code)
+		}
+
+		body, _ = json.Marshal(SubscribeRequest{Address: other, WebhookURL: "https://example.com/hook"})
+		w = httptest.NewRecorder()
+		server.handleSubscribe(w, httptest.NewRequest("POST", "/subscribe", bytes.NewBuffer(body)))
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status OK, got %v", w.Code)
+		}
+		if mockParser.webhooks[other] != "https://example.com/hook" {
+			t.Errorf("Expected webhook to be passed to the parser, got %q", mockParser.webhooks[other])
+		}
+
+		// The signing secret is returned once and kept out of listings
+		var resp SubscribeResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if !strings.HasPrefix(resp.WebhookSecret, webhookSecretPrefix) || mockParser.secrets[other] != resp.WebhookSecret {
+			t.Errorf("Expected a webhook secret passed to the parser, got %+v", resp)
+		}
+		w = httptest.NewRecorder()
+		server.handleGetSubscriptions(w, httptest.NewRequest("GET", "/subscriptions", nil))
+		if strings.Contains(w.Body.String(), resp.WebhookSecret) {
+			t.Errorf("Expected the webhook secret not to be listed, got %s", w.Body.String())
+		}
+	})
+
+	t.Run("GetWebhookDeliveries", func(t *testing.T) {
+		mockParser.deliveries = []types.WebhookDelivery{
+			{ID: 1, Address: wallet, Status: types.DeliveryPending},
+			{ID: 2, Address: wallet, Status: types.DeliveryDead, Attempts: 8, LastError: "webhook answered 500 Internal Server Error", Secret: "whsec_dead"},
+		}
+
+		w := httptest.NewRecorder()
+		server.handleGetWebhookDeliveries(w, httptest.NewRequest("GET", "/webhook-deliveries?status=dead", nil))
+
+		var resp GetWebhookDeliveriesResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.Deliveries) != 1 || resp.Deliveries[0].ID != 2 || resp.Deliveries[0].Address != walletChecksum {
+			t.Errorf("Expected the dead delivery with a checksummed address, got %+v", resp.Deliveries)
+		}
+		if strings.Contains(w.Body.String(), "whsec_dead") {
+			t.Errorf("Expected the signing secret not to be listed, got %s", w.Body.String())
+		}
+
+		w = httptest.NewRecorder()
+		server.handleGetWebhookDeliveries(w, httptest.NewRequest("GET", "/webhook-deliveries?status=done", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status Bad Request for an unknown status, got %v", w.Code)
+		}
+	})
+
 	t.Run("GetCurrentBlock", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/current-block", nil)
 		w := httptest.NewRecorder()
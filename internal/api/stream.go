@@ -0,0 +1,385 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ethparser/internal/websocket"
+	"ethparser/pkg/types"
+)
+
+const (
+	// maxStreamAddresses bounds the addresses a single stream may follow
+	maxStreamAddresses = 100
+
+	// streamBuffer is how many live events a slow stream may fall behind
+	// before it is closed and has to resume from its cursor
+	streamBuffer = 256
+
+	// streamHeartbeat is how often an idle stream is pinged to keep proxies from closing it
+	streamHeartbeat = 15 * time.Second
+
+	// replayPageSize is how many stored transactions of an address a replay reads at once
+	replayPageSize = 500
+)
+
+// StreamEvent is a transaction sent on a stream. Cursor is the block number of
+// the transaction; a client resuming from it receives that block again, so
+// transactions are delivered at least once and may be deduplicated by hash.
+type StreamEvent struct {
+	Event       string                  `json:"event"`
+	Cursor      int64                   `json:"cursor"`
+	Transaction types.ParsedTransaction `json:"transaction"`
+}
+
+// streamHub fans out live transactions to the connected streams
+type streamHub struct {
+	mu      sync.Mutex
+	streams map[*stream]struct{}
+}
+
+// stream is one connected client following a set of addresses
+type stream struct {
+	addresses map[string]bool
+	events    chan types.ParsedTransaction
+
+	// lagged is closed when the stream fell too far behind and was dropped
+	lagged chan struct{}
+}
+
+func newStreamHub() *streamHub {
+	return &streamHub{
+		streams: make(map[*stream]struct{}),
+	}
+}
+
+func (h *streamHub) add(addresses map[string]bool) *stream {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st := &stream{
+		addresses: addresses,
+		events:    make(chan types.ParsedTransaction, streamBuffer),
+		lagged:    make(chan struct{}),
+	}
+	h.streams[st] = struct{}{}
+	return st
+}
+
+func (h *streamHub) remove(st *stream) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.streams, st)
+}
+
+// publish passes tx to every stream following one of its addresses without
+// blocking; a stream whose buffer is full is dropped
+func (h *streamHub) publish(tx types.ParsedTransaction) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for st := range h.streams {
+		if !st.matches(tx) {
+			continue
+		}
+		select {
+		case st.events <- tx:
+		default:
+			delete(h.streams, st)
+			close(st.lagged)
+		}
+	}
+}
+
+func (st *stream) matches(tx types.ParsedTransaction) bool {
+	return st.addresses[types.NormalizeAddress(tx.From)] ||
+		st.addresses[types.NormalizeAddress(tx.To)] ||
+		st.addresses[types.NormalizeAddress(tx.ContractAddress)]
+}
+
+// streamWriter sends events to a client over SSE or WebSocket
+type streamWriter interface {
+	send(event StreamEvent) error
+	sendError(message string) error
+	ping() error
+}
+
+// Publish streams a newly stored transaction to the connected clients following
+// one of its addresses. It never blocks and is meant to be registered as a
+// parser transaction listener.
+func (s *Server) Publish(tx types.ParsedTransaction) {
+	s.streams.publish(tx)
+}
+
+// handleStream streams the transactions of one or more addresses as they are
+// parsed, over WebSocket when the request asks for an upgrade and as
+// Server-Sent Events otherwise
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	addresses, ok := streamAddresses(w, r)
+	if !ok {
+		return
+	}
+
+	// EventSource reconnects send the last received id as Last-Event-ID
+	cursor := int64(-1)
+	rawCursor := r.URL.Query().Get("cursor")
+	if rawCursor == "" {
+		rawCursor = r.Header.Get("Last-Event-ID")
+	}
+	if rawCursor != "" {
+		var err error
+		cursor, err = strconv.ParseInt(rawCursor, 10, 64)
+		if err != nil || cursor < 0 {
+			log.Printf("Invalid stream cursor: %s", rawCursor)
+			writeError(w, r, http.StatusBadRequest, "Cursor must be a non-negative block number")
+			return
+		}
+	}
+
+	// Tenants only stream the addresses they subscribed
+	tenant := tenantOf(r)
+	subscribed := make(map[string]bool)
+	for _, sub := range s.parser.ListSubscriptions(tenant) {
+		subscribed[types.NormalizeAddress(sub.Address)] = true
+	}
+	for address := range addresses {
+		if !subscribed[types.NormalizeAddress(address)] {
+			log.Printf("Stream of unsubscribed address %s by tenant %s", address, tenant)
+			writeError(w, r, http.StatusForbidden, "Not subscribed to "+address)
+			return
+		}
+	}
+
+	var writer streamWriter
+	var closed <-chan struct{}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		// Browsers do not apply CORS to WebSocket, so check their origin here
+		if origin := r.Header.Get("Origin"); origin != "" && !s.allowedOrigin(origin) {
+			log.Printf("Stream upgrade from disallowed origin %s", origin)
+			writeError(w, r, http.StatusForbidden, "Origin not allowed")
+			return
+		}
+
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			log.Printf("Failed to upgrade stream: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		// Reading handles pings and notices when the client goes away
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		writer, closed = wsStreamWriter{conn}, done
+	} else {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, r, http.StatusInternalServerError, "Streaming not supported")
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		writer, closed = sseStreamWriter{w, flusher}, r.Context().Done()
+	}
+
+	// Register before replaying so nothing stored in between is missed
+	st := s.streams.add(addresses)
+	defer s.streams.remove(st)
+	log.Printf("Stream opened for %d addresses from cursor %d", len(addresses), cursor)
+
+	replayed := make(map[string]bool)
+	var lastReplayed int64 = -1
+	if cursor >= 0 {
+		err := s.replay(tenant, addresses, cursor, func(tx types.ParsedTransaction) error {
+			replayed[tx.Hash] = true
+			lastReplayed = tx.BlockNumber
+			return writer.send(newStreamEvent(tx))
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case tx := <-st.events:
+			// Skip what the replay already sent
+			if tx.BlockNumber <= lastReplayed && replayed[tx.Hash] {
+				continue
+			}
+			if err := writer.send(newStreamEvent(tx)); err != nil {
+				return
+			}
+		case <-st.lagged:
+			log.Printf("Stream fell behind, closing it")
+			_ = writer.sendError("stream fell behind, reconnect with the last cursor")
+			return
+		case <-heartbeat.C:
+			if err := writer.ping(); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// replayHistory is the part of the history of an address a replay has read but not sent
+type replayHistory struct {
+	query types.TransactionQuery
+	page  []types.ParsedTransaction
+	done  bool // no page left to read
+}
+
+// replay sends the stored transactions of addresses from block cursor on,
+// oldest first and once each. Histories are read a page at a time and merged,
+// so a long history is never loaded at once.
+func (s *Server) replay(tenant string, addresses map[string]bool, cursor int64, send func(types.ParsedTransaction) error) error {
+	histories := make([]*replayHistory, 0, len(addresses))
+	for address := range addresses {
+		histories = append(histories, &replayHistory{
+			query: types.TransactionQuery{Address: address, Tenant: tenant, FromBlock: cursor, Limit: replayPageSize},
+		})
+	}
+
+	last := ""
+	for {
+		var oldest *replayHistory
+		for _, h := range histories {
+			if len(h.page) == 0 && !h.done {
+				page := s.parser.QueryTransactions(h.query)
+				h.page, h.query.After, h.done = page.Transactions, page.Next, page.Next == nil
+			}
+			if len(h.page) > 0 && (oldest == nil || types.CursorOf(h.page[0]).Compare(oldest.page[0]) < 0) {
+				oldest = h
+			}
+		}
+		if oldest == nil {
+			return nil
+		}
+
+		tx := oldest.page[0]
+		oldest.page = oldest.page[1:]
+		// A transaction between two streamed addresses is in both histories
+		if tx.Hash == last {
+			continue
+		}
+		last = tx.Hash
+		if err := send(tx); err != nil {
+			return err
+		}
+	}
+}
+
+// streamAddresses returns the normalized addresses of the `address` query
+// parameters, each of which may hold a comma-separated list, writing a 400
+// error when there are none, too many or an invalid one
+func streamAddresses(w http.ResponseWriter, r *http.Request) (map[string]bool, bool) {
+	addresses := make(map[string]bool)
+	for _, param := range r.URL.Query()["address"] {
+		for _, address := range strings.Split(param, ",") {
+			parsed, err := types.ParseAddress(strings.TrimSpace(address))
+			if err != nil {
+				log.Printf("Invalid address parameter: %v", err)
+				writeError(w, r, http.StatusBadRequest, "Address must be 0x followed by 40 hex digits, with a valid EIP-55 checksum if mixed case")
+				return nil, false
+			}
+			addresses[parsed.Hex()] = true
+		}
+	}
+
+	if len(addresses) == 0 {
+		log.Printf("Missing address parameter")
+		writeError(w, r, http.StatusBadRequest, "Address is required")
+		return nil, false
+	}
+	if len(addresses) > maxStreamAddresses {
+		log.Printf("Too many stream addresses: %d", len(addresses))
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("At most %d addresses can be streamed at once", maxStreamAddresses))
+		return nil, false
+	}
+	return addresses, true
+}
+
+func newStreamEvent(tx types.ParsedTransaction) StreamEvent {
+	return StreamEvent{
+		Event:       "transaction",
+		Cursor:      tx.BlockNumber,
+		Transaction: checksumTransaction(tx),
+	}
+}
+
+type sseStreamWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (sw sseStreamWriter) send(event StreamEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(sw.w, "id: %d\nevent: %s\ndata: %s\n\n", event.Cursor, event.Event, data); err != nil {
+		return err
+	}
+	sw.flusher.Flush()
+	return nil
+}
+
+func (sw sseStreamWriter) sendError(message string) error {
+	data, _ := json.Marshal(map[string]string{"event": "error", "error": message})
+	if _, err := fmt.Fprintf(sw.w, "event: error\ndata: %s\n\n", data); err != nil {
+		return err
+	}
+	sw.flusher.Flush()
+	return nil
+}
+
+func (sw sseStreamWriter) ping() error {
+	if _, err := fmt.Fprint(sw.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	sw.flusher.Flush()
+	return nil
+}
+
+type wsStreamWriter struct {
+	conn *websocket.Conn
+}
+
+func (ww wsStreamWriter) send(event StreamEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return ww.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (ww wsStreamWriter) sendError(message string) error {
+	data, _ := json.Marshal(map[string]string{"event": "error", "error": message})
+	return ww.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (ww wsStreamWriter) ping() error {
+	return ww.conn.WriteMessage(websocket.PingMessage, nil)
+}
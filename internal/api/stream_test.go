@@ -0,0 +1,209 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"ethparser/internal/websocket"
+	"ethparser/pkg/types"
+)
+
+// readSSE returns the id and data of the next event on an SSE stream, skipping comments
+func readSSE(t *testing.T, reader *bufio.Reader) (string, StreamEvent) {
+	t.Helper()
+
+	var id string
+	var event StreamEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event.Event != "":
+			return id, event
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatalf("Invalid event data %q: %v", line, err)
+			}
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	mockParser := NewMockParser()
+	config := DefaultConfig()
+	config.AdminKey = "admin-secret"
+	keys := newKeyStore()
+	server := NewServer(mockParser, keys, config)
+	httpServer := httptest.NewServer(server.Handler())
+	defer httpServer.Close()
+
+	key := newTenantKey(t, keys, "acme")
+	mockParser.SubscribeWith(wallet, types.SubscribeOptions{Tenant: "acme"})
+	mockParser.transactions[wallet] = []types.ParsedTransaction{
+		{Hash: "0x100", From: wallet, BlockNumber: 100},
+		{Hash: "0x101", To: wallet, BlockNumber: 101},
+	}
+
+	t.Run("SSEResume", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", httpServer.URL+"/v1/stream?address="+walletChecksum, nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.Header.Set("Last-Event-ID", "101")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("Expected an event stream, got %q", ct)
+		}
+		reader := bufio.NewReader(resp.Body)
+
+		// Only the cursor block is replayed
+		id, event := readSSE(t, reader)
+		if id != "101" || event.Transaction.Hash != "0x101" || event.Transaction.To != walletChecksum {
+			t.Errorf("Expected replay of block 101, got id %s and %+v", id, event)
+		}
+
+		// A transaction stored during the replay is not sent twice
+		server.Publish(types.ParsedTransaction{Hash: "0x101", To: wallet, BlockNumber: 101})
+		server.Publish(types.ParsedTransaction{Hash: "0xother", From: "0x00000000000000000000000000000000000000aa", BlockNumber: 102})
+		server.Publish(types.ParsedTransaction{Hash: "0x102", From: wallet, BlockNumber: 102})
+
+		id, event = readSSE(t, reader)
+		if id != "102" || event.Transaction.Hash != "0x102" {
+			t.Errorf("Expected live transaction 0x102, got id %s and %+v", id, event)
+		}
+	})
+
+	t.Run("WebSocket", func(t *testing.T) {
+		url := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/v1/stream?address=" + wallet + "&cursor=0&apiKey=" + key
+		conn, err := websocket.Dial(url, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		var hashes []string
+		for len(hashes) < 2 {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var event StreamEvent
+			_ = json.Unmarshal(data, &event)
+			hashes = append(hashes, event.Transaction.Hash)
+		}
+		if strings.Join(hashes, ",") != "0x100,0x101" {
+			t.Errorf("Expected the history from the cursor in order, got %v", hashes)
+		}
+
+		server.Publish(types.ParsedTransaction{Hash: "0x103", ContractAddress: wallet, BlockNumber: 103})
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var event StreamEvent
+		_ = json.Unmarshal(data, &event)
+		if event.Cursor != 103 || event.Transaction.Hash != "0x103" {
+			t.Errorf("Expected live transaction 0x103, got %+v", event)
+		}
+	})
+
+	t.Run("InvalidRequest", func(t *testing.T) {
+		for _, query := range []string{"", "?address=0x123", "?address=" + wallet + "&cursor=-1", "?address=" + wallet + "&cursor=abc"} {
+			w := httptest.NewRecorder()
+			server.handleStream(w, httptest.NewRequest("GET", "/stream"+query, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status Bad Request for %q, got %v", query, w.Code)
+			}
+		}
+	})
+
+	t.Run("NotSubscribed", func(t *testing.T) {
+		resp, err := http.Get(httpServer.URL + "/v1/stream?address=0xdbf03b407c01e7cd3cbea99509d93f8dddc8c6fb&apiKey=" + key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected status Forbidden, got %v", resp.StatusCode)
+		}
+	})
+
+	t.Run("DisallowedOrigin", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", httpServer.URL+"/v1/stream?address="+wallet+"&apiKey="+key, nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		req.Header.Set("Origin", "https://evil.example.com")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected status Forbidden for a WebSocket from another origin, got %v", resp.StatusCode)
+		}
+	})
+
+	t.Run("ReplayPaged", func(t *testing.T) {
+		other := "0x00000000000000000000000000000000000000bb"
+		mockParser := NewMockParser()
+		for i := 0; i < 2*replayPageSize+10; i++ {
+			tx := types.ParsedTransaction{Hash: fmt.Sprintf("0x%04x", i), From: wallet, BlockNumber: int64(i)}
+			mockParser.transactions[wallet] = append(mockParser.transactions[wallet], tx)
+			if i%100 == 0 {
+				tx.To = other
+				mockParser.transactions[other] = append(mockParser.transactions[other], tx)
+			}
+		}
+		mockParser.transactions[other] = append(mockParser.transactions[other],
+			types.ParsedTransaction{Hash: "0xffff", To: other, BlockNumber: 2*replayPageSize + 20})
+		server := NewServer(mockParser, newKeyStore(), config)
+
+		var blocks []int64
+		err := server.replay("acme", map[string]bool{wallet: true, other: true}, 0, func(tx types.ParsedTransaction) error {
+			blocks = append(blocks, tx.BlockNumber)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blocks) != 2*replayPageSize+11 || !slices.IsSorted(blocks) {
+			t.Errorf("Expected every transaction once in block order, got %d", len(blocks))
+		}
+		if mockParser.lastQuery.Limit != replayPageSize {
+			t.Errorf("Expected the replay to be paged, got limit %d", mockParser.lastQuery.Limit)
+		}
+	})
+
+	t.Run("SlowStreamDropped", func(t *testing.T) {
+		hub := newStreamHub()
+		st := hub.add(map[string]bool{wallet: true})
+		for i := 0; i <= streamBuffer; i++ {
+			hub.publish(types.ParsedTransaction{From: wallet})
+		}
+
+		select {
+		case <-st.lagged:
+		default:
+			t.Error("Expected a full stream to be dropped")
+		}
+		if len(hub.streams) != 0 {
+			t.Errorf("Expected the dropped stream to be removed, got %d streams", len(hub.streams))
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"ethparser/pkg/types"
+)
+
+const (
+	// maxBackfillAttempts is how many times a backfill tries a block before giving up
+	maxBackfillAttempts = 3
+
+	// backfillRetryDelay is the pause between attempts at the same block
+	backfillRetryDelay = time.Second
+
+	// backfillProgressInterval is how many blocks are scanned between progress logs
+	backfillProgressInterval = 100
+)
+
+// GetBackfills returns the backfill jobs of a tenant, of every tenant when it is empty
+func (p *EthParser) GetBackfills(tenant string) []types.BackfillJob {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	jobs := make([]types.BackfillJob, 0, len(p.backfills))
+	for _, job := range p.backfills {
+		if tenant == "" || job.Tenant == tenant {
+			jobs = append(jobs, *job)
+		}
+	}
+	return jobs
+}
+
+// startBackfill registers a job scanning fromBlock up to the scanned head for
+// the address a tenant subscribed and runs it in the background. The head can
+// be above the cursor while blocks wait for a retry, and the live parser will
+// not scan the blocks between them again. Later blocks are left to the live
+// parser, and storage drops any transaction both of them find.
+func (p *EthParser) startBackfill(tenant, address string, fromBlock int) {
+	p.mu.Lock()
+	job := &types.BackfillJob{
+		ID:           len(p.backfills) + 1,
+		Tenant:       tenant,
+		Address:      types.NormalizeAddress(address),
+		FromBlock:    fromBlock,
+		ToBlock:      max(p.storage.GetCurrentBlock(), p.scannedBlock),
+		CurrentBlock: fromBlock - 1,
+		Status:       types.BackfillRunning,
+		StartedAt:    time.Now().Unix(),
+	}
+	p.backfills = append(p.backfills, job)
+	p.mu.Unlock()
+
+	p.logger.Printf("Starting backfill %d for %s from block %d to %d", job.ID, job.Address, job.FromBlock, job.ToBlock)
+	go p.runBackfill(job)
+}
+
+// errUnsubscribed stops the backfill of an address that is no longer subscribed
+var errUnsubscribed = errors.New("address unsubscribed")
+
+func (p *EthParser) runBackfill(job *types.BackfillJob) {
+	match := func(address string) bool {
+		return strings.EqualFold(address, job.Address)
+	}
+
+	var failure error
+	p.fetchRange(job.FromBlock, job.ToBlock, p.fetchBlocksWithRetry, func(blockNum int, block *fetchedBlock, err error) bool {
+		if err != nil {
+			p.logger.Printf("Backfill %d failed at block %d: %v", job.ID, blockNum, err)
+			failure = err
+			return false
+		}
+		if _, ok := p.storage.GetSubscription(job.Tenant, job.Address); !ok {
+			p.logger.Printf("Backfill %d cancelled at block %d: %s was unsubscribed", job.ID, blockNum, job.Address)
+			failure = errUnsubscribed
+			return false
+		}
+
+		found := p.processBlock(block, blockNum, match, false)
+
+		p.mu.Lock()
+		job.CurrentBlock = blockNum
+		job.TransactionsFound += found
+		p.mu.Unlock()
+
+		if (blockNum-job.FromBlock+1)%backfillProgressInterval == 0 {
+			p.logger.Printf("Backfill %d progress: block %d of %d, %d transactions found",
+				job.ID, blockNum, job.ToBlock, job.TransactionsFound)
+		}
+		return true
+	})
+
+	p.finishBackfill(job, failure)
+}
+
+// fetchBlocksWithRetry fetches historical blocks, retrying the ones that failed
+func (p *EthParser) fetchBlocksWithRetry(from, to int) []fetchResult {
+	results := p.fetchBlocks(from, to)
+
+	for i := range results {
+		for attempt := 2; results[i].err != nil && attempt <= maxBackfillAttempts; attempt++ {
+			p.logger.Printf("Backfill attempt %d for block %d: %v", attempt, from+i, results[i].err)
+			time.Sleep(backfillRetryDelay)
+			results[i].block, results[i].err = p.fetchBlock(from + i)
+		}
+	}
+	return results
+}
+
+func (p *EthParser) finishBackfill(job *types.BackfillJob, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	job.FinishedAt = time.Now().Unix()
+	if errors.Is(err, errUnsubscribed) {
+		job.Status = types.BackfillCancelled
+		return
+	}
+	if err != nil {
+		job.Status = types.BackfillFailed
+		job.Error = err.Error()
+		return
+	}
+
+	job.Status = types.BackfillCompleted
+	p.logger.Printf("Backfill %d completed: %d transactions found", job.ID, job.TransactionsFound)
+}
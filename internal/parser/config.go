@@ -0,0 +1,65 @@
+package parser
+
+import "time"
+
+// DefaultConfirmations is the number of blocks on top of a transaction's block
+// before it is reported as confirmed
+const DefaultConfirmations = 12
+
+// DefaultWorkers is the number of block batches fetched concurrently
+const DefaultWorkers = 4
+
+// DefaultBatchSize is the number of blocks requested in one JSON-RPC batch
+const DefaultBatchSize = 10
+
+// DefaultPollInterval is how often the network head is polled without a head subscription
+const DefaultPollInterval = 5 * time.Second
+
+// Trace modes selecting how internal transactions are fetched
+const (
+	// TraceModeOff disables internal transaction indexing
+	TraceModeOff = ""
+
+	// TraceModeDebug uses debug_traceBlockByNumber with the callTracer (geth, erigon, reth)
+	TraceModeDebug = "debug"
+
+	// TraceModeParity uses trace_block (erigon, nethermind, reth)
+	TraceModeParity = "parity"
+)
+
+// Config holds the tunable parser settings
+type Config struct {
+	// Confirmations - blocks required on top of a transaction's block before it is confirmed
+	Confirmations int
+
+	// FromBlock - default block new subscriptions are backfilled from, 0 disables backfill
+	FromBlock int
+
+	// Workers - maximum number of concurrent block batch fetches
+	Workers int
+
+	// BatchSize - number of blocks requested in one JSON-RPC batch
+	BatchSize int
+
+	// PollInterval - how often the network head is polled while there is no head subscription
+	PollInterval time.Duration
+
+	// WSEndpoint - ws:// or wss:// endpoint for eth_subscribe("newHeads"), empty to only poll
+	WSEndpoint string
+
+	// TraceMode - how blocks are traced for internal transactions, TraceModeOff disables tracing
+	TraceMode string
+
+	// FollowDeployments - subscribe contracts deployed by subscribed addresses
+	FollowDeployments bool
+}
+
+// DefaultConfig returns the settings used when nothing is configured
+func DefaultConfig() Config {
+	return Config{
+		Confirmations: DefaultConfirmations,
+		Workers:       DefaultWorkers,
+		BatchSize:     DefaultBatchSize,
+		PollInterval:  DefaultPollInterval,
+	}
+}
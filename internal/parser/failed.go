@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"time"
+
+	"ethparser/pkg/types"
+)
+
+func (p *EthParser) GetFailedBlocks() []types.FailedBlock {
+	return p.storage.GetFailedBlocks()
+}
+
+// retryFailedBlocks parses the queued failed blocks again, removing the ones that
+// succeed. A retried block that does not fit between the blocks parsed around
+// it means the chain was reorganized since, which is handled like any reorg.
+func (p *EthParser) retryFailedBlocks() {
+	failed := p.storage.GetFailedBlocks()
+	if len(failed) == 0 {
+		return
+	}
+
+	p.logger.Printf("Retrying %d failed blocks", len(failed))
+	for _, fb := range failed {
+		block, err := p.fetchBlock(fb.Number)
+		if err != nil {
+			p.logger.Printf("Retry %d of block %d failed: %v", fb.Attempts+1, fb.Number, err)
+			p.storage.RecordBlockFailure(fb.Number, err.Error(), time.Now().Unix())
+			continue
+		}
+
+		parentHash, hasParent := p.blockHash(fb.Number - 1)
+		child, hasChild := p.recentBlock(fb.Number + 1)
+		if (hasParent && parentHash != block.ParentHash) || (hasChild && child.parentHash != block.Hash) {
+			p.logger.Printf("Retried block %d does not fit the stored chain", fb.Number)
+			if _, err := p.handleReorg(fb.Number + 1); err != nil {
+				p.logger.Printf("Failed to handle reorg at block %d: %v", fb.Number+1, err)
+			}
+			return
+		}
+
+		p.processBlock(block, fb.Number, p.storage.IsSubscribed, true)
+		p.rememberBlock(fb.Number, block)
+		p.storage.RemoveFailedBlock(fb.Number)
+		p.logger.Printf("Block %d parsed after %d failed attempts", fb.Number, fb.Attempts)
+	}
+}
+
+// advanceCursor moves the cursor to the highest block below which every block
+// has been parsed: the scanned head, or just below the lowest failed block
+func (p *EthParser) advanceCursor() {
+	contiguous := p.getScannedBlock()
+	for _, fb := range p.storage.GetFailedBlocks() {
+		if fb.Number <= contiguous {
+			contiguous = fb.Number - 1
+		}
+	}
+
+	if contiguous > p.storage.GetCurrentBlock() {
+		p.storage.SetCurrentBlock(contiguous)
+	}
+}
+
+func (p *EthParser) getScannedBlock() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.scannedBlock
+}
+
+func (p *EthParser) setScannedBlock(blockNum int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.scannedBlock = blockNum
+}
@@ -0,0 +1,154 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"ethparser/internal/rpc"
+	"ethparser/pkg/types"
+)
+
+// GetTransaction returns a transaction stored for the tenant, or looks it up on
+// the node when it was not indexed, e.g. because it predates the subscription
+func (p *EthParser) GetTransaction(tenant, hash string) (types.TransactionLookup, error) {
+	if lookup, ok := p.storage.GetTransaction(tenant, hash); ok {
+		return lookup, nil
+	}
+
+	p.logger.Printf("Transaction %s is not indexed, looking it up on the node", hash)
+	responses := p.callMany([]rpc.BatchRequest{
+		{Method: "eth_getTransactionByHash", Params: []interface{}{hash}},
+		{Method: "eth_getTransactionReceipt", Params: []interface{}{hash}},
+	})
+	if responses[0].Error != nil {
+		return types.TransactionLookup{}, fmt.Errorf("failed to get transaction %s: %w", hash, responses[0].Error)
+	}
+	if responses[0].Response.Result == nil {
+		return types.TransactionLookup{}, fmt.Errorf("transaction %s %w", hash, types.ErrNotFound)
+	}
+
+	var tx types.Transaction
+	data, err := json.Marshal(responses[0].Response.Result)
+	if err != nil {
+		return types.TransactionLookup{}, fmt.Errorf("failed to marshal transaction: %w", err)
+	}
+	if err := json.Unmarshal(data, &tx); err != nil {
+		return types.TransactionLookup{}, fmt.Errorf("failed to unmarshal transaction: %w", err)
+	}
+
+	// A transaction still in the mempool has no block yet
+	if tx.BlockNumber == "" {
+		parsedTx := p.parseTransaction(tx, 0, 0)
+		parsedTx.Status = types.StatusPending
+		return types.TransactionLookup{Transaction: parsedTx, Addresses: p.subscribedAddresses(tenant, parsedTx)}, nil
+	}
+
+	blockNum, err := parseQuantity(tx.BlockNumber)
+	if err != nil {
+		return types.TransactionLookup{}, fmt.Errorf("invalid transaction block number: %w", err)
+	}
+	timestamp, err := p.fetchBlockTimestamp(blockNum)
+	if err != nil {
+		return types.TransactionLookup{}, err
+	}
+
+	parsedTx := p.parseTransaction(tx, blockNum, timestamp)
+	if receipt := responses[1]; receipt.Error == nil && receipt.Response.Result != nil {
+		receipts, err := decodeReceipts([]interface{}{receipt.Response.Result}, &fetchedBlock{Block: types.Block{Hash: tx.BlockHash}})
+		if err != nil {
+			return types.TransactionLookup{}, err
+		}
+		applyReceipt(&parsedTx, receipts[0])
+	}
+	return types.TransactionLookup{Transaction: parsedTx, Addresses: p.subscribedAddresses(tenant, parsedTx)}, nil
+}
+
+// GetBlock returns the transactions of a recently parsed block stored for the
+// tenant, or fetches the block from the node: blocks the parser has not reached
+// yet, and blocks it did not parse or no longer keeps the header of, such as
+// blocks from before the service started
+func (p *EthParser) GetBlock(tenant string, number int) (types.BlockLookup, error) {
+	if header, ok := p.recentBlock(number); ok && number <= p.storage.GetCurrentBlock() {
+		return types.BlockLookup{
+			Number:       int64(number),
+			Hash:         header.hash,
+			ParentHash:   header.parentHash,
+			Timestamp:    header.timestamp,
+			Transactions: p.storage.GetBlockTransactions(tenant, number),
+			Indexed:      true,
+		}, nil
+	}
+
+	p.logger.Printf("Block %d is not indexed, fetching it from the node", number)
+	block, err := p.fetchBlock(number)
+	if err != nil {
+		return types.BlockLookup{}, err
+	}
+
+	receipts := make(map[string]Receipt, len(block.Receipts))
+	for _, receipt := range block.Receipts {
+		receipts[strings.ToLower(receipt.TransactionHash)] = receipt
+	}
+
+	lookup := types.BlockLookup{
+		Number:           int64(number),
+		Hash:             block.Hash,
+		ParentHash:       block.ParentHash,
+		Timestamp:        block.timestamp,
+		TransactionCount: len(block.Transactions),
+		Transactions:     make([]types.TransactionLookup, 0),
+	}
+	for _, tx := range block.Transactions {
+		parsedTx := p.parseTransaction(tx, number, block.timestamp)
+		if receipt, ok := receipts[strings.ToLower(tx.Hash)]; ok {
+			applyReceipt(&parsedTx, receipt)
+		}
+		if addresses := p.subscribedAddresses(tenant, parsedTx); len(addresses) > 0 {
+			lookup.Transactions = append(lookup.Transactions, types.TransactionLookup{Transaction: parsedTx, Addresses: addresses})
+		}
+	}
+	return lookup, nil
+}
+
+// subscribedAddresses returns the addresses a tenant subscribed, or any tenant
+// when it is empty, among the sender, recipient and created contract of a transaction
+func (p *EthParser) subscribedAddresses(tenant string, tx types.ParsedTransaction) []string {
+	addresses := make([]string, 0)
+	for _, address := range []string{tx.From, tx.To, tx.ContractAddress} {
+		if address == "" || !p.subscribedBy(tenant, address) {
+			continue
+		}
+		if len(addresses) == 0 || addresses[len(addresses)-1] != address {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+func (p *EthParser) subscribedBy(tenant, address string) bool {
+	if tenant == "" {
+		return p.storage.IsSubscribed(address)
+	}
+	_, ok := p.storage.GetSubscription(tenant, address)
+	return ok
+}
+
+// fetchBlockTimestamp returns the timestamp of a block without its transactions
+func (p *EthParser) fetchBlockTimestamp(blockNum int) (int64, error) {
+	resp, err := p.client.Call("eth_getBlockByNumber", []interface{}{fmt.Sprintf("0x%x", blockNum), false})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get block %d: %w", blockNum, err)
+	}
+
+	header, ok := resp.Result.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("unexpected block %d response", blockNum)
+	}
+	hex, _ := header["timestamp"].(string)
+	timestamp, err := parseQuantity(hex)
+	if err != nil {
+		return 0, fmt.Errorf("invalid block timestamp: %w", err)
+	}
+	return int64(timestamp), nil
+}
@@ -0,0 +1,195 @@
+package parser
+
+import (
+	"math/big"
+	"sort"
+	"strings"
+
+	"ethparser/pkg/types"
+)
+
+const (
+	// transferSingleTopic is the keccak256 hash of TransferSingle(address,address,address,uint256,uint256)
+	transferSingleTopic = "0xc3d58168c5ae7397731d063d5bbf3d657854427343f4c083240f7aacaa2d0f62"
+
+	// transferBatchTopic is the keccak256 hash of TransferBatch(address,address,address,uint256[],uint256[])
+	transferBatchTopic = "0x4a39dc06d4c0dbc64b70af90fd698a233a518aa5d07e595d983b8c0526c8f7fb"
+)
+
+func (p *EthParser) GetNFTTransfers(tenant, address string) []types.NFTTransfer {
+	return p.storage.GetNFTTransfers(tenant, address)
+}
+
+// GetNFTHoldings replays the indexed NFT transfers of an address in chain
+// order and returns the token ids it still holds. Tokens received before the
+// address was indexed are unknown, so sending one away leaves a negative
+// balance that is not reported.
+func (p *EthParser) GetNFTHoldings(tenant, address string) []types.NFTHolding {
+	address = types.NormalizeAddress(address)
+
+	balances := make(map[string]*big.Int)
+	holdings := make(map[string]types.NFTHolding)
+	for _, transfer := range p.storage.GetNFTTransfers(tenant, address) {
+		amount, ok := new(big.Int).SetString(transfer.Amount, 10)
+		if !ok {
+			continue
+		}
+
+		key := transfer.Contract + ":" + transfer.TokenID
+		balance, ok := balances[key]
+		if !ok {
+			balance = new(big.Int)
+			balances[key] = balance
+			holdings[key] = types.NFTHolding{Contract: transfer.Contract, Standard: transfer.Standard, TokenID: transfer.TokenID}
+		}
+
+		if transfer.To == address {
+			balance.Add(balance, amount)
+		}
+		if transfer.From == address {
+			balance.Sub(balance, amount)
+		}
+	}
+
+	result := make([]types.NFTHolding, 0, len(holdings))
+	for key, holding := range holdings {
+		if balances[key].Sign() <= 0 {
+			continue
+		}
+		holding.Amount = balances[key].String()
+		result = append(result, holding)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Contract != result[j].Contract {
+			return result[i].Contract < result[j].Contract
+		}
+		a, _ := new(big.Int).SetString(result[i].TokenID, 10)
+		b, _ := new(big.Int).SetString(result[j].TokenID, 10)
+		return a.Cmp(b) < 0
+	})
+	return result
+}
+
+// decodeNFTTransfers decodes an ERC-721 Transfer or an ERC-1155 TransferSingle
+// or TransferBatch log into one transfer per token id
+func decodeNFTTransfers(log Log) []types.NFTTransfer {
+	if log.Removed || len(log.Topics) != 4 {
+		return nil
+	}
+	logIndex, err := parseQuantity(log.LogIndex)
+	if err != nil {
+		return nil
+	}
+
+	base := types.NFTTransfer{
+		TxHash:   log.TransactionHash,
+		LogIndex: logIndex,
+		Contract: strings.ToLower(log.Address),
+	}
+
+	switch strings.ToLower(log.Topics[0]) {
+	case transferTopic:
+		// ERC-721 indexes the token id, which tells it apart from ERC-20
+		from, okFrom := topicAddress(log.Topics[1])
+		to, okTo := topicAddress(log.Topics[2])
+		tokenID, okID := wordInt(strings.TrimPrefix(log.Topics[3], "0x"))
+		if !okFrom || !okTo || !okID {
+			return nil
+		}
+
+		base.Standard = types.StandardERC721
+		base.From, base.To = from, to
+		base.TokenID = tokenID.String()
+		base.Amount = "1"
+		return []types.NFTTransfer{base}
+
+	case transferSingleTopic, transferBatchTopic:
+		operator, okOperator := topicAddress(log.Topics[1])
+		from, okFrom := topicAddress(log.Topics[2])
+		to, okTo := topicAddress(log.Topics[3])
+		words, okData := abiWords(log.Data)
+		if !okOperator || !okFrom || !okTo || !okData {
+			return nil
+		}
+
+		base.Standard = types.StandardERC1155
+		base.Operator, base.From, base.To = operator, from, to
+
+		var ids, values []*big.Int
+		if strings.ToLower(log.Topics[0]) == transferSingleTopic {
+			if len(words) != 2 {
+				return nil
+			}
+			id, okID := wordInt(words[0])
+			value, okValue := wordInt(words[1])
+			if !okID || !okValue {
+				return nil
+			}
+			ids, values = []*big.Int{id}, []*big.Int{value}
+		} else {
+			var okIDs, okValues bool
+			ids, okIDs = abiUintArray(words, 0)
+			values, okValues = abiUintArray(words, 1)
+			if !okIDs || !okValues || len(ids) != len(values) {
+				return nil
+			}
+		}
+
+		transfers := make([]types.NFTTransfer, len(ids))
+		for i := range ids {
+			transfers[i] = base
+			transfers[i].BatchIndex = i
+			transfers[i].TokenID = ids[i].String()
+			transfers[i].Amount = values[i].String()
+		}
+		return transfers
+	}
+	return nil
+}
+
+// abiWords splits ABI encoded data into its 32-byte words
+func abiWords(data string) ([]string, bool) {
+	hex := strings.TrimPrefix(data, "0x")
+	if len(hex)%64 != 0 {
+		return nil, false
+	}
+
+	words := make([]string, len(hex)/64)
+	for i := range words {
+		words[i] = hex[i*64 : (i+1)*64]
+	}
+	return words, true
+}
+
+// abiUintArray decodes a dynamic uint256[] whose byte offset is stored in words[slot]
+func abiUintArray(words []string, slot int) ([]*big.Int, bool) {
+	if slot >= len(words) {
+		return nil, false
+	}
+
+	offset, ok := wordInt(words[slot])
+	if !ok || !offset.IsInt64() || offset.Int64()%32 != 0 || offset.Int64()/32 >= int64(len(words)) {
+		return nil, false
+	}
+	start := int(offset.Int64() / 32)
+
+	length, ok := wordInt(words[start])
+	if !ok || !length.IsInt64() || length.Int64() > int64(len(words)-start-1) {
+		return nil, false
+	}
+
+	values := make([]*big.Int, length.Int64())
+	for i := range values {
+		if values[i], ok = wordInt(words[start+1+i]); !ok {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+func wordInt(word string) (*big.Int, bool) {
+	if word == "" {
+		return nil, false
+	}
+	return new(big.Int).SetString(word, 16)
+}
@@ -2,9 +2,12 @@ package parser
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"math/big"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"ethparser/internal/rpc"
@@ -12,17 +15,70 @@ import (
 	"ethparser/pkg/types"
 )
 
+const (
+	// minReconnectDelay is the first wait before reconnecting a dropped head subscription
+	minReconnectDelay = time.Second
+
+	// maxReconnectDelay caps the doubling reconnect wait
+	maxReconnectDelay = time.Minute
+)
+
 type EthParser struct {
 	client  rpc.RPCClient
-	storage *storage.MemoryStorage
+	storage storage.Storage
+	config  Config
 	logger  *log.Logger
+
+	mu             sync.RWMutex
+	recentBlocks   map[int]blockHeader
+	reorgs         []types.ReorgEvent
+	backfills      []*types.BackfillJob
+	latestBlock    int
+	finalizedBlock int
+	scannedBlock   int
+
+	// noBlockReceipts is set once the node turns out not to provide eth_getBlockReceipts
+	noBlockReceipts atomic.Bool
+
+	// listeners are called with every transaction stored from a newly parsed block
+	listeners []TransactionListener
+
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
-func NewEthParser(endpoint string, logger *log.Logger) *EthParser {
+// TransactionListener is notified of a transaction stored for a subscribed address
+type TransactionListener func(tx types.ParsedTransaction)
+
+func NewEthParser(client rpc.RPCClient, store storage.Storage, config Config, logger *log.Logger) *EthParser {
 	return &EthParser{
-		client:  rpc.NewClient(endpoint),
-		storage: storage.NewMemoryStorage(logger),
-		logger:  logger,
+		client:       client,
+		storage:      store,
+		config:       config,
+		logger:       logger,
+		recentBlocks: make(map[int]blockHeader),
+		stop:         make(chan struct{}),
+	}
+}
+
+// AddTransactionListener registers a listener called with every transaction found
+// by the live parser. Transactions found by backfills are not reported. Listeners
+// run on the parsing goroutine and must not block.
+func (p *EthParser) AddTransactionListener(listener TransactionListener) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.listeners = append(p.listeners, listener)
+}
+
+// notify passes a stored transaction to the registered listeners
+func (p *EthParser) notify(tx types.ParsedTransaction) {
+	p.mu.RLock()
+	listeners := p.listeners
+	p.mu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(tx)
 	}
 }
 
@@ -31,21 +87,103 @@ func (p *EthParser) GetCurrentBlock() int {
 }
 
 func (p *EthParser) Subscribe(address string) bool {
-	return p.storage.Subscribe(address)
+	return p.SubscribeWith(address, types.SubscribeOptions{})
 }
 
+// SubscribeFrom subscribes an address and, when fromBlock is positive, starts a
+// background backfill of its history from that block up to the current cursor
+func (p *EthParser) SubscribeFrom(address string, fromBlock int) bool {
+	return p.SubscribeWith(address, types.SubscribeOptions{FromBlock: &fromBlock})
+}
+
+// SubscribeWith subscribes an address for a tenant with a label and tags,
+// backfilling its history from opts.FromBlock, or the configured default block
+// when it is nil
+func (p *EthParser) SubscribeWith(address string, opts types.SubscribeOptions) bool {
+	sub := types.Subscription{
+		Tenant:        opts.Tenant,
+		Address:       types.NormalizeAddress(address),
+		Label:         opts.Label,
+		Tags:          opts.Tags,
+		WebhookURL:    opts.WebhookURL,
+		WebhookSecret: opts.WebhookSecret,
+		StartBlock:    p.config.FromBlock,
+		CreatedAt:     time.Now().Unix(),
+	}
+	if opts.FromBlock != nil {
+		sub.StartBlock = max(*opts.FromBlock, 0)
+	}
+
+	// The tenant sees the shared history of the address from where its own
+	// backfill starts, or from the next block without one
+	sub.VisibleFrom = sub.StartBlock
+	if sub.VisibleFrom == 0 {
+		sub.VisibleFrom = p.storage.GetCurrentBlock() + 1
+	}
+
+	if !p.storage.AddSubscription(sub) {
+		return false
+	}
+
+	if sub.StartBlock > 0 {
+		p.startBackfill(sub.Tenant, sub.Address, sub.StartBlock)
+	}
+	return true
+}
+
+// Unsubscribe stops observing an address for a tenant. Its history stays
+// queryable unless purge is set, and a running backfill of the tenant for it is
+// cancelled.
+func (p *EthParser) Unsubscribe(tenant, address string, purge bool) bool {
+	return p.storage.Unsubscribe(tenant, address, purge)
+}
+
+func (p *EthParser) ListSubscriptions(tenant string) []types.Subscription {
+	return p.storage.GetSubscriptions(tenant)
+}
+
+// GetWebhookDeliveries lists the webhook deliveries queued by the webhook dispatcher
+func (p *EthParser) GetWebhookDeliveries(tenant, status string) []types.WebhookDelivery {
+	return p.storage.GetWebhookDeliveries(tenant, status)
+}
+
+// GetTransactions returns the stored transactions of an address subscribed by
+// the default tenant
 func (p *EthParser) GetTransactions(address string) []types.ParsedTransaction {
-	return p.storage.GetTransactions(address)
+	return p.storage.GetTransactions(types.DefaultTenant, address)
+}
+
+func (p *EthParser) QueryTransactions(query types.TransactionQuery) types.TransactionPage {
+	return p.storage.QueryTransactions(query)
+}
+
+func (p *EthParser) GetReorgs() []types.ReorgEvent {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	reorgs := make([]types.ReorgEvent, len(p.reorgs))
+	copy(reorgs, p.reorgs)
+	return reorgs
 }
 
 func (p *EthParser) Start() error {
+	// Resume from the stored cursor when the storage survived a restart
+	if currentBlock := p.storage.GetCurrentBlock(); currentBlock > 0 {
+		p.logger.Printf("Resuming from stored block: %d", currentBlock)
+		go p.parseBlocks()
+		return nil
+	}
+
 	// Get latest block number first
 	resp, err := p.client.Call("eth_blockNumber", []interface{}{})
 	if err != nil {
 		return fmt.Errorf("failed to get latest block: %w", err)
 	}
 
-	latestBlock := hexToInt(resp.Result.(string))
+	latestBlock, err := rpc.ParseBlockNumber(resp.Result)
+	if err != nil {
+		return fmt.Errorf("failed to get latest block: %w", err)
+	}
 	p.logger.Printf("Starting from latest block: %d", latestBlock)
 
 	// Set as our starting point
@@ -56,119 +194,327 @@ func (p *EthParser) Start() error {
 	return nil
 }
 
+// Stop ends block parsing started by Start
+func (p *EthParser) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+// parseBlocks syncs on every new head notification when a WebSocket endpoint
+// is configured, and polls the network head while there is no subscription.
+// The poll also acts as a watchdog for a subscription that went silent, e.g.
+// over a half-open connection, by syncing when no head arrived for an interval.
 func (p *EthParser) parseBlocks() {
-	ticker := time.NewTicker(5 * time.Second)
+	interval := p.config.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	p.logger.Printf("Starting block parser...")
 
-	for range ticker.C {
-		currentBlock := p.GetCurrentBlock()
-		p.logger.Printf("Current block: %d", currentBlock)
+	var (
+		ws        *rpc.WSClient
+		heads     <-chan rpc.Header
+		reconnect <-chan time.Time
+		delay     = minReconnectDelay
+		lastHead  time.Time
+	)
+	if p.config.WSEndpoint != "" {
+		reconnect = time.After(0)
+	}
+	defer func() {
+		if ws != nil {
+			ws.Close()
+		}
+	}()
+
+	for {
+		select {
+		case <-p.stop:
+			p.logger.Printf("Block parser stopped")
+			return
+
+		case <-ticker.C:
+			if heads == nil {
+				p.syncBlocks()
+			} else if time.Since(lastHead) >= interval {
+				p.logger.Printf("No new head for %v, polling", time.Since(lastHead).Round(time.Second))
+				p.syncBlocks()
+			}
 
-		// Get latest block number
-		resp, err := p.client.Call("eth_blockNumber", []interface{}{})
-		if err != nil {
-			p.logger.Printf("Failed to get latest block: %v", err)
-			continue
+		case <-reconnect:
+			reconnect = nil
+			ws, heads = p.subscribeHeads()
+			if heads == nil {
+				reconnect = time.After(delay)
+				delay = min(delay*2, maxReconnectDelay)
+				continue
+			}
+			delay = minReconnectDelay
+			lastHead = time.Now()
+
+			// Catch up on anything missed while polling or disconnected
+			p.syncBlocks()
+
+		case head, ok := <-heads:
+			if !ok {
+				p.logger.Printf("Head subscription dropped (%v), polling until reconnected", ws.Err())
+				ws.Close()
+				ws, heads = nil, nil
+				reconnect = time.After(delay)
+				continue
+			}
+
+			p.logger.Printf("New head %s: %s", head.Number, head.Hash)
+			lastHead = time.Now()
+			p.syncBlocks()
 		}
+	}
+}
 
-		latestBlock := hexToInt(resp.Result.(string))
-		p.logger.Printf("Latest block from network: %d", latestBlock)
+// subscribeHeads connects to the WebSocket endpoint and subscribes to new heads
+func (p *EthParser) subscribeHeads() (*rpc.WSClient, <-chan rpc.Header) {
+	ws, err := rpc.DialWS(p.config.WSEndpoint)
+	if err != nil {
+		p.logger.Printf("Failed to connect to %s: %v", p.config.WSEndpoint, err)
+		return nil, nil
+	}
 
-		if currentBlock < latestBlock {
-			p.logger.Printf("Processing blocks from %d to %d", currentBlock+1, latestBlock)
+	heads, err := ws.SubscribeNewHeads()
+	if err != nil {
+		p.logger.Printf("Failed to subscribe to new heads: %v", err)
+		ws.Close()
+		return nil, nil
+	}
 
-			// Parse new blocks
-			for blockNum := currentBlock + 1; blockNum <= latestBlock; blockNum++ {
-				p.logger.Printf("Parsing block %d", blockNum)
-				if err := p.parseBlock(blockNum); err != nil {
-					p.logger.Printf("Failed to parse block %d: %v", blockNum, err)
-					continue
-				}
-				p.storage.SetCurrentBlock(blockNum)
+	p.logger.Printf("Subscribed to new heads on %s", p.config.WSEndpoint)
+	return ws, heads
+}
+
+// syncBlocks parses every block between the stored cursor and the network head
+func (p *EthParser) syncBlocks() {
+	currentBlock := p.GetCurrentBlock()
+	p.logger.Printf("Current block: %d", currentBlock)
+
+	// Get latest block number
+	resp, err := p.client.Call("eth_blockNumber", []interface{}{})
+	if err != nil {
+		p.logger.Printf("Failed to get latest block: %v", err)
+		return
+	}
+
+	latestBlock, err := rpc.ParseBlockNumber(resp.Result)
+	if err != nil {
+		p.logger.Printf("Failed to get latest block: %v", err)
+		return
+	}
+	p.logger.Printf("Latest block from network: %d", latestBlock)
+
+	defer p.updateStatuses(latestBlock)
+	defer p.advanceCursor()
+
+	p.retryFailedBlocks()
+
+	scannedBlock := max(p.getScannedBlock(), currentBlock)
+	if scannedBlock >= latestBlock {
+		p.logger.Printf("No new blocks to process")
+		return
+	}
+
+	p.logger.Printf("Processing blocks from %d to %d", scannedBlock+1, latestBlock)
+
+	// Parse new blocks, starting over from the common ancestor after a reorg
+	for from := scannedBlock + 1; from <= latestBlock; {
+		reorgAt := 0
+		p.fetchRange(from, latestBlock, p.fetchBlocks, func(blockNum int, block *fetchedBlock, err error) bool {
+			if err == nil {
+				err = p.commitBlock(blockNum, block)
+			}
+			if errors.Is(err, errReorg) {
+				reorgAt = blockNum
+				return false
 			}
-		} else {
-			p.logger.Printf("No new blocks to process")
+			if err != nil {
+				// Queue the block for a retry, the cursor stays below it until then
+				p.logger.Printf("Failed to parse block %d: %v", blockNum, err)
+				p.storage.RecordBlockFailure(blockNum, err.Error(), time.Now().Unix())
+			}
+			p.setScannedBlock(blockNum)
+			p.advanceCursor()
+			return true
+		})
+
+		if reorgAt == 0 {
+			return
+		}
+
+		ancestor, err := p.handleReorg(reorgAt)
+		if err != nil {
+			p.logger.Printf("Failed to handle reorg at block %d: %v", reorgAt, err)
+			return
 		}
+		// Re-parse the canonical branch from the block after the common ancestor
+		from = ancestor + 1
 	}
 }
 
-// Block represents an Ethereum block structure
-type Block struct {
-	Number       string              `json:"number"`
-	Hash         string              `json:"hash"`
-	Timestamp    string              `json:"timestamp"`
-	Transactions []types.Transaction `json:"transactions"`
-}
+// fetchedBlock is a block with the receipts and traces fetched alongside it
+type fetchedBlock struct {
+	types.Block
+
+	Receipts             []Receipt
+	InternalTransactions []types.InternalTransaction
 
-// Transaction represents an Ethereum transaction structure
-type Transaction struct {
-	Hash        string `json:"hash"`
-	From        string `json:"from"`
-	To          string `json:"to"`
-	Value       string `json:"value"`
-	BlockNumber string `json:"blockNumber"`
+	// timestamp is the block time in seconds, validated when the block is decoded
+	timestamp int64
 }
 
 func (p *EthParser) parseBlock(blockNum int) error {
 	p.logger.Printf("Starting to parse block %d", blockNum)
 
-	blockHex := fmt.Sprintf("0x%x", blockNum)
-
-	resp, err := p.client.Call("eth_getBlockByNumber", []interface{}{blockHex, true})
+	block, err := p.fetchBlock(blockNum)
 	if err != nil {
-		return fmt.Errorf("failed to get block %d: %w", blockNum, err)
+		return err
+	}
+
+	return p.commitBlock(blockNum, block)
+}
+
+// commitBlock stores the relevant transactions of a fetched block on top of the stored chain
+func (p *EthParser) commitBlock(blockNum int, block *fetchedBlock) error {
+	// The parent must be the block we parsed at the previous height
+	if parentHash, ok := p.blockHash(blockNum - 1); ok && parentHash != block.ParentHash {
+		p.logger.Printf("Block %d parent %s does not match stored hash %s", blockNum, block.ParentHash, parentHash)
+		return errReorg
 	}
 
-	var block Block
-	blockData, err := json.Marshal(resp.Result)
+	p.processBlock(block, blockNum, p.storage.IsSubscribed, true)
+	p.rememberBlock(blockNum, block)
+	return nil
+}
+
+// fetchBlock loads a block with its full transactions and receipts
+func (p *EthParser) fetchBlock(blockNum int) (*fetchedBlock, error) {
+	results := p.fetchBlocks(blockNum, blockNum)
+	return results[0].block, results[0].err
+}
+
+func decodeBlock(result interface{}) (*fetchedBlock, error) {
+	if result == nil {
+		return nil, fmt.Errorf("block %w", types.ErrNotFound)
+	}
+
+	var block fetchedBlock
+	blockData, err := json.Marshal(result)
 	if err != nil {
-		return fmt.Errorf("failed to marshal block data: %w", err)
+		return nil, fmt.Errorf("failed to marshal block data: %w", err)
 	}
 
-	if err := json.Unmarshal(blockData, &block); err != nil {
-		return fmt.Errorf("failed to unmarshal block data: %w", err)
+	if err := json.Unmarshal(blockData, &block.Block); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal block data: %w", err)
 	}
 
+	timestamp, err := block.Timestamp.Int()
+	if err != nil {
+		return nil, fmt.Errorf("invalid block timestamp: %w", err)
+	}
+	block.timestamp = int64(timestamp)
+
+	return &block, nil
+}
+
+// processBlock stores every transaction, internal transaction and token transfer
+// of the block sent from or to an address accepted by match, and returns how
+// many were stored. With notify set, the listeners get the stored transactions.
+func (p *EthParser) processBlock(block *fetchedBlock, blockNum int, match func(address string) bool, notify bool) int {
 	p.logger.Printf("Processing %d transactions in block %d", len(block.Transactions), blockNum)
 
+	receipts := make(map[string]Receipt, len(block.Receipts))
+	for _, receipt := range block.Receipts {
+		receipts[strings.ToLower(receipt.TransactionHash)] = receipt
+	}
+
 	transactionsFound := 0
 	for _, tx := range block.Transactions {
 		// Debug logging
 		p.logger.Printf("Checking transaction: From=%s, To=%s", tx.From, tx.To)
 
-		if p.storage.IsSubscribed(tx.From) || p.storage.IsSubscribed(tx.To) {
+		receipt, hasReceipt := receipts[strings.ToLower(tx.Hash)]
+
+		// A contract creation has no recipient, the created contract stands in for it
+		created := ""
+		if tx.To == "" && hasReceipt {
+			created = strings.ToLower(receipt.ContractAddress)
+			if created != "" && receipt.Status == "0x1" && p.config.FollowDeployments && match(tx.From) {
+				p.followDeployment(tx.From, created, blockNum)
+			}
+		}
+
+		if match(tx.From) || match(tx.To) || (created != "" && match(created)) {
 			p.logger.Printf("Found relevant transaction in block %d: %s", blockNum, tx.Hash)
 
-			// Convert to ParsedTransaction
-			parsedTx := types.ParsedTransaction{
-				Hash:        tx.Hash,
-				From:        tx.From,
-				To:          tx.To,
-				Value:       tx.Value,
-				BlockNumber: int64(blockNum),
-				Timestamp:   int64(hexToInt(block.Timestamp)),
+			parsedTx := p.parseTransaction(tx, blockNum, block.timestamp)
+			if hasReceipt {
+				applyReceipt(&parsedTx, receipt)
 			}
 
-			p.storage.AddTransaction(parsedTx)
-			transactionsFound++
+			if p.storage.AddTransaction(parsedTx) {
+				transactionsFound++
+				if notify {
+					p.notify(parsedTx)
+				}
+			}
 		}
 	}
 
-	p.logger.Printf("Found %d relevant transactions in block %d", transactionsFound, blockNum)
-	return nil
+	transactionsFound += p.processInternalTransactions(block, blockNum, match)
+	transactionsFound += p.processLogs(block, blockNum, match)
+
+	p.logger.Printf("Found %d relevant transactions and transfers in block %d", transactionsFound, blockNum)
+	return transactionsFound
 }
 
-func hexToInt(hex string) int {
-	// Remove "0x" prefix if present
-	if len(hex) >= 2 && hex[0:2] == "0x" {
-		hex = hex[2:]
+// parseTransaction converts a transaction included in a block, without its receipt
+func (p *EthParser) parseTransaction(tx types.Transaction, blockNum int, timestamp int64) types.ParsedTransaction {
+	return types.ParsedTransaction{
+		Hash:        tx.Hash,
+		From:        types.NormalizeAddress(tx.From),
+		To:          types.NormalizeAddress(tx.To),
+		Value:       tx.Value.Hex(),
+		ValueWei:    tx.Value.Decimal(),
+		ValueEth:    tx.Value.Ether(),
+		BlockNumber: int64(blockNum),
+		Timestamp:   timestamp,
+		Status:      p.statusFor(blockNum),
 	}
+}
 
-	// Convert hex string to big.Int
-	n := new(big.Int)
-	n.SetString(hex, 16)
+// followDeployment subscribes a contract deployed by a subscribed address for
+// every tenant of the deployer, so its history is indexed from its creation on
+func (p *EthParser) followDeployment(deployer, contract string, blockNum int) {
+	for _, subscriber := range p.storage.GetSubscribers(deployer) {
+		sub := types.Subscription{
+			Tenant:      subscriber.Tenant,
+			Address:     contract,
+			Label:       "Deployed by " + deployer,
+			CreatedAt:   time.Now().Unix(),
+			VisibleFrom: blockNum,
+		}
+		if p.storage.AddSubscription(sub) {
+			p.logger.Printf("Following contract %s deployed by %s in block %d for tenant %s", contract, deployer, blockNum, sub.Tenant)
+		}
+	}
+}
 
-	return int(n.Int64())
+// parseQuantity decodes a hex quantity that must fit in an int, such as a block
+// number, timestamp or log index
+func parseQuantity(hex string) (int, error) {
+	q, err := types.ParseQuantity(hex)
+	if err != nil {
+		return 0, err
+	}
+	return q.Int()
 }
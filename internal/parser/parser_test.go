@@ -1,12 +1,20 @@
 package parser
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"math/big"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"ethparser/internal/rpc"
+	"ethparser/internal/rpc/rpctest"
 	"ethparser/internal/storage"
+	"ethparser/pkg/types"
 )
 
 // MockRPCClient implements the RPCClient interface
@@ -50,6 +58,12 @@ func (m *MockRPCClient) Call(method string, params interface{}) (*rpc.JSONRPCRes
 			},
 			ID: 1,
 		}, nil
+	case "eth_getBlockReceipts":
+		return &rpc.JSONRPCResponse{
+			JsonRPC: "2.0",
+			Result:  []interface{}{},
+			ID:      1,
+		}, nil
 	}
 	return nil, nil
 }
@@ -60,11 +74,7 @@ func createTestParser() *EthParser {
 	mockClient := NewMockRPCClient()
 	storage := storage.NewMemoryStorage(logger)
 
-	return &EthParser{
-		client:  mockClient,
-		storage: storage,
-		logger:  logger,
-	}
+	return NewEthParser(mockClient, storage, DefaultConfig(), logger)
 }
 
 func TestParser(t *testing.T) {
@@ -128,3 +138,1157 @@ func TestParser(t *testing.T) {
 		}
 	})
 }
+
+// chainMockClient serves blocks from a mutable in-memory chain
+func mustParseQuantity(hex string) int {
+	n, err := parseQuantity(hex)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+type chainMockClient struct {
+	mu        sync.Mutex
+	head      int
+	finalized int
+	blocks    map[int]map[string]interface{}
+	logs      map[int][]map[string]interface{}
+	traces    map[int]interface{}
+	reverted  map[string]bool
+	created   map[string]string
+	failing   map[int]bool
+	batches   int
+
+	// noBlockReceipts makes eth_getBlockReceipts fail as on nodes without it
+	noBlockReceipts bool
+	receiptCalls    int
+}
+
+func newChainMockClient() *chainMockClient {
+	return &chainMockClient{
+		blocks:   make(map[int]map[string]interface{}),
+		logs:     make(map[int][]map[string]interface{}),
+		traces:   make(map[int]interface{}),
+		reverted: make(map[string]bool),
+		created:  make(map[string]string),
+		failing:  make(map[int]bool),
+	}
+}
+
+func (c *chainMockClient) setFailing(num int, failing bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failing[num] = failing
+}
+
+// setBlock adds a block on top of the given parent, with an optional transaction to `to`
+func (c *chainMockClient) setBlock(num int, hash, parentHash, to string) {
+	txs := []map[string]interface{}{}
+	if to != "" {
+		txs = append(txs, map[string]interface{}{
+			"hash":        hash + "-tx",
+			"from":        "0x123456",
+			"to":          to,
+			"value":       "0x1",
+			"blockNumber": fmt.Sprintf("0x%x", num),
+		})
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.blocks[num] = map[string]interface{}{
+		"number":       fmt.Sprintf("0x%x", num),
+		"hash":         hash,
+		"parentHash":   parentHash,
+		"timestamp":    "0x60c88c32",
+		"transactions": txs,
+	}
+	delete(c.logs, num)
+	if num > c.head {
+		c.head = num
+	}
+}
+
+// addDeployment adds a transaction from deployer creating contract to a block
+func (c *chainMockClient) addDeployment(num int, deployer, contract string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hash := fmt.Sprintf("0x%x-deploy", num)
+	block := c.blocks[num]
+	block["transactions"] = append(block["transactions"].([]map[string]interface{}), map[string]interface{}{
+		"hash":        hash,
+		"from":        deployer,
+		"to":          nil,
+		"value":       "0x0",
+		"blockNumber": fmt.Sprintf("0x%x", num),
+	})
+	c.created[hash] = contract
+}
+
+// addLog adds a log emitted by contract to the receipts of a block
+func (c *chainMockClient) addLog(num int, contract string, topics []string, data string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logs[num] = append(c.logs[num], map[string]interface{}{
+		"address":         contract,
+		"topics":          topics,
+		"data":            data,
+		"logIndex":        fmt.Sprintf("0x%x", len(c.logs[num])),
+		"transactionHash": fmt.Sprintf("0x%x-transfer", num),
+	})
+}
+
+// addTransfer adds an ERC-20 Transfer log of token to the receipts of a block
+func (c *chainMockClient) addTransfer(num int, token, from, to string, amount int64) {
+	c.addLog(num, token, []string{transferTopic, padTopic(from), padTopic(to)}, fmt.Sprintf("0x%064x", amount))
+}
+
+// padTopic left-pads an address into a 32-byte topic
+func padTopic(address string) string {
+	return "0x" + strings.Repeat("0", 24) + strings.TrimPrefix(address, "0x")
+}
+
+// receipts returns a receipt for every transaction of a block, plus one
+// carrying the logs added with addLog. Must be called with c.mu held.
+func (c *chainMockClient) receipts(num int) []map[string]interface{} {
+	block := c.blocks[num]
+
+	receipts := []map[string]interface{}{}
+	for _, tx := range block["transactions"].([]map[string]interface{}) {
+		status := "0x1"
+		if c.reverted[tx["hash"].(string)] {
+			status = "0x0"
+		}
+		var contractAddress interface{}
+		if contract, ok := c.created[tx["hash"].(string)]; ok {
+			contractAddress = contract
+		}
+		receipts = append(receipts, map[string]interface{}{
+			"transactionHash":   tx["hash"],
+			"blockHash":         block["hash"],
+			"status":            status,
+			"gasUsed":           "0x5208",
+			"effectiveGasPrice": "0x3b9aca00",
+			"contractAddress":   contractAddress,
+			"logs":              []interface{}{},
+		})
+	}
+	if logs := c.logs[num]; len(logs) > 0 {
+		receipts = append(receipts, map[string]interface{}{
+			"transactionHash": fmt.Sprintf("0x%x-transfer", num),
+			"blockHash":       block["hash"],
+			"status":          "0x1",
+			"logs":            logs,
+		})
+	}
+	return receipts
+}
+
+func (c *chainMockClient) Call(method string, params interface{}) (*rpc.JSONRPCResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch method {
+	case "eth_blockNumber":
+		return &rpc.JSONRPCResponse{Result: fmt.Sprintf("0x%x", c.head)}, nil
+	case "eth_getBlockByNumber":
+		tag := params.([]interface{})[0].(string)
+		num := c.finalized
+		if tag != "finalized" {
+			num = mustParseQuantity(tag)
+		}
+		block, ok := c.blocks[num]
+		if !ok || c.failing[num] {
+			return nil, fmt.Errorf("block %d not found", num)
+		}
+		return &rpc.JSONRPCResponse{Result: block}, nil
+	case "eth_getBlockReceipts":
+		if c.noBlockReceipts {
+			return nil, &rpc.JSONRPCError{Code: rpc.CodeMethodNotFound, Message: "the method eth_getBlockReceipts does not exist/is not available"}
+		}
+		num := mustParseQuantity(params.([]interface{})[0].(string))
+		if _, ok := c.blocks[num]; !ok || c.failing[num] {
+			return nil, fmt.Errorf("block %d not found", num)
+		}
+		return &rpc.JSONRPCResponse{Result: c.receipts(num)}, nil
+	case "eth_getTransactionReceipt":
+		hash := params.([]interface{})[0].(string)
+		for num := range c.blocks {
+			for _, receipt := range c.receipts(num) {
+				if receipt["transactionHash"] == hash {
+					c.receiptCalls++
+					return &rpc.JSONRPCResponse{Result: receipt}, nil
+				}
+			}
+		}
+		return &rpc.JSONRPCResponse{Result: nil}, nil
+	case "eth_getTransactionByHash":
+		hash := params.([]interface{})[0].(string)
+		for _, block := range c.blocks {
+			for _, tx := range block["transactions"].([]map[string]interface{}) {
+				if tx["hash"] == hash {
+					found := map[string]interface{}{"blockHash": block["hash"]}
+					for k, v := range tx {
+						found[k] = v
+					}
+					return &rpc.JSONRPCResponse{Result: found}, nil
+				}
+			}
+		}
+		return &rpc.JSONRPCResponse{Result: nil}, nil
+	case "debug_traceBlockByNumber", "trace_block":
+		num := mustParseQuantity(params.([]interface{})[0].(string))
+		if _, ok := c.blocks[num]; !ok || c.failing[num] {
+			return nil, fmt.Errorf("block %d not found", num)
+		}
+		traces, ok := c.traces[num]
+		if !ok {
+			traces = []interface{}{}
+		}
+		return &rpc.JSONRPCResponse{Result: traces}, nil
+	}
+	return nil, fmt.Errorf("unexpected method %s", method)
+}
+
+func (c *chainMockClient) BatchCall(requests []rpc.BatchRequest) ([]rpc.BatchResult, error) {
+	results := make([]rpc.BatchResult, len(requests))
+	for i, req := range requests {
+		results[i].Response, results[i].Error = c.Call(req.Method, req.Params)
+	}
+
+	c.mu.Lock()
+	c.batches++
+	c.mu.Unlock()
+	return results, nil
+}
+
+func TestReorg(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	address := "0xdac17f958d2ee523a2206206994597c13d831ec7"
+
+	client := newChainMockClient()
+	client.setBlock(100, "0xa100", "0xa99", "")
+	client.setBlock(101, "0xa101", "0xa100", address)
+	client.setBlock(102, "0xa102", "0xa101", address)
+
+	parser := NewEthParser(client, storage.NewMemoryStorage(logger), DefaultConfig(), logger)
+	parser.Subscribe(address)
+	parser.storage.SetCurrentBlock(99)
+
+	parser.syncBlocks()
+	if got := len(parser.GetTransactions(address)); got != 2 {
+		t.Fatalf("Expected 2 transactions before reorg, got %d", got)
+	}
+
+	// Replace blocks 101 and 102 with a longer competing branch
+	client.setBlock(101, "0xb101", "0xa100", "")
+	client.setBlock(102, "0xb102", "0xb101", address)
+	client.setBlock(103, "0xb103", "0xb102", "")
+	client.setBlock(104, "0xb104", "0xb103", "")
+
+	// Block 103 does not extend stored 102, so the parser rolls back to 100
+	parser.syncBlocks()
+
+	if got := parser.GetCurrentBlock(); got != 104 {
+		t.Errorf("Expected cursor at 104 after reorg, got %d", got)
+	}
+
+	transactions := parser.GetTransactions(address)
+	if len(transactions) != 1 || transactions[0].Hash != "0xb102-tx" {
+		t.Errorf("Expected only the canonical transaction, got %+v", transactions)
+	}
+
+	reorgs := parser.GetReorgs()
+	if len(reorgs) != 1 {
+		t.Fatalf("Expected 1 reorg event, got %d", len(reorgs))
+	}
+	if reorgs[0].CommonAncestor != 100 || reorgs[0].Depth != 2 || reorgs[0].RemovedTransactions != 2 {
+		t.Errorf("Unexpected reorg event: %+v", reorgs[0])
+	}
+}
+
+func TestReorgAfterRetry(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	address := "0xdac17f958d2ee523a2206206994597c13d831ec7"
+
+	client := newChainMockClient()
+	client.setBlock(100, "0xa100", "0xa99", "")
+	for num := 101; num <= 105; num++ {
+		client.setBlock(num, fmt.Sprintf("0xa%d", num), fmt.Sprintf("0xa%d", num-1), address)
+	}
+	client.setFailing(103, true)
+
+	parser := NewEthParser(client, storage.NewMemoryStorage(logger), DefaultConfig(), logger)
+	parser.Subscribe(address)
+	parser.storage.SetCurrentBlock(99)
+	parser.syncBlocks()
+
+	// The chain is reorganized above 102 while block 103 waits for its retry
+	for num := 103; num <= 106; num++ {
+		client.setBlock(num, fmt.Sprintf("0xb%d", num), fmt.Sprintf("0xb%d", num-1), address)
+	}
+	client.setBlock(103, "0xb103", "0xa102", address)
+	client.setFailing(103, false)
+
+	// The retried block 103 is not the parent of the stored 104
+	parser.syncBlocks()
+
+	if got := parser.GetCurrentBlock(); got != 106 {
+		t.Errorf("Expected cursor at 106 after reorg, got %d", got)
+	}
+	for _, tx := range parser.GetTransactions(address) {
+		if strings.HasPrefix(tx.Hash, "0xa10") && tx.BlockNumber > 102 {
+			t.Errorf("Expected orphaned transaction %s to be removed", tx.Hash)
+		}
+	}
+	if got := len(parser.GetTransactions(address)); got != 6 {
+		t.Errorf("Expected 6 canonical transactions, got %d", got)
+	}
+
+	reorgs := parser.GetReorgs()
+	if len(reorgs) != 1 {
+		t.Fatalf("Expected 1 reorg event, got %d", len(reorgs))
+	}
+	if reorgs[0].CommonAncestor != 102 || reorgs[0].OldHead != 105 || reorgs[0].Unresolved {
+		t.Errorf("Unexpected reorg event: %+v", reorgs[0])
+	}
+}
+
+func TestReorgBeyondTrackedHistory(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+
+	client := newChainMockClient()
+	client.setBlock(100, "0xa100", "0xa99", "")
+	client.setBlock(101, "0xa101", "0xa100", "")
+
+	parser := NewEthParser(client, storage.NewMemoryStorage(logger), DefaultConfig(), logger)
+	parser.storage.SetCurrentBlock(99)
+	parser.syncBlocks()
+
+	// Every tracked block is replaced, so no common ancestor can be found
+	client.setBlock(100, "0xb100", "0xb99", "")
+	client.setBlock(101, "0xb101", "0xb100", "")
+	client.setBlock(102, "0xb102", "0xb101", "")
+	parser.syncBlocks()
+
+	reorgs := parser.GetReorgs()
+	if len(reorgs) != 1 {
+		t.Fatalf("Expected 1 reorg event, got %d", len(reorgs))
+	}
+	if !reorgs[0].Unresolved || reorgs[0].CommonAncestor != 99 {
+		t.Errorf("Expected an unresolved reorg rolled back to 99, got %+v", reorgs[0])
+	}
+	if got := parser.GetCurrentBlock(); got != 102 {
+		t.Errorf("Expected cursor at 102, got %d", got)
+	}
+}
+
+func TestConfirmations(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	address := "0xdac17f958d2ee523a2206206994597c13d831ec7"
+
+	client := newChainMockClient()
+	client.setBlock(100, "0xa100", "0xa99", "")
+	client.setBlock(101, "0xa101", "0xa100", address)
+	client.finalized = 100
+
+	config := DefaultConfig()
+	config.Confirmations = 2
+	parser := NewEthParser(client, storage.NewMemoryStorage(logger), config, logger)
+	parser.Subscribe(address)
+	parser.storage.SetCurrentBlock(100)
+
+	status := func() string {
+		transactions := parser.GetTransactions(address)
+		if len(transactions) != 1 {
+			t.Fatalf("Expected 1 transaction, got %d", len(transactions))
+		}
+		return transactions[0].Status
+	}
+
+	parser.syncBlocks()
+	if got := status(); got != types.StatusPending {
+		t.Errorf("Expected pending at head, got %s", got)
+	}
+
+	client.setBlock(102, "0xa102", "0xa101", "")
+	client.setBlock(103, "0xa103", "0xa102", "")
+	parser.syncBlocks()
+	if got := status(); got != types.StatusConfirmed {
+		t.Errorf("Expected confirmed after 2 blocks, got %s", got)
+	}
+
+	client.finalized = 101
+	parser.syncBlocks()
+	if got := status(); got != types.StatusFinalized {
+		t.Errorf("Expected finalized, got %s", got)
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	address := "0xdac17f958d2ee523a2206206994597c13d831ec7"
+
+	client := newChainMockClient()
+	client.setBlock(100, "0xa100", "0xa99", address)
+	client.setBlock(101, "0xa101", "0xa100", "")
+	client.setBlock(102, "0xa102", "0xa101", address)
+	client.setBlock(103, "0xa103", "0xa102", address)
+
+	parser := NewEthParser(client, storage.NewMemoryStorage(logger), DefaultConfig(), logger)
+	parser.storage.SetCurrentBlock(102)
+
+	if !parser.SubscribeFrom(address, 100) {
+		t.Fatal("Failed to subscribe address")
+	}
+
+	// Live parsing of block 103 runs alongside the backfill of 100-102
+	parser.syncBlocks()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		backfills := parser.GetBackfills("")
+		if len(backfills) != 1 {
+			t.Fatalf("Expected 1 backfill job, got %d", len(backfills))
+		}
+		if backfills[0].Status == types.BackfillCompleted {
+			if backfills[0].TransactionsFound != 2 || backfills[0].CurrentBlock != 102 {
+				t.Errorf("Unexpected backfill progress: %+v", backfills[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Backfill did not complete: %+v", backfills[0])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := len(parser.GetTransactions(address)); got != 3 {
+		t.Errorf("Expected 3 transactions after backfill, got %d", got)
+	}
+
+	// Backfilling the same range again must not duplicate transactions
+	parser.runBackfill(&types.BackfillJob{Address: address, FromBlock: 100, ToBlock: 103})
+	if got := len(parser.GetTransactions(address)); got != 3 {
+		t.Errorf("Expected 3 transactions after repeated backfill, got %d", got)
+	}
+}
+
+func TestBackfillAboveFailedBlock(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	address := "0xdac17f958d2ee523a2206206994597c13d831ec7"
+	other := "0x1111111111111111111111111111111111111111"
+
+	client := newChainMockClient()
+	client.setBlock(100, "0x100", "0x99", "")
+	for num := 101; num <= 110; num++ {
+		to := address
+		if num == 102 || num == 107 || num == 109 {
+			to = other
+		}
+		client.setBlock(num, fmt.Sprintf("0x%d", num), fmt.Sprintf("0x%d", num-1), to)
+	}
+	client.setFailing(105, true)
+
+	parser := NewEthParser(client, storage.NewMemoryStorage(logger), DefaultConfig(), logger)
+	parser.Subscribe(address)
+	parser.storage.SetCurrentBlock(100)
+
+	// Block 105 fails: the cursor stops at 104 while blocks up to 110 are scanned
+	parser.syncBlocks()
+	if got := parser.GetCurrentBlock(); got != 104 {
+		t.Fatalf("Expected cursor to stop before failed block 105, got %d", got)
+	}
+	client.setFailing(105, false)
+
+	if !parser.SubscribeFrom(other, 100) {
+		t.Fatal("Failed to subscribe address")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		backfills := parser.GetBackfills("")
+		if len(backfills) != 1 {
+			t.Fatalf("Expected 1 backfill job, got %d", len(backfills))
+		}
+		if backfills[0].ToBlock != 110 {
+			t.Fatalf("Expected backfill up to scanned block 110, got %d", backfills[0].ToBlock)
+		}
+		if backfills[0].Status == types.BackfillCompleted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Backfill did not complete: %+v", backfills[0])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Retrying block 105 does not rescan the blocks after it
+	parser.syncBlocks()
+	if got := len(parser.GetTransactions(other)); got != 3 {
+		t.Errorf("Expected 3 transactions for the backfilled address, got %d", got)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	address := "0xdac17f958d2ee523a2206206994597c13d831ec7"
+
+	t.Run("CursorStopsAtFailedBlock", func(t *testing.T) {
+		client := newChainMockClient()
+		client.setBlock(100, "0x100", "0x99", "")
+		for num := 101; num <= 120; num++ {
+			client.setBlock(num, fmt.Sprintf("0x%d", num), fmt.Sprintf("0x%d", num-1), address)
+		}
+		client.setFailing(105, true)
+
+		parser := NewEthParser(client, storage.NewMemoryStorage(logger), DefaultConfig(), logger)
+		parser.Subscribe(address)
+		parser.storage.SetCurrentBlock(100)
+
+		parser.syncBlocks()
+		if got := parser.GetCurrentBlock(); got != 104 {
+			t.Errorf("Expected cursor to stop before failed block 105, got %d", got)
+		}
+		if got := len(parser.GetTransactions(address)); got != 19 {
+			t.Errorf("Expected blocks after the failed one to be parsed, got %d transactions", got)
+		}
+
+		// Still failing: the attempt is counted and the cursor stays put
+		parser.syncBlocks()
+		failed := parser.GetFailedBlocks()
+		if len(failed) != 1 || failed[0].Number != 105 || failed[0].Attempts != 2 {
+			t.Errorf("Expected block 105 failed twice, got %+v", failed)
+		}
+		if got := parser.GetCurrentBlock(); got != 104 {
+			t.Errorf("Expected cursor to stay at 104, got %d", got)
+		}
+
+		client.setFailing(105, false)
+		parser.syncBlocks()
+		if got := parser.GetCurrentBlock(); got != 120 {
+			t.Errorf("Expected cursor at head after retry, got %d", got)
+		}
+		if failed := parser.GetFailedBlocks(); len(failed) != 0 {
+			t.Errorf("Expected no failed blocks after retry, got %+v", failed)
+		}
+		if got := len(parser.GetTransactions(address)); got != 20 {
+			t.Errorf("Expected 20 transactions, got %d", got)
+		}
+		if client.batches == 0 {
+			t.Error("Expected blocks to be fetched in batches")
+		}
+	})
+
+	t.Run("OrderedCommit", func(t *testing.T) {
+		config := DefaultConfig()
+		config.Workers = 8
+		config.BatchSize = 3
+		parser := NewEthParser(newChainMockClient(), storage.NewMemoryStorage(logger), config, logger)
+
+		// Later blocks finish fetching first
+		fetch := func(from, to int) []fetchResult {
+			time.Sleep(time.Duration(50-from) * time.Millisecond)
+			var results []fetchResult
+			for blockNum := from; blockNum <= to; blockNum++ {
+				results = append(results, fetchResult{block: &fetchedBlock{Block: types.Block{Number: types.NewQuantity(big.NewInt(int64(blockNum)))}}})
+			}
+			return results
+		}
+
+		var committed []int
+		parser.fetchRange(1, 40, fetch, func(blockNum int, block *fetchedBlock, err error) bool {
+			if block.Number.Hex() != fmt.Sprintf("0x%x", blockNum) {
+				t.Errorf("Block %s committed as %d", block.Number, blockNum)
+			}
+			committed = append(committed, blockNum)
+			return blockNum < 30
+		})
+
+		if len(committed) != 30 {
+			t.Fatalf("Expected commit to stop after block 30, got %d commits", len(committed))
+		}
+		for i, blockNum := range committed {
+			if blockNum != i+1 {
+				t.Fatalf("Expected ordered commits, got %v", committed)
+			}
+		}
+	})
+}
+
+func TestNewHeads(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+
+	server := rpctest.NewWSServer()
+	defer server.Close()
+
+	client := newChainMockClient()
+	client.setBlock(100, "0x100", "0x99", "")
+
+	// Poll rarely, so only head notifications can move the cursor
+	config := DefaultConfig()
+	config.PollInterval = time.Hour
+	config.WSEndpoint = server.URL
+
+	parser := NewEthParser(client, storage.NewMemoryStorage(logger), config, logger)
+	parser.storage.SetCurrentBlock(100)
+	if err := parser.Start(); err != nil {
+		t.Fatalf("Failed to start parser: %v", err)
+	}
+	defer parser.Stop()
+
+	waitForBlock := func(block int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for parser.GetCurrentBlock() < block {
+			if time.Now().After(deadline) {
+				t.Fatalf("Cursor did not reach block %d, at %d", block, parser.GetCurrentBlock())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if !server.WaitForSubscriptions(1, 5*time.Second) {
+		t.Fatal("Parser did not subscribe to new heads")
+	}
+
+	client.setBlock(101, "0x101", "0x100", "")
+	server.PushHead(rpc.Header{Number: "0x65", Hash: "0x101"})
+	waitForBlock(101)
+
+	// After the socket drops the parser reconnects and catches up on missed blocks
+	server.DropConnections()
+	client.setBlock(102, "0x102", "0x101", "")
+
+	if !server.WaitForSubscriptions(2, 5*time.Second) {
+		t.Fatal("Parser did not resubscribe after the connection dropped")
+	}
+	waitForBlock(102)
+}
+
+func TestNewHeadsWatchdog(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+
+	server := rpctest.NewWSServer()
+	defer server.Close()
+
+	client := newChainMockClient()
+	client.setBlock(100, "0x100", "0x99", "")
+
+	config := DefaultConfig()
+	config.PollInterval = 20 * time.Millisecond
+	config.WSEndpoint = server.URL
+
+	parser := NewEthParser(client, storage.NewMemoryStorage(logger), config, logger)
+	parser.storage.SetCurrentBlock(100)
+	if err := parser.Start(); err != nil {
+		t.Fatalf("Failed to start parser: %v", err)
+	}
+	defer parser.Stop()
+
+	if !server.WaitForSubscriptions(1, 5*time.Second) {
+		t.Fatal("Parser did not subscribe to new heads")
+	}
+
+	// The subscription stays open but never delivers a head
+	client.setBlock(101, "0x101", "0x100", "")
+	deadline := time.Now().Add(5 * time.Second)
+	for parser.GetCurrentBlock() < 101 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a silent subscription to fall back to polling, at block %d", parser.GetCurrentBlock())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTokenTransfers(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	wallet := "0x00000000000000000000000000000000000000aa"
+	usdt := "0xdac17f958d2ee523a2206206994597c13d831ec7"
+
+	client := newChainMockClient()
+	client.setBlock(100, "0x100", "0x99", "")
+	client.setBlock(101, "0x101", "0x100", "")
+	client.addTransfer(101, usdt, "0x00000000000000000000000000000000000000bb", wallet, 2500000)
+	client.addTransfer(101, usdt, "0x00000000000000000000000000000000000000bb", "0x00000000000000000000000000000000000000cc", 1)
+	client.setBlock(102, "0x102", "0x101", "")
+	client.addTransfer(102, usdt, wallet, "0x00000000000000000000000000000000000000cc", 500000)
+
+	parser := NewEthParser(client, storage.NewMemoryStorage(logger), DefaultConfig(), logger)
+	parser.Subscribe(wallet)
+	parser.storage.SetCurrentBlock(100)
+
+	parser.syncBlocks()
+
+	transfers := parser.GetTokenTransfers("", wallet)
+	if len(transfers) != 2 {
+		t.Fatalf("Expected 2 token transfers, got %d", len(transfers))
+	}
+	if transfers[0].Token != usdt || transfers[0].To != wallet || transfers[0].Amount != "2500000" {
+		t.Errorf("Unexpected incoming transfer %+v", transfers[0])
+	}
+	if transfers[1].From != wallet || transfers[1].Amount != "500000" || transfers[1].BlockNumber != 102 {
+		t.Errorf("Unexpected outgoing transfer %+v", transfers[1])
+	}
+	if len(parser.GetTransactions(wallet)) != 0 {
+		t.Error("Token transfers should not be stored as native transactions")
+	}
+
+	// A reorg drops the transfers of the orphaned blocks
+	client.setBlock(102, "0xb102", "0x101", "")
+	client.setBlock(103, "0xb103", "0xb102", "")
+	parser.syncBlocks()
+
+	if got := len(parser.GetTokenTransfers("", wallet)); got != 1 {
+		t.Errorf("Expected 1 token transfer after reorg, got %d", got)
+	}
+}
+
+func TestDecodeTokenTransfer(t *testing.T) {
+	log := Log{
+		Address: "0xdAC17F958D2ee523a2206206994597C13D831ec7",
+		Topics: []string{
+			transferTopic,
+			"0x0000000000000000000000005041ed759dd4afc3a72b8192c143f72f4724081a",
+			"0x000000000000000000000000a9d1e08c7793af67e9d92fe308d5697fb81d3e43",
+		},
+		Data:            "0x00000000000000000000000000000000000000000000000000000000b2d05e00",
+		LogIndex:        "0x1f",
+		TransactionHash: "0xabc",
+	}
+
+	transfer, ok := decodeTokenTransfer(log)
+	if !ok {
+		t.Fatal("Expected the transfer to decode")
+	}
+	if transfer.Token != "0xdac17f958d2ee523a2206206994597c13d831ec7" ||
+		transfer.From != "0x5041ed759dd4afc3a72b8192c143f72f4724081a" ||
+		transfer.To != "0xa9d1e08c7793af67e9d92fe308d5697fb81d3e43" ||
+		transfer.Amount != "3000000000" || transfer.LogIndex != 31 {
+		t.Errorf("Unexpected transfer %+v", transfer)
+	}
+
+	// ERC-721 transfers carry the token id as a fourth topic
+	log.Topics = append(log.Topics, "0x0000000000000000000000000000000000000000000000000000000000000001")
+	if _, ok := decodeTokenTransfer(log); ok {
+		t.Error("ERC-721 transfer should not decode as ERC-20")
+	}
+}
+
+func TestNFTTransfers(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	wallet := "0x00000000000000000000000000000000000000aa"
+	other := "0x00000000000000000000000000000000000000bb"
+	zero := "0x0000000000000000000000000000000000000000"
+	apes := "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d"
+	items := "0x76be3b62873462d2142405439777e971754e8e77"
+
+	client := newChainMockClient()
+	client.setBlock(100, "0x100", "0x99", "")
+	client.setBlock(101, "0x101", "0x100", "")
+
+	// ERC-721: mint #1 and #2 to the wallet, then send #2 away
+	client.addLog(101, apes, []string{transferTopic, padTopic(zero), padTopic(wallet), fmt.Sprintf("0x%064x", 1)}, "0x")
+	client.addLog(101, apes, []string{transferTopic, padTopic(zero), padTopic(wallet), fmt.Sprintf("0x%064x", 2)}, "0x")
+	client.addLog(101, apes, []string{transferTopic, padTopic(wallet), padTopic(other), fmt.Sprintf("0x%064x", 2)}, "0x")
+
+	// ERC-1155: receive 5 of id 7, then send 2 of id 7 and 1 of id 9 in a batch
+	client.addLog(101, items, []string{transferSingleTopic, padTopic(other), padTopic(other), padTopic(wallet)},
+		fmt.Sprintf("0x%064x%064x", 7, 5))
+	client.addLog(101, items, []string{transferBatchTopic, padTopic(wallet), padTopic(wallet), padTopic(other)},
+		fmt.Sprintf("0x%064x%064x%064x%064x%064x%064x%064x%064x", 0x40, 0xa0, 2, 7, 9, 2, 2, 1))
+
+	parser := NewEthParser(client, storage.NewMemoryStorage(logger), DefaultConfig(), logger)
+	parser.Subscribe(wallet)
+	parser.storage.SetCurrentBlock(100)
+
+	parser.syncBlocks()
+
+	transfers := parser.GetNFTTransfers("", wallet)
+	if len(transfers) != 6 {
+		t.Fatalf("Expected 6 NFT transfers, got %d: %+v", len(transfers), transfers)
+	}
+	if transfers[0].Standard != types.StandardERC721 || transfers[0].TokenID != "1" || transfers[0].Amount != "1" {
+		t.Errorf("Unexpected ERC-721 transfer %+v", transfers[0])
+	}
+	if transfers[3].Standard != types.StandardERC1155 || transfers[3].Operator != other || transfers[3].Amount != "5" {
+		t.Errorf("Unexpected ERC-1155 transfer %+v", transfers[3])
+	}
+	if transfers[5].BatchIndex != 1 || transfers[5].TokenID != "9" {
+		t.Errorf("Unexpected batch transfer %+v", transfers[5])
+	}
+	if len(parser.GetTokenTransfers("", wallet)) != 0 {
+		t.Error("ERC-721 transfers should not be stored as token transfers")
+	}
+
+	holdings := parser.GetNFTHoldings("", wallet)
+	expected := []types.NFTHolding{
+		{Contract: items, Standard: types.StandardERC1155, TokenID: "7", Amount: "3"},
+		{Contract: apes, Standard: types.StandardERC721, TokenID: "1", Amount: "1"},
+	}
+	if fmt.Sprint(holdings) != fmt.Sprint(expected) {
+		t.Errorf("Expected holdings %+v, got %+v", expected, holdings)
+	}
+
+	// A backfill stores the mint of #3 after the live transfer that sent it away
+	parser.storage.AddNFTTransfer(types.NFTTransfer{TxHash: "0xout", LogIndex: 20, Contract: apes, Standard: types.StandardERC721, From: wallet, To: other, TokenID: "3", Amount: "1", BlockNumber: 101})
+	parser.storage.AddNFTTransfer(types.NFTTransfer{TxHash: "0xmint", LogIndex: 0, Contract: apes, Standard: types.StandardERC721, From: zero, To: wallet, TokenID: "3", Amount: "1", BlockNumber: 50})
+	if transfers := parser.GetNFTTransfers("", wallet); transfers[0].TxHash != "0xmint" {
+		t.Errorf("Expected transfers in block order, got %+v", transfers[0])
+	}
+	if holdings := parser.GetNFTHoldings("", wallet); fmt.Sprint(holdings) != fmt.Sprint(expected) {
+		t.Errorf("Expected the backfilled token not to be held, got %+v", holdings)
+	}
+}
+
+func TestInternalTransactions(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	wallet := "0x00000000000000000000000000000000000000aa"
+	safe := "0x00000000000000000000000000000000000000bb"
+	singleton := "0x00000000000000000000000000000000000000cc"
+
+	// A multisig execution paying the wallet through a delegatecall to its
+	// singleton, plus a reverted payment that must be ignored
+	debugTraces := []interface{}{
+		map[string]interface{}{
+			"txHash": "0xexec",
+			"result": map[string]interface{}{
+				"type": "CALL", "from": "0x00000000000000000000000000000000000000dd", "to": safe, "value": "0x0",
+				"calls": []interface{}{
+					map[string]interface{}{
+						"type": "DELEGATECALL", "from": safe, "to": singleton,
+						"calls": []interface{}{
+							map[string]interface{}{"type": "CALL", "from": safe, "to": wallet, "value": "0xde0b6b3a7640000"},
+							map[string]interface{}{"type": "CALL", "from": safe, "to": wallet, "value": "0x1", "error": "execution reverted"},
+						},
+					},
+				},
+			},
+		},
+	}
+	parityTraces := []interface{}{
+		map[string]interface{}{
+			"type": "call", "transactionHash": "0xexec", "traceAddress": []int{},
+			"action": map[string]interface{}{"callType": "call", "from": "0x00000000000000000000000000000000000000dd", "to": safe, "value": "0x0"},
+		},
+		map[string]interface{}{
+			"type": "call", "transactionHash": "0xexec", "traceAddress": []int{0},
+			"action": map[string]interface{}{"callType": "delegatecall", "from": safe, "to": singleton, "value": "0x0"},
+		},
+		map[string]interface{}{
+			"type": "call", "transactionHash": "0xexec", "traceAddress": []int{0, 0},
+			"action": map[string]interface{}{"callType": "call", "from": safe, "to": wallet, "value": "0xde0b6b3a7640000"},
+		},
+		map[string]interface{}{
+			"type": "call", "transactionHash": "0xexec", "traceAddress": []int{0, 1}, "error": "Reverted",
+			"action": map[string]interface{}{"callType": "call", "from": safe, "to": singleton, "value": "0x0"},
+		},
+		map[string]interface{}{
+			"type": "call", "transactionHash": "0xexec", "traceAddress": []int{0, 1, 0},
+			"action": map[string]interface{}{"callType": "call", "from": safe, "to": wallet, "value": "0x1"},
+		},
+		map[string]interface{}{
+			"type": "reward", "traceAddress": []int{},
+			"action": map[string]interface{}{"author": wallet, "value": "0x1bc16d674ec80000"},
+		},
+	}
+
+	for mode, traces := range map[string]interface{}{TraceModeDebug: debugTraces, TraceModeParity: parityTraces} {
+		t.Run(mode, func(t *testing.T) {
+			client := newChainMockClient()
+			client.setBlock(100, "0x100", "0x99", "")
+			client.setBlock(101, "0x101", "0x100", "")
+			client.traces[101] = traces
+
+			config := DefaultConfig()
+			config.TraceMode = mode
+			parser := NewEthParser(client, storage.NewMemoryStorage(logger), config, logger)
+			parser.Subscribe(wallet)
+			parser.storage.SetCurrentBlock(100)
+
+			parser.syncBlocks()
+
+			internal := parser.GetInternalTransactions("", wallet)
+			if len(internal) != 1 {
+				t.Fatalf("Expected 1 internal transaction, got %d: %+v", len(internal), internal)
+			}
+			tx := internal[0]
+			if tx.TxHash != "0xexec" || tx.TraceAddress != "0.0" || tx.From != safe || tx.Value != "0xde0b6b3a7640000" || tx.BlockNumber != 101 {
+				t.Errorf("Unexpected internal transaction %+v", tx)
+			}
+		})
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		client := newChainMockClient()
+		client.setBlock(100, "0x100", "0x99", "")
+		client.setBlock(101, "0x101", "0x100", "")
+		client.traces[101] = debugTraces
+
+		parser := NewEthParser(client, storage.NewMemoryStorage(logger), DefaultConfig(), logger)
+		parser.Subscribe(wallet)
+		parser.storage.SetCurrentBlock(100)
+
+		parser.syncBlocks()
+
+		if got := len(parser.GetInternalTransactions("", wallet)); got != 0 {
+			t.Errorf("Expected no internal transactions without tracing, got %d", got)
+		}
+	})
+}
+
+func TestReceipts(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	address := "0xdac17f958d2ee523a2206206994597c13d831ec7"
+
+	for _, blockReceipts := range []bool{true, false} {
+		t.Run(fmt.Sprintf("BlockReceipts=%v", blockReceipts), func(t *testing.T) {
+			client := newChainMockClient()
+			client.noBlockReceipts = !blockReceipts
+			client.setBlock(100, "0x100", "0x99", "")
+			client.setBlock(101, "0x101", "0x100", address)
+			client.setBlock(102, "0x102", "0x101", address)
+			client.reverted["0x102-tx"] = true
+
+			parser := NewEthParser(client, storage.NewMemoryStorage(logger), DefaultConfig(), logger)
+			parser.Subscribe(address)
+			parser.storage.SetCurrentBlock(100)
+
+			parser.syncBlocks()
+
+			txs := parser.GetTransactions(address)
+			if len(txs) != 2 {
+				t.Fatalf("Expected 2 transactions, got %d", len(txs))
+			}
+			if txs[0].ExecutionStatus != types.ExecutionSuccess || txs[0].GasUsed != 21000 ||
+				txs[0].EffectiveGasPrice != "1000000000" || txs[0].Fee != "21000000000000" || txs[0].FeeEth != "0.000021" {
+				t.Errorf("Unexpected receipt fields %+v", txs[0])
+			}
+			if txs[0].Value != "0x1" || txs[0].ValueWei != "1" || txs[0].ValueEth != "0.000000000000000001" {
+				t.Errorf("Unexpected value fields %+v", txs[0])
+			}
+			if txs[1].ExecutionStatus != types.ExecutionReverted {
+				t.Errorf("Expected the second transaction to be reverted, got %+v", txs[1])
+			}
+
+			if blockReceipts != (client.receiptCalls == 0) {
+				t.Errorf("Unexpected %d eth_getTransactionReceipt calls", client.receiptCalls)
+			}
+			if len(parser.GetFailedBlocks()) != 0 {
+				t.Errorf("Expected no failed blocks, got %+v", parser.GetFailedBlocks())
+			}
+		})
+	}
+}
+
+func TestContractCreation(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	deployer := "0x4e59b44847b379578588920ca78fbf26c0b4956c"
+	contract := "0x5fbdb2315678afecb367f032d93f642f64180aa3"
+	failed := "0xe7f1725e7734ce288f8367e1bb143e90bb3f0512"
+
+	for _, follow := range []bool{false, true} {
+		t.Run(fmt.Sprintf("FollowDeployments=%v", follow), func(t *testing.T) {
+			client := newChainMockClient()
+			client.setBlock(100, "0x100", "0x99", "")
+			client.setBlock(101, "0x101", "0x100", "")
+			client.addDeployment(101, deployer, contract)
+			client.setBlock(102, "0x102", "0x101", contract)
+			client.addDeployment(102, deployer, failed)
+			client.reverted["0x66-deploy"] = true
+
+			config := DefaultConfig()
+			config.FollowDeployments = follow
+			parser := NewEthParser(client, storage.NewMemoryStorage(logger), config, logger)
+			parser.Subscribe(deployer)
+			parser.storage.SetCurrentBlock(100)
+
+			parser.syncBlocks()
+
+			txs := parser.GetTransactions(deployer)
+			if len(txs) != 2 {
+				t.Fatalf("Expected 2 deployments, got %d", len(txs))
+			}
+			if txs[0].To != "" || txs[0].ContractAddress != contract {
+				t.Errorf("Unexpected deployment %+v", txs[0])
+			}
+
+			// The followed contract keeps its creation and later transactions
+			contractTxs := parser.GetTransactions(contract)
+			if follow && (len(contractTxs) != 2 || contractTxs[0].Hash != "0x65-deploy") {
+				t.Errorf("Expected the creation and call of the deployed contract, got %+v", contractTxs)
+			}
+			if !follow && len(contractTxs) != 0 {
+				t.Errorf("Expected the deployed contract not to be followed, got %+v", contractTxs)
+			}
+			sub, ok := parser.storage.GetSubscription(types.DefaultTenant, contract)
+			if ok != follow {
+				t.Errorf("Expected the contract to be followed for the tenant of the deployer: %v", ok)
+			}
+			if follow && sub.VisibleFrom != 101 {
+				t.Errorf("Expected the contract to be visible from its deployment, got %d", sub.VisibleFrom)
+			}
+			if parser.storage.IsSubscribed(failed) {
+				t.Errorf("Expected a reverted deployment not to be followed")
+			}
+		})
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	address := "0xdac17f958d2ee523a2206206994597c13d831ec7"
+
+	client := newChainMockClient()
+	client.setBlock(100, "0xa100", "0xa99", address)
+	client.setBlock(101, "0xa101", "0xa100", address)
+
+	config := DefaultConfig()
+	config.FromBlock = 50
+	parser := NewEthParser(client, storage.NewMemoryStorage(logger), config, logger)
+	parser.storage.SetCurrentBlock(100)
+
+	noBackfill := 0
+	if !parser.SubscribeWith("0xDAC17F958D2EE523A2206206994597C13D831EC7", types.SubscribeOptions{Label: "USDT", Tags: []string{"token"}, FromBlock: &noBackfill}) {
+		t.Fatal("Failed to subscribe address")
+	}
+
+	subs := parser.ListSubscriptions("")
+	if len(subs) != 1 || subs[0].Address != address || subs[0].Label != "USDT" || subs[0].StartBlock != 0 || subs[0].VisibleFrom != 101 || subs[0].CreatedAt == 0 {
+		t.Errorf("Unexpected subscriptions %+v", subs)
+	}
+	if len(parser.GetBackfills("")) != 0 {
+		t.Errorf("Expected no backfill, got %+v", parser.GetBackfills(""))
+	}
+
+	// Tenants subscribe the same address independently
+	if !parser.SubscribeWith(address, types.SubscribeOptions{Tenant: "acme", FromBlock: &noBackfill}) {
+		t.Fatal("Failed to subscribe address for acme")
+	}
+	if len(parser.ListSubscriptions("acme")) != 1 || len(parser.ListSubscriptions("")) != 2 {
+		t.Errorf("Expected a subscription per tenant, got %+v", parser.ListSubscriptions(""))
+	}
+	if !parser.Unsubscribe("acme", address, true) || !parser.storage.IsSubscribed(address) {
+		t.Error("Expected the address to stay indexed for the default tenant")
+	}
+
+	// An unsubscribed address keeps its history but is no longer indexed
+	if !parser.Unsubscribe(types.DefaultTenant, address, false) {
+		t.Fatal("Failed to unsubscribe address")
+	}
+	parser.syncBlocks()
+	if got := len(parser.GetTransactions(address)); got != 0 {
+		t.Errorf("Expected no transactions after unsubscribing, got %d", got)
+	}
+	if len(parser.ListSubscriptions("")) != 0 {
+		t.Errorf("Expected no subscriptions, got %+v", parser.ListSubscriptions(""))
+	}
+
+	// GetTransactions answers for the default tenant only
+	other := "0x00000000000000000000000000000000000000cc"
+	client.setBlock(102, "0xa102", "0xa101", other)
+	parser.SubscribeWith(other, types.SubscribeOptions{Tenant: "acme", FromBlock: &noBackfill})
+	parser.syncBlocks()
+	if got := len(parser.GetTransactions(other)); got != 0 {
+		t.Errorf("Expected no default tenant transactions, got %d", got)
+	}
+	if got := len(parser.storage.GetTransactions("acme", other)); got != 1 {
+		t.Errorf("Expected 1 transaction for acme, got %d", got)
+	}
+
+	// A backfill stops once its address is unsubscribed
+	job := &types.BackfillJob{Address: address, FromBlock: 100, ToBlock: 101}
+	parser.runBackfill(job)
+	if job.Status != types.BackfillCancelled {
+		t.Errorf("Expected the backfill to be cancelled, got %+v", job)
+	}
+}
+
+func TestTransactionListener(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	address := "0xdac17f958d2ee523a2206206994597c13d831ec7"
+
+	client := newChainMockClient()
+	client.setBlock(100, "0xa100", "0xa99", address)
+	client.setBlock(101, "0xa101", "0xa100", address)
+
+	parser := NewEthParser(client, storage.NewMemoryStorage(logger), DefaultConfig(), logger)
+	parser.storage.SetCurrentBlock(99)
+	parser.Subscribe(address)
+
+	var notified []string
+	parser.AddTransactionListener(func(tx types.ParsedTransaction) {
+		notified = append(notified, tx.Hash)
+	})
+
+	// Backfilled transactions are stored without notifying
+	job := &types.BackfillJob{Address: address, FromBlock: 100, ToBlock: 100}
+	parser.runBackfill(job)
+	if len(notified) != 0 {
+		t.Errorf("Expected no notifications from a backfill, got %v", notified)
+	}
+
+	parser.syncBlocks()
+	if len(notified) != 1 {
+		t.Errorf("Expected the live block 101 to be notified once, got %v", notified)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	address := "0xdac17f958d2ee523a2206206994597c13d831ec7"
+
+	client := newChainMockClient()
+	client.setBlock(100, "0xa100", "0xa99", address)
+	client.setBlock(101, "0xa101", "0xa100", "0x00000000000000000000000000000000000000aa")
+
+	parser := NewEthParser(client, storage.NewMemoryStorage(logger), DefaultConfig(), logger)
+	parser.storage.SetCurrentBlock(99)
+	parser.Subscribe(address)
+	parser.syncBlocks()
+
+	lookup, err := parser.GetTransaction("", "0xa100-tx")
+	if err != nil || !lookup.Indexed || len(lookup.Addresses) != 1 || lookup.Addresses[0] != address {
+		t.Errorf("Expected the indexed transaction, got %+v, %v", lookup, err)
+	}
+
+	// Tenants that do not follow the address only get what the node returns
+	lookup, err = parser.GetTransaction("globex", "0xa100-tx")
+	if err != nil || lookup.Indexed || len(lookup.Addresses) != 0 {
+		t.Errorf("Expected the transaction from the node for another tenant, got %+v, %v", lookup, err)
+	}
+
+	// Transactions that were not stored are looked up on the node
+	lookup, err = parser.GetTransaction("", "0xa101-tx")
+	if err != nil || lookup.Indexed || len(lookup.Addresses) != 0 || lookup.Transaction.BlockNumber != 101 || lookup.Transaction.Timestamp == 0 {
+		t.Errorf("Expected the transaction from the node, got %+v, %v", lookup, err)
+	}
+	if _, err := parser.GetTransaction("", "0xmissing"); !errors.Is(err, types.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	block, err := parser.GetBlock("", 100)
+	if err != nil || !block.Indexed || block.Hash != "0xa100" || block.ParentHash != "0xa99" || block.Timestamp == 0 || len(block.Transactions) != 1 {
+		t.Errorf("Expected the indexed block, got %+v, %v", block, err)
+	}
+
+	// Blocks below the cursor that were never parsed come from the node
+	client.setBlock(50, "0xa50", "0xa49", address)
+	block, err = parser.GetBlock("", 50)
+	if err != nil || block.Indexed || block.Hash != "0xa50" || len(block.Transactions) != 1 {
+		t.Errorf("Expected the unparsed block from the node, got %+v, %v", block, err)
+	}
+
+	// Blocks beyond the parsed head are fetched from the node
+	client.setBlock(102, "0xa102", "0xa101", address)
+	block, err = parser.GetBlock("", 102)
+	if err != nil || block.Indexed || block.TransactionCount != 1 || len(block.Transactions) != 1 || block.Transactions[0].Addresses[0] != address {
+		t.Errorf("Expected the block from the node, got %+v, %v", block, err)
+	}
+	if len(parser.GetTransactions(address)) != 1 {
+		t.Error("Expected a lookup not to store anything")
+	}
+}
@@ -0,0 +1,160 @@
+package parser
+
+import (
+	"fmt"
+
+	"ethparser/internal/rpc"
+)
+
+// fetchResult is the outcome of fetching a single block
+type fetchResult struct {
+	block *fetchedBlock
+	err   error
+}
+
+// fetchRange fetches blocks from..to in chunks of Config.BatchSize with up to
+// Config.Workers concurrent requests and hands them to commit strictly in
+// ascending order. Fetching runs at most two rounds of workers ahead of
+// commit, and stops at the first block commit returns false for.
+func (p *EthParser) fetchRange(from, to int, fetch func(from, to int) []fetchResult, commit func(blockNum int, block *fetchedBlock, err error) bool) {
+	workers := max(p.config.Workers, 1)
+	batchSize := max(p.config.BatchSize, 1)
+
+	// Chunk results are queued in block order; each one is filled in by its worker
+	pending := make(chan chan []fetchResult, workers*2)
+	slots := make(chan struct{}, workers)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(pending)
+
+		for start := from; start <= to; start += batchSize {
+			end := min(start+batchSize-1, to)
+
+			results := make(chan []fetchResult, 1)
+			select {
+			case pending <- results:
+			case <-done:
+				return
+			}
+
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+
+			go func(start, end int) {
+				defer func() { <-slots }()
+				results <- fetch(start, end)
+			}(start, end)
+		}
+	}()
+
+	blockNum := from
+	for results := range pending {
+		for _, r := range <-results {
+			if !commit(blockNum, r.block, r.err) {
+				return
+			}
+			blockNum++
+		}
+	}
+}
+
+// fetchBlocks fetches blocks from..to with their full transactions, receipts
+// and, when tracing is enabled, traces, in one JSON-RPC batch when the client
+// supports it
+func (p *EthParser) fetchBlocks(from, to int) []fetchResult {
+	count := to - from + 1
+	requests := make([]rpc.BatchRequest, 0, count*3)
+	for blockNum := from; blockNum <= to; blockNum++ {
+		requests = append(requests, rpc.BatchRequest{
+			Method: "eth_getBlockByNumber",
+			Params: []interface{}{fmt.Sprintf("0x%x", blockNum), true},
+		})
+	}
+
+	receiptsAt := len(requests)
+	for blockNum := from; blockNum <= to; blockNum++ {
+		if receipts, ok := p.receiptsRequest(blockNum); ok {
+			requests = append(requests, receipts)
+		}
+	}
+
+	tracesAt := len(requests)
+	for blockNum := from; blockNum <= to; blockNum++ {
+		if trace, ok := p.traceRequest(blockNum); ok {
+			requests = append(requests, trace)
+		}
+	}
+
+	responses := p.callMany(requests)
+	results := make([]fetchResult, count)
+	for i := range results {
+		var receipts, traces *rpc.BatchResult
+		if tracesAt > receiptsAt {
+			receipts = &responses[receiptsAt+i]
+		}
+		if len(responses) > tracesAt {
+			traces = &responses[tracesAt+i]
+		}
+
+		results[i].block, results[i].err = p.assembleBlock(responses[i], receipts, traces)
+		if results[i].err != nil {
+			results[i].err = fmt.Errorf("block %d: %w", from+i, results[i].err)
+		}
+	}
+	return results
+}
+
+// assembleBlock decodes a block and attaches its receipts and traces. Without a
+// block receipts answer the receipts are fetched per transaction.
+func (p *EthParser) assembleBlock(blockResp rpc.BatchResult, receiptsResp, tracesResp *rpc.BatchResult) (*fetchedBlock, error) {
+	if blockResp.Error != nil {
+		return nil, fmt.Errorf("failed to get block: %w", blockResp.Error)
+	}
+	block, err := decodeBlock(blockResp.Response.Result)
+	if err != nil {
+		return nil, err
+	}
+
+	if receiptsResp != nil {
+		block.Receipts, err = p.blockReceipts(block, *receiptsResp)
+	} else {
+		block.Receipts, err = p.transactionReceipts(block)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if tracesResp != nil {
+		if tracesResp.Error != nil {
+			return nil, fmt.Errorf("failed to trace block: %w", tracesResp.Error)
+		}
+		block.InternalTransactions, err = decodeTraces(p.config.TraceMode, tracesResp.Response.Result, block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return block, nil
+}
+
+// callMany sends requests as one batch when the client supports batching and
+// one by one otherwise. Results are always in request order.
+func (p *EthParser) callMany(requests []rpc.BatchRequest) []rpc.BatchResult {
+	if batcher, ok := p.client.(rpc.BatchCaller); ok && len(requests) > 1 {
+		results, err := batcher.BatchCall(requests)
+		if err == nil {
+			return results
+		}
+		p.logger.Printf("Batch of %d calls failed, falling back to single calls: %v", len(requests), err)
+	}
+
+	results := make([]rpc.BatchResult, len(requests))
+	for i, req := range requests {
+		results[i].Response, results[i].Error = p.client.Call(req.Method, req.Params)
+	}
+	return results
+}
@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"ethparser/internal/rpc"
+	"ethparser/pkg/types"
+)
+
+// Receipt represents the receipt of a transaction with the logs it emitted
+type Receipt struct {
+	TransactionHash   string `json:"transactionHash"`
+	BlockHash         string `json:"blockHash"`
+	Status            string `json:"status"`
+	GasUsed           string `json:"gasUsed"`
+	EffectiveGasPrice string `json:"effectiveGasPrice"`
+	ContractAddress   string `json:"contractAddress"`
+	Logs              []Log  `json:"logs"`
+}
+
+// Log represents an event emitted by a contract
+type Log struct {
+	Address         string   `json:"address"`
+	Topics          []string `json:"topics"`
+	Data            string   `json:"data"`
+	LogIndex        string   `json:"logIndex"`
+	TransactionHash string   `json:"transactionHash"`
+	Removed         bool     `json:"removed"`
+}
+
+// receiptsRequest returns the eth_getBlockReceipts call for a block, unless the
+// node turned out not to support it
+func (p *EthParser) receiptsRequest(blockNum int) (rpc.BatchRequest, bool) {
+	if p.noBlockReceipts.Load() {
+		return rpc.BatchRequest{}, false
+	}
+	return rpc.BatchRequest{
+		Method: "eth_getBlockReceipts",
+		Params: []interface{}{fmt.Sprintf("0x%x", blockNum)},
+	}, true
+}
+
+// blockReceipts decodes the eth_getBlockReceipts answer for a block. When the
+// node does not provide the method, it is not asked again and receipts are
+// fetched per transaction from then on.
+func (p *EthParser) blockReceipts(block *fetchedBlock, resp rpc.BatchResult) ([]Receipt, error) {
+	if rpc.IsMethodNotFound(resp.Error) {
+		if !p.noBlockReceipts.Swap(true) {
+			p.logger.Printf("eth_getBlockReceipts is not available, fetching receipts per transaction")
+		}
+		return p.transactionReceipts(block)
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("failed to get receipts: %w", resp.Error)
+	}
+	return decodeReceipts(resp.Response.Result, block)
+}
+
+// transactionReceipts fetches the receipt of every transaction of a block with eth_getTransactionReceipt
+func (p *EthParser) transactionReceipts(block *fetchedBlock) ([]Receipt, error) {
+	if len(block.Transactions) == 0 {
+		return nil, nil
+	}
+
+	requests := make([]rpc.BatchRequest, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		requests[i] = rpc.BatchRequest{Method: "eth_getTransactionReceipt", Params: []interface{}{tx.Hash}}
+	}
+
+	results := make([]interface{}, len(requests))
+	for i, resp := range p.callMany(requests) {
+		if resp.Error != nil {
+			return nil, fmt.Errorf("failed to get receipt of %s: %w", block.Transactions[i].Hash, resp.Error)
+		}
+		if resp.Response.Result == nil {
+			return nil, fmt.Errorf("receipt of %s not found", block.Transactions[i].Hash)
+		}
+		results[i] = resp.Response.Result
+	}
+	return decodeReceipts(results, block)
+}
+
+// decodeReceipts decodes the receipts of a block, which must belong to that
+// block in case the chain changed between fetching the block and its receipts
+func decodeReceipts(result interface{}, block *fetchedBlock) ([]Receipt, error) {
+	if result == nil {
+		return nil, fmt.Errorf("receipts not found")
+	}
+
+	var receipts []Receipt
+	receiptData, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal receipts: %w", err)
+	}
+
+	if err := json.Unmarshal(receiptData, &receipts); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal receipts: %w", err)
+	}
+
+	for _, receipt := range receipts {
+		if receipt.BlockHash != "" && !strings.EqualFold(receipt.BlockHash, block.Hash) {
+			return nil, fmt.Errorf("receipts belong to block %s, not %s", receipt.BlockHash, block.Hash)
+		}
+	}
+	return receipts, nil
+}
+
+// applyReceipt adds the execution result and fee of a receipt to a transaction
+func applyReceipt(tx *types.ParsedTransaction, receipt Receipt) {
+	switch receipt.Status {
+	case "0x1":
+		tx.ExecutionStatus = types.ExecutionSuccess
+	case "0x0":
+		tx.ExecutionStatus = types.ExecutionReverted
+	}
+
+	tx.LogCount = len(receipt.Logs)
+	tx.ContractAddress = strings.ToLower(receipt.ContractAddress)
+
+	gasUsed, errGas := types.ParseQuantity(receipt.GasUsed)
+	if errGas == nil {
+		tx.GasUsed, errGas = gasUsed.Int64()
+	}
+
+	gasPrice, errPrice := types.ParseQuantity(receipt.EffectiveGasPrice)
+	if errPrice == nil {
+		tx.EffectiveGasPrice = gasPrice.Decimal()
+	}
+	if errGas == nil && errPrice == nil {
+		fee := types.NewQuantity(new(big.Int).Mul(gasUsed.Big(), gasPrice.Big()))
+		tx.Fee = fee.Decimal()
+		tx.FeeEth = fee.Ether()
+	}
+}
@@ -0,0 +1,151 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"ethparser/pkg/types"
+)
+
+const (
+	// maxTrackedBlocks is how many recent block hashes are kept for reorg detection
+	maxTrackedBlocks = 128
+
+	// maxReorgEvents is how many reorg events are kept for the API
+	maxReorgEvents = 100
+)
+
+// errReorg is returned by parseBlock when the block does not extend the stored chain
+var errReorg = errors.New("chain reorganization detected")
+
+// blockHeader is what is kept of a recently parsed block
+type blockHeader struct {
+	hash       string
+	parentHash string
+	timestamp  int64
+}
+
+// rememberBlock records the header of a parsed block, forgetting the oldest ones
+func (p *EthParser) rememberBlock(blockNum int, block *fetchedBlock) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.recentBlocks[blockNum] = blockHeader{hash: block.Hash, parentHash: block.ParentHash, timestamp: block.timestamp}
+	delete(p.recentBlocks, blockNum-maxTrackedBlocks)
+}
+
+// recentBlock returns the header of a block parsed recently
+func (p *EthParser) recentBlock(blockNum int) (blockHeader, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	header, ok := p.recentBlocks[blockNum]
+	return header, ok
+}
+
+func (p *EthParser) blockHash(blockNum int) (string, bool) {
+	header, ok := p.recentBlock(blockNum)
+	return header.hash, ok
+}
+
+// oldestRecentBlock returns the lowest block whose header is still tracked
+func (p *EthParser) oldestRecentBlock() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	oldest := 0
+	for num := range p.recentBlocks {
+		if oldest == 0 || num < oldest {
+			oldest = num
+		}
+	}
+	return oldest
+}
+
+// handleReorg walks back from the block before blockNum until the stored hash
+// matches the canonical chain, removes everything stored above that common
+// ancestor and moves the cursor back to it. It returns the common ancestor.
+// Blocks missing from the tracked headers because they failed are stepped
+// over; running out of tracked headers is recorded as an unresolved reorg.
+func (p *EthParser) handleReorg(blockNum int) (int, error) {
+	oldHead := max(blockNum-1, p.getScannedBlock())
+	ancestor := blockNum - 1
+	oldest := p.oldestRecentBlock()
+	unresolved := false
+
+	for ; ancestor > 0; ancestor-- {
+		storedHash, ok := p.blockHash(ancestor)
+		if !ok && ancestor > oldest {
+			continue
+		}
+		if !ok {
+			p.logger.Printf("WARNING: reorg at block %d is deeper than the tracked history, rolling back to block %d without finding the common ancestor; older records may be orphaned",
+				blockNum, ancestor)
+			unresolved = true
+			break
+		}
+
+		canonicalHash, err := p.fetchBlockHash(ancestor)
+		if err != nil {
+			return 0, err
+		}
+		if canonicalHash == storedHash {
+			break
+		}
+	}
+
+	removed := p.storage.RemoveTransactionsFrom(ancestor + 1)
+	if ancestor < p.storage.GetCurrentBlock() {
+		p.storage.SetCurrentBlock(ancestor)
+	}
+
+	// Failed blocks above the ancestor are orphaned and get re-scanned on the canonical branch
+	for _, failed := range p.storage.GetFailedBlocks() {
+		if failed.Number > ancestor {
+			p.storage.RemoveFailedBlock(failed.Number)
+		}
+	}
+
+	event := types.ReorgEvent{
+		DetectedAt:          time.Now().Unix(),
+		DetectedAtBlock:     blockNum,
+		CommonAncestor:      ancestor,
+		OldHead:             oldHead,
+		Depth:               oldHead - ancestor,
+		RemovedTransactions: removed,
+		Unresolved:          unresolved,
+	}
+
+	p.mu.Lock()
+	p.scannedBlock = ancestor
+	for num := range p.recentBlocks {
+		if num > ancestor {
+			delete(p.recentBlocks, num)
+		}
+	}
+	p.reorgs = append(p.reorgs, event)
+	if len(p.reorgs) > maxReorgEvents {
+		p.reorgs = p.reorgs[len(p.reorgs)-maxReorgEvents:]
+	}
+	p.mu.Unlock()
+
+	p.logger.Printf("Reorg detected at block %d: common ancestor %d, depth %d, removed %d transactions",
+		blockNum, ancestor, event.Depth, removed)
+	return ancestor, nil
+}
+
+// fetchBlockHash returns the canonical hash of a block without its transactions
+func (p *EthParser) fetchBlockHash(blockNum int) (string, error) {
+	resp, err := p.client.Call("eth_getBlockByNumber", []interface{}{fmt.Sprintf("0x%x", blockNum), false})
+	if err != nil {
+		return "", fmt.Errorf("failed to get block %d: %w", blockNum, err)
+	}
+
+	header, ok := resp.Result.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("unexpected block %d response", blockNum)
+	}
+	hash, _ := header["hash"].(string)
+	return hash, nil
+}
@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"fmt"
+
+	"ethparser/pkg/types"
+)
+
+// updateStatuses promotes stored transactions to confirmed or finalized as the chain advances
+func (p *EthParser) updateStatuses(latestBlock int) {
+	finalizedBlock, err := p.fetchFinalizedBlock()
+	if err != nil {
+		p.logger.Printf("Failed to get finalized block: %v", err)
+		finalizedBlock = p.getFinalizedBlock()
+	}
+
+	p.mu.Lock()
+	p.latestBlock = latestBlock
+	p.finalizedBlock = finalizedBlock
+	p.mu.Unlock()
+
+	updated := p.storage.UpdateStatuses(p.confirmedBlock(latestBlock), finalizedBlock)
+	if updated > 0 {
+		p.logger.Printf("Updated status of %d transactions (head %d, finalized %d)", updated, latestBlock, finalizedBlock)
+	}
+}
+
+// statusFor returns the status of a transaction included in the given block
+func (p *EthParser) statusFor(blockNum int) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	switch {
+	case blockNum <= p.finalizedBlock:
+		return types.StatusFinalized
+	case blockNum <= p.confirmedBlock(p.latestBlock):
+		return types.StatusConfirmed
+	default:
+		return types.StatusPending
+	}
+}
+
+// confirmedBlock is the highest block with enough confirmations on top of it
+func (p *EthParser) confirmedBlock(latestBlock int) int {
+	return latestBlock - p.config.Confirmations
+}
+
+func (p *EthParser) getFinalizedBlock() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.finalizedBlock
+}
+
+// fetchFinalizedBlock asks the node for the number of the `finalized` block tag
+func (p *EthParser) fetchFinalizedBlock() (int, error) {
+	resp, err := p.client.Call("eth_getBlockByNumber", []interface{}{"finalized", false})
+	if err != nil {
+		return 0, err
+	}
+
+	header, ok := resp.Result.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("unexpected finalized block response")
+	}
+	number, ok := header["number"].(string)
+	if !ok {
+		return 0, fmt.Errorf("finalized block without number")
+	}
+	return parseQuantity(number)
+}
@@ -0,0 +1,105 @@
+package parser
+
+import (
+	"math/big"
+	"strings"
+
+	"ethparser/pkg/types"
+)
+
+// transferTopic is the keccak256 hash of Transfer(address,address,uint256)
+const transferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+func (p *EthParser) GetTokenTransfers(tenant, address string) []types.TokenTransfer {
+	return p.storage.GetTokenTransfers(tenant, address)
+}
+
+// processLogs stores every ERC-20 and NFT transfer of the block sent from or to
+// an address accepted by match, and returns how many were stored
+func (p *EthParser) processLogs(block *fetchedBlock, blockNum int, match func(address string) bool) int {
+	timestamp := block.timestamp
+	status := p.statusFor(blockNum)
+
+	transfersFound := 0
+	for _, receipt := range block.Receipts {
+		for _, log := range receipt.Logs {
+			if transfer, ok := decodeTokenTransfer(log); ok && (match(transfer.From) || match(transfer.To)) {
+				p.logger.Printf("Found relevant %s transfer in block %d: %s", transfer.Token, blockNum, transfer.TxHash)
+
+				transfer.BlockNumber = int64(blockNum)
+				transfer.Timestamp = timestamp
+				transfer.Status = status
+
+				if p.storage.AddTokenTransfer(transfer) {
+					transfersFound++
+				}
+				continue
+			}
+
+			for _, transfer := range decodeNFTTransfers(log) {
+				if !match(transfer.From) && !match(transfer.To) {
+					continue
+				}
+				p.logger.Printf("Found relevant NFT transfer in block %d: %s #%s", blockNum, transfer.Contract, transfer.TokenID)
+
+				transfer.BlockNumber = int64(blockNum)
+				transfer.Timestamp = timestamp
+				transfer.Status = status
+
+				if p.storage.AddNFTTransfer(transfer) {
+					transfersFound++
+				}
+			}
+		}
+	}
+	return transfersFound
+}
+
+// decodeTokenTransfer decodes an ERC-20 Transfer log. ERC-721 emits the same
+// event with the token id as a third indexed topic and is left to decodeNFTTransfers.
+func decodeTokenTransfer(log Log) (types.TokenTransfer, bool) {
+	if log.Removed || len(log.Topics) != 3 || !strings.EqualFold(log.Topics[0], transferTopic) {
+		return types.TokenTransfer{}, false
+	}
+
+	from, ok := topicAddress(log.Topics[1])
+	if !ok {
+		return types.TokenTransfer{}, false
+	}
+	to, ok := topicAddress(log.Topics[2])
+	if !ok {
+		return types.TokenTransfer{}, false
+	}
+
+	// The amount is the first 32-byte word of the data
+	data := strings.TrimPrefix(log.Data, "0x")
+	if len(data) < 64 {
+		return types.TokenTransfer{}, false
+	}
+	amount, ok := new(big.Int).SetString(data[:64], 16)
+	if !ok {
+		return types.TokenTransfer{}, false
+	}
+	logIndex, err := parseQuantity(log.LogIndex)
+	if err != nil {
+		return types.TokenTransfer{}, false
+	}
+
+	return types.TokenTransfer{
+		TxHash:   log.TransactionHash,
+		LogIndex: logIndex,
+		Token:    strings.ToLower(log.Address),
+		From:     from,
+		To:       to,
+		Amount:   amount.String(),
+	}, true
+}
+
+// topicAddress extracts the address left-padded into a 32-byte topic
+func topicAddress(topic string) (string, bool) {
+	hex := strings.TrimPrefix(topic, "0x")
+	if len(hex) != 64 {
+		return "", false
+	}
+	return "0x" + strings.ToLower(hex[24:]), true
+}
@@ -0,0 +1,240 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"ethparser/internal/rpc"
+	"ethparser/pkg/types"
+)
+
+// callFrame is a call in the tree returned by the callTracer
+type callFrame struct {
+	Type  string      `json:"type"`
+	From  string      `json:"from"`
+	To    string      `json:"to"`
+	Value string      `json:"value"`
+	Error string      `json:"error"`
+	Calls []callFrame `json:"calls"`
+}
+
+// txTrace is the callTracer result for one transaction of a block
+type txTrace struct {
+	TxHash string    `json:"txHash"`
+	Result callFrame `json:"result"`
+}
+
+// parityTrace is a single flattened call returned by trace_block
+type parityTrace struct {
+	Type   string `json:"type"`
+	Action struct {
+		CallType      string `json:"callType"`
+		From          string `json:"from"`
+		To            string `json:"to"`
+		Value         string `json:"value"`
+		Address       string `json:"address"`
+		RefundAddress string `json:"refundAddress"`
+		Balance       string `json:"balance"`
+	} `json:"action"`
+	Result *struct {
+		Address string `json:"address"`
+	} `json:"result"`
+	Error           string `json:"error"`
+	TraceAddress    []int  `json:"traceAddress"`
+	TransactionHash string `json:"transactionHash"`
+}
+
+func (p *EthParser) GetInternalTransactions(tenant, address string) []types.InternalTransaction {
+	return p.storage.GetInternalTransactions(tenant, address)
+}
+
+// traceRequest returns the call tracing a block in the configured trace mode
+func (p *EthParser) traceRequest(blockNum int) (rpc.BatchRequest, bool) {
+	blockHex := fmt.Sprintf("0x%x", blockNum)
+
+	switch p.config.TraceMode {
+	case TraceModeDebug:
+		return rpc.BatchRequest{
+			Method: "debug_traceBlockByNumber",
+			Params: []interface{}{blockHex, map[string]interface{}{"tracer": "callTracer"}},
+		}, true
+	case TraceModeParity:
+		return rpc.BatchRequest{Method: "trace_block", Params: []interface{}{blockHex}}, true
+	}
+	return rpc.BatchRequest{}, false
+}
+
+// processInternalTransactions stores every internal transaction of the block
+// sent from or to an address accepted by match, and returns how many were stored
+func (p *EthParser) processInternalTransactions(block *fetchedBlock, blockNum int, match func(address string) bool) int {
+	timestamp := block.timestamp
+	status := p.statusFor(blockNum)
+
+	found := 0
+	for _, tx := range block.InternalTransactions {
+		if !match(tx.From) && !match(tx.To) {
+			continue
+		}
+		p.logger.Printf("Found relevant internal transaction in block %d: %s [%s]", blockNum, tx.TxHash, tx.TraceAddress)
+
+		// The value was validated by hasValue when the traces were decoded
+		value, _ := types.ParseQuantity(tx.Value)
+		tx.ValueWei = value.Decimal()
+		tx.ValueEth = value.Ether()
+		tx.BlockNumber = int64(blockNum)
+		tx.Timestamp = timestamp
+		tx.Status = status
+
+		if p.storage.AddInternalTransaction(tx) {
+			found++
+		}
+	}
+	return found
+}
+
+// decodeTraces flattens the traces of a block into the internal calls that
+// moved value. Calls that reverted, and everything nested in them, are skipped.
+func decodeTraces(mode string, result interface{}, block *fetchedBlock) ([]types.InternalTransaction, error) {
+	if result == nil {
+		return nil, fmt.Errorf("traces not found")
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal traces: %w", err)
+	}
+
+	if mode == TraceModeParity {
+		var traces []parityTrace
+		if err := json.Unmarshal(data, &traces); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal traces: %w", err)
+		}
+		return flattenParityTraces(traces), nil
+	}
+
+	var traces []txTrace
+	if err := json.Unmarshal(data, &traces); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal traces: %w", err)
+	}
+
+	var internal []types.InternalTransaction
+	for i, trace := range traces {
+		// Older nodes do not return the hash, the traces are in transaction order
+		txHash := trace.TxHash
+		if txHash == "" && i < len(block.Transactions) {
+			txHash = block.Transactions[i].Hash
+		}
+		if trace.Result.Error == "" {
+			internal = flattenCalls(txHash, trace.Result, nil, internal)
+		}
+	}
+	return internal, nil
+}
+
+// flattenCalls appends the value transfers nested in frame, depth first
+func flattenCalls(txHash string, frame callFrame, path []int, internal []types.InternalTransaction) []types.InternalTransaction {
+	for i, call := range frame.Calls {
+		if call.Error != "" {
+			continue
+		}
+
+		callPath := append(append([]int{}, path...), i)
+		callType := strings.ToLower(call.Type)
+		if transfersValue(callType) && hasValue(call.Value) {
+			internal = append(internal, types.InternalTransaction{
+				TxHash:       txHash,
+				TraceAddress: joinTraceAddress(callPath),
+				Type:         callType,
+				From:         strings.ToLower(call.From),
+				To:           strings.ToLower(call.To),
+				Value:        call.Value,
+			})
+		}
+		internal = flattenCalls(txHash, call, callPath, internal)
+	}
+	return internal
+}
+
+func flattenParityTraces(traces []parityTrace) []types.InternalTransaction {
+	var internal []types.InternalTransaction
+	reverted := make(map[string]bool)
+
+	for _, trace := range traces {
+		// Block and uncle rewards are not part of a transaction
+		if trace.TransactionHash == "" {
+			continue
+		}
+
+		key := trace.TransactionHash + ":" + joinTraceAddress(trace.TraceAddress)
+		if trace.Error != "" || revertedParent(reverted, trace) {
+			reverted[key] = true
+			continue
+		}
+		if len(trace.TraceAddress) == 0 {
+			continue
+		}
+
+		tx := types.InternalTransaction{
+			TxHash:       trace.TransactionHash,
+			TraceAddress: joinTraceAddress(trace.TraceAddress),
+		}
+		switch trace.Type {
+		case "call":
+			tx.Type, tx.From, tx.To, tx.Value = trace.Action.CallType, trace.Action.From, trace.Action.To, trace.Action.Value
+		case "create":
+			tx.Type, tx.From, tx.Value = "create", trace.Action.From, trace.Action.Value
+			if trace.Result != nil {
+				tx.To = trace.Result.Address
+			}
+		case "suicide":
+			tx.Type, tx.From, tx.To, tx.Value = "selfdestruct", trace.Action.Address, trace.Action.RefundAddress, trace.Action.Balance
+		default:
+			continue
+		}
+
+		if !transfersValue(tx.Type) || !hasValue(tx.Value) {
+			continue
+		}
+		tx.From = strings.ToLower(tx.From)
+		tx.To = strings.ToLower(tx.To)
+		internal = append(internal, tx)
+	}
+	return internal
+}
+
+// revertedParent reports whether any call enclosing trace reverted
+func revertedParent(reverted map[string]bool, trace parityTrace) bool {
+	for depth := 0; depth < len(trace.TraceAddress); depth++ {
+		if reverted[trace.TransactionHash+":"+joinTraceAddress(trace.TraceAddress[:depth])] {
+			return true
+		}
+	}
+	return false
+}
+
+// transfersValue reports whether a call of this type moves its value to the callee.
+// Delegate and static calls run in the caller's context and move nothing.
+func transfersValue(callType string) bool {
+	switch callType {
+	case "call", "create", "create2", "selfdestruct":
+		return true
+	}
+	return false
+}
+
+// hasValue reports whether a traced value is a valid non-zero quantity
+func hasValue(value string) bool {
+	q, err := types.ParseQuantity(value)
+	return err == nil && q.Sign() > 0
+}
+
+// joinTraceAddress formats a call position as dot-separated indexes, e.g. "0.2.1"
+func joinTraceAddress(path []int) string {
+	parts := make([]string, len(path))
+	for i, index := range path {
+		parts[i] = strconv.Itoa(index)
+	}
+	return strings.Join(parts, ".")
+}
@@ -3,8 +3,12 @@ package rpc
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"sync/atomic"
+	"time"
 )
 
 // RPCClient interface defines the methods our RPC client must implement
@@ -12,9 +16,31 @@ type RPCClient interface {
 	Call(method string, params interface{}) (*JSONRPCResponse, error)
 }
 
+// BatchCaller is implemented by clients that can send several calls in one JSON-RPC batch
+type BatchCaller interface {
+	BatchCall(requests []BatchRequest) ([]BatchResult, error)
+}
+
 type Client struct {
 	endpoint   string
 	httpClient *http.Client
+	config     ClientConfig
+	limiter    *rateLimiter
+	sleep      func(time.Duration)
+	nextID     atomic.Int64
+}
+
+// BatchRequest is a single call of a JSON-RPC batch
+type BatchRequest struct {
+	Method string
+	Params interface{}
+}
+
+// BatchResult is the outcome of one call of a batch. Results are returned in
+// request order and either Response or Error is set.
+type BatchResult struct {
+	Response *JSONRPCResponse
+	Error    error
 }
 
 type JSONRPCRequest struct {
@@ -36,10 +62,41 @@ type JSONRPCError struct {
 	Message string `json:"message"`
 }
 
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("rpc error: %s", e.Message)
+}
+
+// CodeMethodNotFound is the JSON-RPC error code for a method the node does not provide
+const CodeMethodNotFound = -32601
+
+// IsMethodNotFound reports whether err means the node does not provide the called method
+func IsMethodNotFound(err error) bool {
+	var rpcErr *JSONRPCError
+	return errors.As(err, &rpcErr) && rpcErr.Code == CodeMethodNotFound
+}
+
+// HTTPError is returned when the endpoint answers with a non-2xx status
+type HTTPError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d, body: %s", e.StatusCode, e.Body)
+}
+
 func NewClient(endpoint string) *Client {
+	return NewClientWithConfig(endpoint, DefaultClientConfig())
+}
+
+func NewClientWithConfig(endpoint string, config ClientConfig) *Client {
 	return &Client{
 		endpoint:   endpoint,
-		httpClient: &http.Client{},
+		httpClient: &http.Client{Timeout: config.Timeout},
+		config:     config,
+		limiter:    newRateLimiter(config.RateLimit, config.Burst),
+		sleep:      time.Sleep,
 	}
 }
 
@@ -48,28 +105,143 @@ func (c *Client) Call(method string, params interface{}) (*JSONRPCResponse, erro
 		JsonRPC: "2.0",
 		Method:  method,
 		Params:  params,
-		ID:      83,
+		ID:      c.newID(),
+	}
+
+	var response JSONRPCResponse
+	err := c.withRetry(method, func() error {
+		body, err := c.post(request, 1)
+		if err != nil {
+			return err
+		}
+
+		response = JSONRPCResponse{}
+		if err := json.Unmarshal(body, &response); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+
+		if response.Error != nil {
+			return response.Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// BatchCall sends all requests as a single JSON-RPC batch. Responses are matched
+// back to their requests by ID, so the order the node answers in does not
+// matter. The returned error is only set when the batch as a whole failed.
+func (c *Client) BatchCall(requests []BatchRequest) ([]BatchResult, error) {
+	if len(requests) == 0 {
+		return nil, nil
 	}
 
-	body, err := json.Marshal(request)
+	batch := make([]JSONRPCRequest, len(requests))
+	positions := make(map[int]int, len(requests))
+	for i, req := range requests {
+		batch[i] = JSONRPCRequest{
+			JsonRPC: "2.0",
+			Method:  req.Method,
+			Params:  req.Params,
+			ID:      c.newID(),
+		}
+		positions[batch[i].ID] = i
+	}
+
+	var responses []JSONRPCResponse
+	err := c.withRetry("batch", func() error {
+		body, err := c.post(batch, len(batch))
+		if err != nil {
+			return err
+		}
+
+		// Nodes answer a batch they reject as a whole with a single error object
+		body = bytes.TrimSpace(body)
+		if len(body) > 0 && body[0] != '[' {
+			var response JSONRPCResponse
+			if err := json.Unmarshal(body, &response); err != nil {
+				return fmt.Errorf("failed to decode response: %w", err)
+			}
+			if response.Error != nil {
+				return response.Error
+			}
+			return fmt.Errorf("unexpected non-batch response")
+		}
+
+		responses = nil
+		if err := json.Unmarshal(body, &responses); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(requests))
+	for i := range responses {
+		response := &responses[i]
+		pos, ok := positions[response.ID]
+		if !ok {
+			continue
+		}
+		delete(positions, response.ID)
+
+		if response.Error != nil {
+			results[pos].Error = response.Error
+			continue
+		}
+		results[pos].Response = response
+	}
+
+	for id, pos := range positions {
+		results[pos].Error = fmt.Errorf("no response for request %d (%s)", id, requests[pos].Method)
+	}
+
+	return results, nil
+}
+
+// post sends a JSON-RPC payload of n calls and returns the raw response body
+func (c *Client) post(payload interface{}, n int) ([]byte, error) {
+	body, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
+	c.limiter.wait(n, c.sleep)
+
 	resp, err := c.httpClient.Post(c.endpoint, "application/json", bytes.NewReader(body))
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return nil, &transportError{err: fmt.Errorf("failed to make request: %w", err)}
 	}
 	defer resp.Body.Close()
 
-	var response JSONRPCResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &transportError{err: fmt.Errorf("failed to read response: %w", err)}
 	}
 
-	if response.Error != nil {
-		return nil, fmt.Errorf("rpc error: %s", response.Error.Message)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &HTTPError{
+			StatusCode: resp.StatusCode,
+			Body:       truncate(string(data), 200),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
 	}
+	return data, nil
+}
 
-	return &response, nil
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+func (c *Client) newID() int {
+	return int(c.nextID.Add(1))
 }
@@ -0,0 +1,253 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer answers JSON-RPC requests with handle, which receives the decoded batch
+func newTestServer(t *testing.T, handle func(requests []JSONRPCRequest) interface{}) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var raw json.RawMessage
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&raw))
+
+		var requests []JSONRPCRequest
+		if raw[0] == '[' {
+			require.NoError(t, json.Unmarshal(raw, &requests))
+		} else {
+			var request JSONRPCRequest
+			require.NoError(t, json.Unmarshal(raw, &request))
+			requests = []JSONRPCRequest{request}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(handle(requests))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCall(t *testing.T) {
+	var ids []int
+	server := newTestServer(t, func(requests []JSONRPCRequest) interface{} {
+		ids = append(ids, requests[0].ID)
+		return JSONRPCResponse{JsonRPC: "2.0", Result: "0x3e8", ID: requests[0].ID}
+	})
+	client := NewClient(server.URL)
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Call("eth_blockNumber", []interface{}{})
+		require.NoError(t, err)
+		assert.Equal(t, "0x3e8", resp.Result)
+	}
+	assert.NotEqual(t, ids[0], ids[1])
+}
+
+func TestBatchCall(t *testing.T) {
+	t.Run("MatchesResponsesByID", func(t *testing.T) {
+		server := newTestServer(t, func(requests []JSONRPCRequest) interface{} {
+			// Answer in reverse order, fail the second call and drop the third
+			var responses []JSONRPCResponse
+			for i := len(requests) - 1; i >= 0; i-- {
+				req := requests[i]
+				switch i {
+				case 1:
+					responses = append(responses, JSONRPCResponse{ID: req.ID, Error: &JSONRPCError{Code: -32000, Message: "header not found"}})
+				case 2:
+				default:
+					responses = append(responses, JSONRPCResponse{ID: req.ID, Result: req.Method})
+				}
+			}
+			return responses
+		})
+		client := NewClient(server.URL)
+
+		results, err := client.BatchCall([]BatchRequest{
+			{Method: "eth_blockNumber", Params: []interface{}{}},
+			{Method: "eth_getBlockByNumber", Params: []interface{}{"0x1", true}},
+			{Method: "eth_getBlockByNumber", Params: []interface{}{"0x2", true}},
+			{Method: "eth_chainId", Params: []interface{}{}},
+		})
+		require.NoError(t, err)
+		require.Len(t, results, 4)
+
+		assert.Equal(t, "eth_blockNumber", results[0].Response.Result)
+		assert.EqualError(t, results[1].Error, "rpc error: header not found")
+		assert.Error(t, results[2].Error)
+		assert.Equal(t, "eth_chainId", results[3].Response.Result)
+	})
+
+	t.Run("UniqueIDs", func(t *testing.T) {
+		seen := map[int]bool{}
+		server := newTestServer(t, func(requests []JSONRPCRequest) interface{} {
+			var responses []JSONRPCResponse
+			for _, req := range requests {
+				assert.False(t, seen[req.ID], "duplicate id %d", req.ID)
+				seen[req.ID] = true
+				responses = append(responses, JSONRPCResponse{ID: req.ID, Result: "0x1"})
+			}
+			return responses
+		})
+		client := NewClient(server.URL)
+
+		requests := []BatchRequest{{Method: "eth_blockNumber"}, {Method: "eth_blockNumber"}}
+		for i := 0; i < 2; i++ {
+			_, err := client.BatchCall(requests)
+			require.NoError(t, err)
+		}
+		assert.Len(t, seen, 4)
+	})
+
+	t.Run("RejectedBatch", func(t *testing.T) {
+		server := newTestServer(t, func(requests []JSONRPCRequest) interface{} {
+			return JSONRPCResponse{Error: &JSONRPCError{Code: -32600, Message: "batch too large"}}
+		})
+		client := NewClient(server.URL)
+
+		_, err := client.BatchCall([]BatchRequest{{Method: "eth_blockNumber"}})
+		assert.EqualError(t, err, "rpc error: batch too large")
+	})
+}
+
+func TestRetry(t *testing.T) {
+	config := DefaultClientConfig()
+	config.MaxRetries = 2
+
+	// newRetryClient records the delays instead of sleeping
+	newRetryClient := func(url string) (*Client, *[]time.Duration) {
+		client := NewClientWithConfig(url, config)
+		var delays []time.Duration
+		client.sleep = func(d time.Duration) { delays = append(delays, d) }
+		return client, &delays
+	}
+
+	t.Run("HonorsRetryAfter", func(t *testing.T) {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			switch calls {
+			case 1:
+				w.Header().Set("Retry-After", "7")
+				http.Error(w, "slow down", http.StatusTooManyRequests)
+				return
+			case 2:
+				// Longer than MaxBackoff
+				w.Header().Set("Retry-After", "3600")
+				http.Error(w, "slow down", http.StatusTooManyRequests)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(JSONRPCResponse{Result: "0x1"})
+		}))
+		defer server.Close()
+		client, delays := newRetryClient(server.URL)
+
+		resp, err := client.Call("eth_blockNumber", []interface{}{})
+		require.NoError(t, err)
+		assert.Equal(t, "0x1", resp.Result)
+		assert.Equal(t, []time.Duration{7 * time.Second, config.MaxBackoff}, *delays)
+	})
+
+	t.Run("GivesUpAfterMaxRetries", func(t *testing.T) {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+		client, delays := newRetryClient(server.URL)
+
+		_, err := client.Call("eth_blockNumber", []interface{}{})
+		var httpErr *HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		assert.Equal(t, http.StatusServiceUnavailable, httpErr.StatusCode)
+		assert.Equal(t, 3, calls)
+
+		// Exponential backoff with jitter in the upper half of each step
+		require.Len(t, *delays, 2)
+		assert.GreaterOrEqual(t, (*delays)[0], config.BaseBackoff/2)
+		assert.LessOrEqual(t, (*delays)[0], config.BaseBackoff)
+		assert.GreaterOrEqual(t, (*delays)[1], config.BaseBackoff)
+		assert.LessOrEqual(t, (*delays)[1], 2*config.BaseBackoff)
+	})
+
+	t.Run("RetryableRPCError", func(t *testing.T) {
+		calls := 0
+		server := newTestServer(t, func(requests []JSONRPCRequest) interface{} {
+			calls++
+			if calls == 1 {
+				return JSONRPCResponse{ID: requests[0].ID, Error: &JSONRPCError{Code: -32005, Message: "limit exceeded"}}
+			}
+			return JSONRPCResponse{ID: requests[0].ID, Result: "0x1"}
+		})
+		client, _ := newRetryClient(server.URL)
+
+		_, err := client.Call("eth_blockNumber", []interface{}{})
+		require.NoError(t, err)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("PermanentRPCError", func(t *testing.T) {
+		calls := 0
+		server := newTestServer(t, func(requests []JSONRPCRequest) interface{} {
+			calls++
+			return JSONRPCResponse{ID: requests[0].ID, Error: &JSONRPCError{Code: -32601, Message: "method not found"}}
+		})
+		client, delays := newRetryClient(server.URL)
+
+		_, err := client.Call("debug_traceBlockByNumber", []interface{}{})
+		var rpcErr *JSONRPCError
+		require.ErrorAs(t, err, &rpcErr)
+		assert.Equal(t, -32601, rpcErr.Code)
+		assert.Equal(t, 1, calls)
+		assert.Empty(t, *delays)
+	})
+
+	t.Run("ServerError", func(t *testing.T) {
+		// The generic server error code is only retried for transient messages
+		assert.True(t, IsRetryable(&JSONRPCError{Code: -32000, Message: "header not found"}))
+		assert.True(t, IsRetryable(&JSONRPCError{Code: -32000, Message: "request timed out"}))
+		assert.False(t, IsRetryable(&JSONRPCError{Code: -32000, Message: "execution reverted"}))
+		assert.False(t, IsRetryable(&JSONRPCError{Code: -32000, Message: "nonce too low"}))
+	})
+
+	t.Run("ParseRetryAfter", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		assert.Equal(t, 3*time.Second, parseRetryAfter("3", now))
+		assert.Equal(t, 90*time.Second, parseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now))
+		assert.Zero(t, parseRetryAfter("soon", now))
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter(2, 2)
+	limiter.now = func() time.Time { return now }
+	limiter.last = now
+
+	// The burst is available immediately
+	assert.Zero(t, limiter.reserve(1))
+	assert.Zero(t, limiter.reserve(1))
+
+	// Then tokens arrive at 2 per second
+	assert.Equal(t, 500*time.Millisecond, limiter.reserve(1))
+
+	var slept time.Duration
+	limiter.wait(1, func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	})
+	assert.Equal(t, 500*time.Millisecond, slept)
+
+	// A batch takes a token per call, so the next call waits for all of them
+	now = now.Add(time.Second)
+	assert.Zero(t, limiter.reserve(4))
+	assert.Equal(t, time.Second+500*time.Millisecond, limiter.reserve(1))
+
+	assert.Nil(t, newRateLimiter(0, 1))
+}
@@ -0,0 +1,389 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ethparser/pkg/types"
+)
+
+// MultiConfig holds the failover settings of a MultiClient
+type MultiConfig struct {
+	// FailureThreshold - consecutive failures after which an endpoint is taken out of rotation
+	FailureThreshold int
+
+	// Cooldown - how long an unhealthy endpoint is only used as a last resort
+	Cooldown time.Duration
+
+	// MaxLag - blocks an endpoint may trail the most advanced one before it is lagging
+	MaxLag int
+
+	// Quorum - endpoints that must return the same block hash, 0 or 1 disables quorum mode
+	Quorum int
+}
+
+// DefaultMultiConfig returns the failover settings used when nothing is configured
+func DefaultMultiConfig() MultiConfig {
+	return MultiConfig{
+		FailureThreshold: 3,
+		Cooldown:         30 * time.Second,
+		MaxLag:           5,
+	}
+}
+
+// Endpoint is a named RPC client wrapped by a MultiClient
+type Endpoint struct {
+	URL    string
+	Client RPCClient
+}
+
+// EndpointStatus reports the health of an endpoint
+type EndpointStatus struct {
+	URL                 string    `json:"url"`
+	Healthy             bool      `json:"healthy"`
+	Lagging             bool      `json:"lagging"`
+	LatestBlock         int       `json:"latestBlock"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	UnhealthyUntil      time.Time `json:"unhealthyUntil,omitempty"`
+}
+
+type endpointState struct {
+	Endpoint
+	failures       int
+	unhealthyUntil time.Time
+	latestBlock    int
+	lagging        bool
+}
+
+// MultiClient spreads calls over several endpoints. Calls go to the first
+// healthy, up to date endpoint and fail over to the next one on error.
+// eth_blockNumber is asked from every endpoint to detect lagging nodes, and in
+// quorum mode blocks are only accepted once enough endpoints agree on their hash.
+type MultiClient struct {
+	mu        sync.Mutex
+	endpoints []*endpointState
+	config    MultiConfig
+	now       func() time.Time
+}
+
+// NewMultiClient wraps the endpoints, in order of preference
+func NewMultiClient(endpoints []Endpoint, config MultiConfig) *MultiClient {
+	states := make([]*endpointState, len(endpoints))
+	for i, endpoint := range endpoints {
+		states[i] = &endpointState{Endpoint: endpoint}
+	}
+
+	return &MultiClient{
+		endpoints: states,
+		config:    config,
+		now:       time.Now,
+	}
+}
+
+// NewMultiClientFromURLs creates a Client for every URL and wraps them
+func NewMultiClientFromURLs(urls []string, clientConfig ClientConfig, config MultiConfig) *MultiClient {
+	endpoints := make([]Endpoint, len(urls))
+	for i, url := range urls {
+		endpoints[i] = Endpoint{URL: url, Client: NewClientWithConfig(url, clientConfig)}
+	}
+	return NewMultiClient(endpoints, config)
+}
+
+// Status returns the health of every endpoint
+func (m *MultiClient) Status() []EndpointStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	statuses := make([]EndpointStatus, len(m.endpoints))
+	for i, e := range m.endpoints {
+		statuses[i] = EndpointStatus{
+			URL:                 e.URL,
+			Healthy:             !now.Before(e.unhealthyUntil),
+			Lagging:             e.lagging,
+			LatestBlock:         e.latestBlock,
+			ConsecutiveFailures: e.failures,
+			UnhealthyUntil:      e.unhealthyUntil,
+		}
+	}
+	return statuses
+}
+
+func (m *MultiClient) Call(method string, params interface{}) (*JSONRPCResponse, error) {
+	if method == "eth_blockNumber" {
+		return m.blockNumber(params)
+	}
+	if m.config.Quorum > 1 && isQuorumMethod(method) {
+		results, err := m.quorumBatch([]BatchRequest{{Method: method, Params: params}})
+		if err != nil {
+			return nil, err
+		}
+		return results[0].Response, results[0].Error
+	}
+
+	var lastErr error
+	for _, e := range m.candidates() {
+		resp, err := e.Client.Call(method, params)
+		m.record(e, err)
+		if err == nil {
+			return resp, nil
+		}
+		log.Printf("RPC %s failed on %s, failing over: %v", method, e.URL, err)
+		lastErr = err
+	}
+	return nil, m.exhausted(lastErr)
+}
+
+func (m *MultiClient) BatchCall(requests []BatchRequest) ([]BatchResult, error) {
+	if m.config.Quorum > 1 {
+		for _, req := range requests {
+			if isQuorumMethod(req.Method) {
+				return m.quorumBatch(requests)
+			}
+		}
+	}
+
+	var lastErr error
+	for _, e := range m.candidates() {
+		results, err := batchOn(e.Client, requests)
+		m.record(e, err)
+		if err == nil {
+			return results, nil
+		}
+		log.Printf("RPC batch failed on %s, failing over: %v", e.URL, err)
+		lastErr = err
+	}
+	return nil, m.exhausted(lastErr)
+}
+
+// blockNumber asks every endpoint for its head, flags the ones trailing the
+// most advanced endpoint by more than MaxLag and returns the head of the
+// endpoint calls go to first, so every block up to it can be fetched from there
+func (m *MultiClient) blockNumber(params interface{}) (*JSONRPCResponse, error) {
+	type head struct {
+		resp  *JSONRPCResponse
+		block int
+		err   error
+	}
+
+	heads := make([]head, len(m.endpoints))
+	var wg sync.WaitGroup
+	for i, e := range m.endpoints {
+		wg.Add(1)
+		go func(i int, e *endpointState) {
+			defer wg.Done()
+			resp, err := e.Client.Call("eth_blockNumber", params)
+			if err == nil {
+				heads[i].block, err = ParseBlockNumber(resp.Result)
+			}
+			heads[i].resp, heads[i].err = resp, err
+			m.record(e, err)
+		}(i, e)
+	}
+	wg.Wait()
+
+	best := -1
+	var lastErr error
+	for i, h := range heads {
+		if h.err != nil {
+			lastErr = h.err
+			continue
+		}
+		if best < 0 || h.block > heads[best].block {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil, m.exhausted(lastErr)
+	}
+
+	index := make(map[*endpointState]int, len(m.endpoints))
+	m.mu.Lock()
+	for i, e := range m.endpoints {
+		index[e] = i
+		if heads[i].err != nil {
+			continue
+		}
+		e.latestBlock = heads[i].block
+		lagging := heads[best].block-heads[i].block > m.config.MaxLag
+		if lagging != e.lagging {
+			log.Printf("RPC endpoint %s lagging=%v (block %d, best %d)", e.URL, lagging, heads[i].block, heads[best].block)
+		}
+		e.lagging = lagging
+	}
+	m.mu.Unlock()
+
+	// A more advanced endpoint further down the order may have blocks the
+	// serving one does not have yet
+	for _, e := range m.candidates() {
+		if h := heads[index[e]]; h.err == nil {
+			return h.resp, nil
+		}
+	}
+	return heads[best].resp, nil
+}
+
+// quorumBatch sends the batch to endpoints until every block request has been
+// answered with the same hash by Quorum endpoints. Other requests take the first
+// successful answer.
+func (m *MultiClient) quorumBatch(requests []BatchRequest) ([]BatchResult, error) {
+	results := make([]BatchResult, len(requests))
+	settled := make([]bool, len(requests))
+	votes := make([]map[string]int, len(requests))
+	for i := range votes {
+		votes[i] = make(map[string]int)
+	}
+
+	remaining := len(requests)
+	var lastErr error
+	for _, e := range m.candidates() {
+		answers, err := batchOn(e.Client, requests)
+		m.record(e, err)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		for i, answer := range answers {
+			if settled[i] || answer.Error != nil {
+				continue
+			}
+
+			if !isQuorumMethod(requests[i].Method) {
+				results[i], settled[i] = answer, true
+				remaining--
+				continue
+			}
+
+			hash := resultHash(answer.Response.Result)
+			if hash == "" {
+				continue
+			}
+			votes[i][hash]++
+			if votes[i][hash] >= m.config.Quorum {
+				results[i], settled[i] = answer, true
+				remaining--
+			}
+		}
+
+		if remaining == 0 {
+			return results, nil
+		}
+	}
+
+	if remaining == len(requests) && lastErr != nil {
+		return nil, m.exhausted(lastErr)
+	}
+
+	for i := range results {
+		if !settled[i] {
+			results[i] = BatchResult{Error: fmt.Errorf("quorum of %d not reached for %s: %v", m.config.Quorum, requests[i].Method, votes[i])}
+		}
+	}
+	return results, nil
+}
+
+// candidates orders endpoints for a call: healthy and up to date first, then
+// lagging ones, then those cooling down after failures
+func (m *MultiClient) candidates() []*endpointState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	rank := func(e *endpointState) int {
+		switch {
+		case now.Before(e.unhealthyUntil):
+			return 2
+		case e.lagging:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	ordered := make([]*endpointState, len(m.endpoints))
+	copy(ordered, m.endpoints)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return rank(ordered[i]) < rank(ordered[j])
+	})
+	return ordered
+}
+
+// record updates the health of an endpoint after a call. Only errors that
+// point at the endpoint itself count as failures.
+func (m *MultiClient) record(e *endpointState, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err == nil {
+		e.failures = 0
+		e.unhealthyUntil = time.Time{}
+		return
+	}
+	if !IsRetryable(err) {
+		return
+	}
+
+	e.failures++
+	if e.failures >= m.config.FailureThreshold && !m.now().Before(e.unhealthyUntil) {
+		e.unhealthyUntil = m.now().Add(m.config.Cooldown)
+		log.Printf("RPC endpoint %s unhealthy after %d failures, cooling down for %v", e.URL, e.failures, m.config.Cooldown)
+	}
+}
+
+func (m *MultiClient) exhausted(err error) error {
+	if err == nil {
+		return errors.New("no rpc endpoints configured")
+	}
+	return fmt.Errorf("all rpc endpoints failed: %w", err)
+}
+
+// batchOn sends requests as a batch, or one by one when the client cannot batch
+func batchOn(client RPCClient, requests []BatchRequest) ([]BatchResult, error) {
+	if batcher, ok := client.(BatchCaller); ok {
+		return batcher.BatchCall(requests)
+	}
+
+	results := make([]BatchResult, len(requests))
+	for i, req := range requests {
+		results[i].Response, results[i].Error = client.Call(req.Method, req.Params)
+	}
+	return results, nil
+}
+
+func isQuorumMethod(method string) bool {
+	return method == "eth_getBlockByNumber" || method == "eth_getBlockByHash"
+}
+
+func resultHash(result interface{}) string {
+	block, ok := result.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	hash, _ := block["hash"].(string)
+	return strings.ToLower(hash)
+}
+
+// ParseBlockNumber decodes the result of eth_blockNumber
+func ParseBlockNumber(result interface{}) (int, error) {
+	hex, ok := result.(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected block number %v", result)
+	}
+
+	q, err := types.ParseQuantity(hex)
+	if err != nil {
+		return 0, fmt.Errorf("invalid block number: %w", err)
+	}
+	return q.Int()
+}
+
+var (
+	_ RPCClient   = (*MultiClient)(nil)
+	_ BatchCaller = (*MultiClient)(nil)
+)
@@ -0,0 +1,157 @@
+package rpc
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEndpoint answers calls with a fixed head and block hash, or fails
+type fakeEndpoint struct {
+	mu    sync.Mutex
+	head  int
+	hash  string
+	err   error
+	calls int
+}
+
+func (f *fakeEndpoint) Call(method string, params interface{}) (*JSONRPCResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	switch method {
+	case "eth_blockNumber":
+		return &JSONRPCResponse{Result: fmt.Sprintf("0x%x", f.head)}, nil
+	case "eth_getBlockByNumber":
+		return &JSONRPCResponse{Result: map[string]interface{}{"hash": f.hash}}, nil
+	}
+	return &JSONRPCResponse{Result: method}, nil
+}
+
+func (f *fakeEndpoint) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.err = err
+}
+
+func TestMultiClient(t *testing.T) {
+	unavailable := &HTTPError{StatusCode: 503}
+
+	t.Run("Failover", func(t *testing.T) {
+		primary := &fakeEndpoint{head: 100, err: unavailable}
+		backup := &fakeEndpoint{head: 100}
+
+		config := DefaultMultiConfig()
+		config.FailureThreshold = 2
+		client := NewMultiClient([]Endpoint{{URL: "primary", Client: primary}, {URL: "backup", Client: backup}}, config)
+
+		for i := 0; i < 2; i++ {
+			resp, err := client.Call("eth_chainId", nil)
+			require.NoError(t, err)
+			assert.Equal(t, "eth_chainId", resp.Result)
+		}
+		assert.False(t, client.Status()[0].Healthy)
+
+		// The unhealthy primary is skipped until its cooldown ends
+		_, err := client.Call("eth_chainId", nil)
+		require.NoError(t, err)
+		assert.Equal(t, 2, primary.calls)
+
+		primary.setErr(nil)
+		client.now = func() time.Time { return time.Now().Add(config.Cooldown) }
+		_, err = client.Call("eth_chainId", nil)
+		require.NoError(t, err)
+		assert.Equal(t, 3, primary.calls)
+		assert.True(t, client.Status()[0].Healthy)
+	})
+
+	t.Run("AllEndpointsFail", func(t *testing.T) {
+		client := NewMultiClient([]Endpoint{
+			{URL: "a", Client: &fakeEndpoint{err: unavailable}},
+			{URL: "b", Client: &fakeEndpoint{err: unavailable}},
+		}, DefaultMultiConfig())
+
+		_, err := client.Call("eth_chainId", nil)
+		assert.ErrorIs(t, err, unavailable)
+	})
+
+	t.Run("LaggingEndpoint", func(t *testing.T) {
+		behind := &fakeEndpoint{head: 90}
+		ahead := &fakeEndpoint{head: 100}
+		client := NewMultiClient([]Endpoint{{URL: "behind", Client: behind}, {URL: "ahead", Client: ahead}}, DefaultMultiConfig())
+
+		resp, err := client.Call("eth_blockNumber", []interface{}{})
+		require.NoError(t, err)
+		assert.Equal(t, "0x64", resp.Result)
+
+		status := client.Status()
+		assert.True(t, status[0].Lagging)
+		assert.Equal(t, 90, status[0].LatestBlock)
+		assert.False(t, status[1].Lagging)
+
+		// Lagging endpoints are only used after up to date ones
+		_, err = client.Call("eth_getBalance", nil)
+		require.NoError(t, err)
+		assert.Equal(t, 1, behind.calls)
+		assert.Equal(t, 2, ahead.calls)
+	})
+
+	t.Run("ServingEndpointHead", func(t *testing.T) {
+		// Within MaxLag the preferred endpoint keeps serving, so its head is reported
+		primary := &fakeEndpoint{head: 98}
+		secondary := &fakeEndpoint{head: 100}
+		client := NewMultiClient([]Endpoint{{URL: "primary", Client: primary}, {URL: "secondary", Client: secondary}}, DefaultMultiConfig())
+
+		resp, err := client.Call("eth_blockNumber", []interface{}{})
+		require.NoError(t, err)
+		assert.Equal(t, "0x62", resp.Result)
+		assert.False(t, client.Status()[0].Lagging)
+	})
+
+	t.Run("Quorum", func(t *testing.T) {
+		a := &fakeEndpoint{hash: "0xaaa"}
+		b := &fakeEndpoint{hash: "0xbbb"}
+		c := &fakeEndpoint{hash: "0xaaa"}
+
+		config := DefaultMultiConfig()
+		config.Quorum = 2
+		client := NewMultiClient([]Endpoint{{URL: "a", Client: a}, {URL: "b", Client: b}, {URL: "c", Client: c}}, config)
+
+		resp, err := client.Call("eth_getBlockByNumber", []interface{}{"0x1", true})
+		require.NoError(t, err)
+		assert.Equal(t, "0xaaa", resultHash(resp.Result))
+
+		results, err := client.BatchCall([]BatchRequest{
+			{Method: "eth_getBlockByNumber", Params: []interface{}{"0x1", true}},
+			{Method: "eth_chainId"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "0xaaa", resultHash(results[0].Response.Result))
+		assert.Equal(t, "eth_chainId", results[1].Response.Result)
+
+		c.hash = "0xccc"
+		_, err = client.Call("eth_getBlockByNumber", []interface{}{"0x1", true})
+		assert.ErrorContains(t, err, "quorum of 2 not reached")
+	})
+}
+
+func TestParseBlockNumber(t *testing.T) {
+	n, err := ParseBlockNumber("0x1312d00")
+	require.NoError(t, err)
+	assert.Equal(t, 20000000, n)
+
+	for _, result := range []interface{}{nil, 20000000, "", "0x", "1312d00", "0xzz", "0x10000000000000000"} {
+		_, err := ParseBlockNumber(result)
+		assert.Error(t, err, "%v", result)
+	}
+}
@@ -0,0 +1,67 @@
+package rpc
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket refilled at rate tokens per second up to burst
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// newRateLimiter returns nil, which never blocks, when rate is not positive
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+// wait blocks, using sleep, until a token is available and takes n of them
+func (l *rateLimiter) wait(n int, sleep func(time.Duration)) {
+	if l == nil {
+		return
+	}
+
+	for {
+		delay := l.reserve(n)
+		if delay == 0 {
+			return
+		}
+		sleep(delay)
+	}
+}
+
+// reserve takes n tokens if one is available, otherwise it returns how long to
+// wait for the next one. A batch larger than the burst leaves the bucket in
+// debt, which the following calls wait out.
+func (l *rateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens -= float64(n)
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
@@ -0,0 +1,159 @@
+package rpc
+
+import (
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClientConfig holds the retry and rate limiting settings of a Client
+type ClientConfig struct {
+	// MaxRetries - how many times a failed call is retried, 0 disables retries
+	MaxRetries int
+
+	// BaseBackoff - delay before the first retry, doubled for every following one
+	BaseBackoff time.Duration
+
+	// MaxBackoff - upper bound for a single retry delay
+	MaxBackoff time.Duration
+
+	// RateLimit - maximum requests per second sent to the endpoint, 0 disables the limiter
+	RateLimit float64
+
+	// Burst - number of requests that may be sent at once before RateLimit applies
+	Burst int
+
+	// Timeout - limit for a single HTTP request, 0 means no timeout
+	Timeout time.Duration
+}
+
+// DefaultClientConfig returns the settings used by NewClient
+func DefaultClientConfig() ClientConfig {
+	return ClientConfig{
+		MaxRetries:  3,
+		BaseBackoff: 500 * time.Millisecond,
+		MaxBackoff:  10 * time.Second,
+		Burst:       1,
+		Timeout:     30 * time.Second,
+	}
+}
+
+// Standard and widely used JSON-RPC error codes worth retrying. Everything
+// else (parse errors, unknown methods, invalid params) is permanent.
+var retryableRPCCodes = map[int]bool{
+	-32005: true, // limit exceeded
+	-32603: true, // internal error
+}
+
+// serverErrorCode is the generic code nodes answer both transient failures and
+// reverts, nonce errors and the like with; only the messages below are retried
+const serverErrorCode = -32000
+
+// retryableServerErrors are parts of the messages of transient server errors
+var retryableServerErrors = []string{
+	"header not found", // lagging node, the block is not there yet
+	"timeout",
+	"timed out",
+	"rate limit",
+	"too many requests",
+}
+
+// transportError wraps failures to reach the endpoint or read its answer
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+// IsRetryable reports whether a call that failed with err may succeed when repeated
+func IsRetryable(err error) bool {
+	var transportErr *transportError
+	if errors.As(err, &transportErr) {
+		return true
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode >= 500
+	}
+
+	var rpcErr *JSONRPCError
+	if errors.As(err, &rpcErr) {
+		if rpcErr.Code == serverErrorCode {
+			message := strings.ToLower(rpcErr.Message)
+			for _, part := range retryableServerErrors {
+				if strings.Contains(message, part) {
+					return true
+				}
+			}
+		}
+		return retryableRPCCodes[rpcErr.Code]
+	}
+
+	return false
+}
+
+// withRetry runs fn until it succeeds, fails permanently or runs out of retries
+func (c *Client) withRetry(method string, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil || !IsRetryable(err) || attempt >= c.config.MaxRetries {
+			return err
+		}
+
+		delay := c.backoff(attempt)
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+			// A server asking for a longer pause does not stall the parser beyond MaxBackoff
+			delay = httpErr.RetryAfter
+			if c.config.MaxBackoff > 0 {
+				delay = min(delay, c.config.MaxBackoff)
+			}
+		}
+
+		log.Printf("RPC %s failed (attempt %d/%d), retrying in %v: %v", method, attempt+1, c.config.MaxRetries+1, delay, err)
+		c.sleep(delay)
+	}
+}
+
+// backoff returns the exponential delay before retry number attempt+1, with
+// jitter spreading it over the upper half so that clients do not retry in lockstep
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.config.BaseBackoff << attempt
+	if delay <= 0 || (c.config.MaxBackoff > 0 && delay > c.config.MaxBackoff) {
+		delay = c.config.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,153 @@
+// Package rpctest provides local stand-ins for Ethereum node endpoints in tests
+package rpctest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"ethparser/internal/rpc"
+	"ethparser/internal/websocket"
+)
+
+// WSServer is a local stand-in for a node's WebSocket JSON-RPC endpoint. It
+// answers eth_subscribe("newHeads"), pushes heads on demand, and answers other
+// methods from Results.
+type WSServer struct {
+	URL string
+
+	server *httptest.Server
+
+	mu            sync.Mutex
+	results       map[string]interface{}
+	conns         map[*websocket.Conn]string
+	subscriptions int
+}
+
+// NewWSServer starts a stand-in listening on a local ws:// URL
+func NewWSServer() *WSServer {
+	s := &WSServer{
+		results: make(map[string]interface{}),
+		conns:   make(map[*websocket.Conn]string),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = "ws" + strings.TrimPrefix(s.server.URL, "http")
+	return s
+}
+
+// SetResult makes the stand-in answer method with result
+func (s *WSServer) SetResult(method string, result interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.results[method] = result
+}
+
+// PushHead notifies every newHeads subscriber of a head
+func (s *WSServer) PushHead(head rpc.Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, subID := range s.conns {
+		if subID == "" {
+			continue
+		}
+		message, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"method":  "eth_subscription",
+			"params":  map[string]interface{}{"subscription": subID, "result": head},
+		})
+		_ = conn.WriteMessage(websocket.TextMessage, message)
+	}
+}
+
+// DropConnections closes every open connection, as a node restart would
+func (s *WSServer) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+// Subscriptions returns how many newHeads subscriptions were made so far
+func (s *WSServer) Subscriptions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.subscriptions
+}
+
+// WaitForSubscriptions waits until n subscriptions were made
+func (s *WSServer) WaitForSubscriptions(n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if s.Subscriptions() >= n {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// Close drops all connections and stops the server
+func (s *WSServer) Close() {
+	s.DropConnections()
+	s.server.Close()
+}
+
+func (s *WSServer) serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.conns[conn] = ""
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var request rpc.JSONRPCRequest
+		if err := json.Unmarshal(data, &request); err != nil {
+			return
+		}
+
+		response := rpc.JSONRPCResponse{JsonRPC: "2.0", ID: request.ID}
+
+		s.mu.Lock()
+		if request.Method == "eth_subscribe" {
+			s.subscriptions++
+			subID := fmt.Sprintf("0x%x", s.subscriptions)
+			s.conns[conn] = subID
+			response.Result = subID
+		} else if result, ok := s.results[request.Method]; ok {
+			response.Result = result
+		} else {
+			response.Error = &rpc.JSONRPCError{Code: -32601, Message: "method not found"}
+		}
+		s.mu.Unlock()
+
+		message, _ := json.Marshal(response)
+		if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			return
+		}
+	}
+}
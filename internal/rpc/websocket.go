@@ -0,0 +1,251 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ethparser/internal/websocket"
+)
+
+// DefaultWSTimeout bounds dialing and waiting for a call answer over WebSocket
+const DefaultWSTimeout = 10 * time.Second
+
+// ErrWSClosed is returned by calls on a WebSocket client whose connection dropped
+var ErrWSClosed = errors.New("websocket connection closed")
+
+// Header is the block header delivered by a newHeads subscription
+type Header struct {
+	Number     string `json:"number"`
+	Hash       string `json:"hash"`
+	ParentHash string `json:"parentHash"`
+	Timestamp  string `json:"timestamp"`
+}
+
+// WSClient is a JSON-RPC client over a single WebSocket connection supporting
+// eth_subscribe. Once the connection drops every call fails and subscription
+// channels are closed; callers reconnect by dialing a new client.
+type WSClient struct {
+	conn    *websocket.Conn
+	timeout time.Duration
+	nextID  atomic.Int64
+
+	mu          sync.Mutex
+	pending     map[int]chan *JSONRPCResponse
+	pendingSubs map[int]chan json.RawMessage
+	subs        map[string]chan json.RawMessage
+	err         error
+	done        chan struct{}
+}
+
+// subscriptionMessage is the eth_subscription notification pushed by the node
+type subscriptionMessage struct {
+	ID     *int   `json:"id"`
+	Method string `json:"method"`
+	Params struct {
+		Subscription string          `json:"subscription"`
+		Result       json.RawMessage `json:"result"`
+	} `json:"params"`
+}
+
+// DialWS connects to a ws:// or wss:// JSON-RPC endpoint
+func DialWS(endpoint string) (*WSClient, error) {
+	conn, err := websocket.Dial(endpoint, DefaultWSTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &WSClient{
+		conn:        conn,
+		timeout:     DefaultWSTimeout,
+		pending:     make(map[int]chan *JSONRPCResponse),
+		pendingSubs: make(map[int]chan json.RawMessage),
+		subs:        make(map[string]chan json.RawMessage),
+		done:        make(chan struct{}),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// Done is closed when the connection drops
+func (c *WSClient) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection dropped
+func (c *WSClient) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+func (c *WSClient) Close() error {
+	err := c.conn.Close()
+	c.shutdown(ErrWSClosed)
+	return err
+}
+
+func (c *WSClient) Call(method string, params interface{}) (*JSONRPCResponse, error) {
+	return c.call(method, params, nil)
+}
+
+// call sends a request and waits for its answer. When notifications is set the
+// call creates a subscription, and the read loop registers the channel for the
+// returned subscription ID before handling any later message.
+func (c *WSClient) call(method string, params interface{}, notifications chan json.RawMessage) (*JSONRPCResponse, error) {
+	id := int(c.nextID.Add(1))
+	answer := make(chan *JSONRPCResponse, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.pending[id] = answer
+	if notifications != nil {
+		c.pendingSubs[id] = notifications
+	}
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		delete(c.pendingSubs, id)
+		c.mu.Unlock()
+	}()
+
+	body, err := json.Marshal(JSONRPCRequest{JsonRPC: "2.0", Method: method, Params: params, ID: id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	if err := c.conn.WriteMessage(websocket.TextMessage, body); err != nil {
+		return nil, &transportError{err: fmt.Errorf("failed to send request: %w", err)}
+	}
+
+	select {
+	case response := <-answer:
+		if response.Error != nil {
+			return nil, response.Error
+		}
+		return response, nil
+	case <-c.done:
+		return nil, c.Err()
+	case <-time.After(c.timeout):
+		return nil, &transportError{err: fmt.Errorf("timed out waiting for %s", method)}
+	}
+}
+
+// SubscribeNewHeads starts an eth_subscribe("newHeads") subscription. The
+// returned channel is closed when the connection drops.
+func (c *WSClient) SubscribeNewHeads() (<-chan Header, error) {
+	notifications := make(chan json.RawMessage, 16)
+
+	resp, err := c.call("eth_subscribe", []interface{}{"newHeads"}, notifications)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to new heads: %w", err)
+	}
+	if _, ok := resp.Result.(string); !ok {
+		return nil, fmt.Errorf("unexpected subscription id %v", resp.Result)
+	}
+
+	heads := make(chan Header, 16)
+	go func() {
+		defer close(heads)
+		for raw := range notifications {
+			var header Header
+			if err := json.Unmarshal(raw, &header); err != nil {
+				log.Printf("Invalid newHeads notification: %v", err)
+				continue
+			}
+			select {
+			case heads <- header:
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	return heads, nil
+}
+
+func (c *WSClient) readLoop() {
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.shutdown(&transportError{err: fmt.Errorf("%w: %v", ErrWSClosed, err)})
+			return
+		}
+
+		var msg subscriptionMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			log.Printf("Invalid websocket message: %v", err)
+			continue
+		}
+
+		if msg.Method == "eth_subscription" {
+			c.notify(msg.Params.Subscription, msg.Params.Result)
+			continue
+		}
+
+		if msg.ID == nil {
+			continue
+		}
+
+		var response JSONRPCResponse
+		if err := json.Unmarshal(data, &response); err != nil {
+			log.Printf("Invalid websocket response: %v", err)
+			continue
+		}
+
+		c.mu.Lock()
+		answer, ok := c.pending[response.ID]
+		if sub, isSub := c.pendingSubs[response.ID]; isSub && response.Error == nil {
+			if subID, valid := response.Result.(string); valid {
+				c.subs[subID] = sub
+			}
+		}
+		c.mu.Unlock()
+		if ok {
+			answer <- &response
+		}
+	}
+}
+
+// notify hands a notification to its subscription without blocking the read loop
+func (c *WSClient) notify(subID string, result json.RawMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sub, ok := c.subs[subID]
+	if !ok {
+		return
+	}
+
+	select {
+	case sub <- result:
+	default:
+		log.Printf("Dropping notification for slow subscription %s", subID)
+	}
+}
+
+// shutdown fails pending calls and closes subscriptions, once
+func (c *WSClient) shutdown(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	for id, sub := range c.subs {
+		close(sub)
+		delete(c.subs, id)
+	}
+}
+
+var _ RPCClient = (*WSClient)(nil)
@@ -0,0 +1,61 @@
+package rpc_test
+
+import (
+	"testing"
+	"time"
+
+	"ethparser/internal/rpc"
+	"ethparser/internal/rpc/rpctest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWSClient(t *testing.T) {
+	server := rpctest.NewWSServer()
+	defer server.Close()
+	server.SetResult("eth_blockNumber", "0x64")
+
+	client, err := rpc.DialWS(server.URL)
+	require.NoError(t, err)
+	defer client.Close()
+
+	t.Run("Call", func(t *testing.T) {
+		resp, err := client.Call("eth_blockNumber", []interface{}{})
+		require.NoError(t, err)
+		assert.Equal(t, "0x64", resp.Result)
+
+		_, err = client.Call("eth_unknown", []interface{}{})
+		assert.EqualError(t, err, "rpc error: method not found")
+	})
+
+	t.Run("NewHeads", func(t *testing.T) {
+		heads, err := client.SubscribeNewHeads()
+		require.NoError(t, err)
+
+		server.PushHead(rpc.Header{Number: "0x65", Hash: "0xabc", ParentHash: "0xdef"})
+
+		select {
+		case head := <-heads:
+			assert.Equal(t, "0x65", head.Number)
+			assert.Equal(t, "0xabc", head.Hash)
+		case <-time.After(time.Second):
+			t.Fatal("No head received")
+		}
+
+		// Dropping the connection closes the subscription and fails later calls
+		server.DropConnections()
+
+		select {
+		case _, ok := <-heads:
+			assert.False(t, ok)
+		case <-time.After(time.Second):
+			t.Fatal("Subscription not closed after the connection dropped")
+		}
+
+		<-client.Done()
+		_, err = client.Call("eth_blockNumber", []interface{}{})
+		assert.ErrorIs(t, err, rpc.ErrWSClosed)
+		assert.True(t, rpc.IsRetryable(err))
+	})
+}
@@ -0,0 +1,691 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"ethparser/pkg/types"
+)
+
+const (
+	snapshotFileName = "snapshot.json"
+	logFileName      = "journal.log"
+
+	// DefaultSnapshotInterval is the number of journal entries written before the
+	// journal is compacted into a new snapshot
+	DefaultSnapshotInterval = 1000
+)
+
+// Journal operations
+const (
+	opSubscribe       = "subscribe"
+	opUnsubscribe     = "unsubscribe"
+	opAddTransaction  = "add_transaction"
+	opAddTransfer     = "add_token_transfer"
+	opAddNFTTransfer  = "add_nft_transfer"
+	opAddInternalTx   = "add_internal_transaction"
+	opSetCurrentBlock = "set_current_block"
+	opRemoveFrom      = "remove_transactions_from"
+	opUpdateStatuses  = "update_statuses"
+	opBlockFailure    = "block_failure"
+	opRemoveFailed    = "remove_failed_block"
+	opAddDelivery     = "add_webhook_delivery"
+	opUpdateDelivery  = "update_webhook_delivery"
+	opRemoveDelivery  = "remove_webhook_delivery"
+	opAddAPIKey       = "add_api_key"
+	opRemoveAPIKey    = "remove_api_key"
+)
+
+// journalEntry is a single mutation appended to the journal
+type journalEntry struct {
+	Op           string                     `json:"op"`
+	Seq          int64                      `json:"seq"`
+	Tenant       string                     `json:"tenant,omitempty"`
+	Address      string                     `json:"address,omitempty"`
+	Purge        bool                       `json:"purge,omitempty"`
+	Subscription *types.Subscription        `json:"subscription,omitempty"`
+	Transaction  *types.ParsedTransaction   `json:"transaction,omitempty"`
+	Transfer     *types.TokenTransfer       `json:"transfer,omitempty"`
+	NFTTransfer  *types.NFTTransfer         `json:"nftTransfer,omitempty"`
+	InternalTx   *types.InternalTransaction `json:"internalTransaction,omitempty"`
+	Delivery     *types.WebhookDelivery     `json:"delivery,omitempty"`
+	DeliveryID   int                        `json:"deliveryId,omitempty"`
+	APIKey       *types.APIKey              `json:"apiKey,omitempty"`
+	APIKeyID     int                        `json:"apiKeyId,omitempty"`
+	Block        int                        `json:"block,omitempty"`
+	Finalized    int                        `json:"finalized,omitempty"`
+	Reason       string                     `json:"reason,omitempty"`
+	At           int64                      `json:"at,omitempty"`
+}
+
+// snapshotState is the full storage state written on compaction
+type snapshotState struct {
+	Seq            int64                                  `json:"seq"` // last journal entry the snapshot covers
+	Subscriptions  []types.Subscription                   `json:"subscriptions"`
+	RetainedRanges []retainedRange                        `json:"retainedRanges"`
+	Transactions   map[string][]types.ParsedTransaction   `json:"transactions"`
+	TokenTransfers map[string][]types.TokenTransfer       `json:"tokenTransfers"`
+	NFTTransfers   map[string][]types.NFTTransfer         `json:"nftTransfers"`
+	InternalTxs    map[string][]types.InternalTransaction `json:"internalTransactions"`
+	FailedBlocks   []types.FailedBlock                    `json:"failedBlocks"`
+	Deliveries     []types.WebhookDelivery                `json:"webhookDeliveries"`
+	LastDelivery   int                                    `json:"lastDeliveryId"`
+	APIKeys        []types.APIKey                         `json:"apiKeys"`
+	LastAPIKey     int                                    `json:"lastApiKeyId"`
+	CurrentBlock   int                                    `json:"currentBlock"`
+}
+
+// retainedRange is the history a tenant kept of an address after unsubscribing
+type retainedRange struct {
+	Tenant  string `json:"tenant"`
+	Address string `json:"address"`
+	blockRange
+}
+
+// FileStorage is a durable Storage keeping its state in memory and recording
+// every mutation in an append-only journal, periodically compacted into a snapshot
+type FileStorage struct {
+	mu               sync.Mutex
+	mem              *MemoryStorage
+	dir              string
+	journal          *os.File
+	entries          int
+	seq              int64 // last journal entry written
+	failed           error // journal write failure, until a snapshot covers the state again
+	snapshotInterval int
+	logger           *log.Logger
+}
+
+// NewFileStorage opens (or creates) a file storage in dir and restores the
+// previous state from its snapshot and journal
+func NewFileStorage(dir string, logger *log.Logger) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	s := &FileStorage{
+		mem:              NewMemoryStorage(logger),
+		dir:              dir,
+		snapshotInterval: DefaultSnapshotInterval,
+		logger:           logger,
+	}
+
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+
+	clean, err := s.replayJournal()
+	if err != nil {
+		return nil, err
+	}
+
+	s.journal, err = os.OpenFile(s.path(logFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	// A torn write at the end of the journal cannot be appended to safely, so
+	// fold everything that was readable into a fresh snapshot
+	if !clean {
+		if err := s.compact(); err != nil {
+			s.journal.Close()
+			return nil, err
+		}
+	}
+
+	s.logger.Printf("Restored file storage from %s: current block %d", dir, s.mem.GetCurrentBlock())
+	return s, nil
+}
+
+// SetSnapshotInterval changes how many journal entries trigger a compaction
+func (s *FileStorage) SetSnapshotInterval(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n > 0 {
+		s.snapshotInterval = n
+	}
+}
+
+func (s *FileStorage) IsSubscribed(address string) bool {
+	return s.mem.IsSubscribed(address)
+}
+
+func (s *FileStorage) Subscribe(address string) bool {
+	return s.AddSubscription(types.Subscription{Address: address})
+}
+
+func (s *FileStorage) AddSubscription(sub types.Subscription) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub.Address = types.NormalizeAddress(sub.Address)
+
+	if !s.mem.AddSubscription(sub) {
+		return false
+	}
+
+	s.append(journalEntry{Op: opSubscribe, Address: sub.Address, Subscription: &sub})
+	return true
+}
+
+func (s *FileStorage) Unsubscribe(tenant, address string, purge bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tenant = tenantOrDefault(tenant)
+	address = types.NormalizeAddress(address)
+
+	if !s.mem.Unsubscribe(tenant, address, purge) {
+		return false
+	}
+
+	s.append(journalEntry{Op: opUnsubscribe, Tenant: tenant, Address: address, Purge: purge})
+	return true
+}
+
+func (s *FileStorage) GetSubscription(tenant, address string) (types.Subscription, bool) {
+	return s.mem.GetSubscription(tenant, address)
+}
+
+func (s *FileStorage) GetSubscribers(address string) []types.Subscription {
+	return s.mem.GetSubscribers(address)
+}
+
+func (s *FileStorage) GetSubscriptions(tenant string) []types.Subscription {
+	return s.mem.GetSubscriptions(tenant)
+}
+
+func (s *FileStorage) AddTransaction(tx types.ParsedTransaction) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.mem.AddTransaction(tx) {
+		return false
+	}
+
+	s.append(journalEntry{Op: opAddTransaction, Transaction: &tx})
+	return true
+}
+
+func (s *FileStorage) GetTransactions(tenant, address string) []types.ParsedTransaction {
+	return s.mem.GetTransactions(tenant, address)
+}
+
+func (s *FileStorage) QueryTransactions(query types.TransactionQuery) types.TransactionPage {
+	return s.mem.QueryTransactions(query)
+}
+
+func (s *FileStorage) GetTransaction(tenant, hash string) (types.TransactionLookup, bool) {
+	return s.mem.GetTransaction(tenant, hash)
+}
+
+func (s *FileStorage) GetBlockTransactions(tenant string, block int) []types.TransactionLookup {
+	return s.mem.GetBlockTransactions(tenant, block)
+}
+
+func (s *FileStorage) AddTokenTransfer(transfer types.TokenTransfer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.mem.AddTokenTransfer(transfer) {
+		return false
+	}
+
+	s.append(journalEntry{Op: opAddTransfer, Transfer: &transfer})
+	return true
+}
+
+func (s *FileStorage) GetTokenTransfers(tenant, address string) []types.TokenTransfer {
+	return s.mem.GetTokenTransfers(tenant, address)
+}
+
+func (s *FileStorage) AddInternalTransaction(tx types.InternalTransaction) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.mem.AddInternalTransaction(tx) {
+		return false
+	}
+
+	s.append(journalEntry{Op: opAddInternalTx, InternalTx: &tx})
+	return true
+}
+
+func (s *FileStorage) GetInternalTransactions(tenant, address string) []types.InternalTransaction {
+	return s.mem.GetInternalTransactions(tenant, address)
+}
+
+func (s *FileStorage) AddNFTTransfer(transfer types.NFTTransfer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.mem.AddNFTTransfer(transfer) {
+		return false
+	}
+
+	s.append(journalEntry{Op: opAddNFTTransfer, NFTTransfer: &transfer})
+	return true
+}
+
+func (s *FileStorage) GetNFTTransfers(tenant, address string) []types.NFTTransfer {
+	return s.mem.GetNFTTransfers(tenant, address)
+}
+
+func (s *FileStorage) RemoveTransactionsFrom(block int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := s.mem.RemoveTransactionsFrom(block)
+	s.append(journalEntry{Op: opRemoveFrom, Block: block})
+	return removed
+}
+
+func (s *FileStorage) UpdateStatuses(confirmedBlock, finalizedBlock int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated := s.mem.UpdateStatuses(confirmedBlock, finalizedBlock)
+	if updated > 0 {
+		s.append(journalEntry{Op: opUpdateStatuses, Block: confirmedBlock, Finalized: finalizedBlock})
+	}
+	return updated
+}
+
+func (s *FileStorage) RecordBlockFailure(block int, reason string, at int64) types.FailedBlock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failed := s.mem.RecordBlockFailure(block, reason, at)
+	s.append(journalEntry{Op: opBlockFailure, Block: block, Reason: reason, At: at})
+	return failed
+}
+
+func (s *FileStorage) RemoveFailedBlock(block int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mem.RemoveFailedBlock(block)
+	s.append(journalEntry{Op: opRemoveFailed, Block: block})
+}
+
+func (s *FileStorage) GetFailedBlocks() []types.FailedBlock {
+	return s.mem.GetFailedBlocks()
+}
+
+func (s *FileStorage) AddWebhookDelivery(delivery types.WebhookDelivery) types.WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delivery = s.mem.AddWebhookDelivery(delivery)
+	s.append(journalEntry{Op: opAddDelivery, Delivery: &delivery})
+	return delivery
+}
+
+func (s *FileStorage) UpdateWebhookDelivery(delivery types.WebhookDelivery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mem.UpdateWebhookDelivery(delivery)
+	s.append(journalEntry{Op: opUpdateDelivery, Delivery: &delivery})
+}
+
+func (s *FileStorage) RemoveWebhookDelivery(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mem.RemoveWebhookDelivery(id)
+	s.append(journalEntry{Op: opRemoveDelivery, DeliveryID: id})
+}
+
+func (s *FileStorage) GetWebhookDeliveries(tenant, status string) []types.WebhookDelivery {
+	return s.mem.GetWebhookDeliveries(tenant, status)
+}
+
+func (s *FileStorage) AddAPIKey(key types.APIKey) types.APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key = s.mem.AddAPIKey(key)
+	s.append(journalEntry{Op: opAddAPIKey, APIKey: &key})
+	return key
+}
+
+func (s *FileStorage) GetAPIKeys() []types.APIKey {
+	return s.mem.GetAPIKeys()
+}
+
+func (s *FileStorage) GetAPIKeyByHash(hash string) (types.APIKey, bool) {
+	return s.mem.GetAPIKeyByHash(hash)
+}
+
+func (s *FileStorage) RemoveAPIKey(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.mem.RemoveAPIKey(id) {
+		return false
+	}
+
+	s.append(journalEntry{Op: opRemoveAPIKey, APIKeyID: id})
+	return true
+}
+
+func (s *FileStorage) SetCurrentBlock(block int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// While the journal is failing the cursor only moves once a snapshot has
+	// recorded the parsed blocks, so none of them are skipped after a restart
+	if s.failed != nil {
+		if err := s.compact(); err != nil {
+			s.logger.Printf("Holding block cursor at %d, storage failed: %v", s.mem.GetCurrentBlock(), err)
+			return
+		}
+	}
+
+	s.mem.SetCurrentBlock(block)
+	s.append(journalEntry{Op: opSetCurrentBlock, Block: block})
+}
+
+func (s *FileStorage) GetCurrentBlock() int {
+	return s.mem.GetCurrentBlock()
+}
+
+// Close compacts the journal into a snapshot and releases the journal file
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.compact(); err != nil {
+		return err
+	}
+	return s.journal.Close()
+}
+
+// Err returns the journal failure that keeps mutations from being recorded,
+// nil once a snapshot covers them again
+func (s *FileStorage) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.failed
+}
+
+// append writes an entry to the journal, compacting once the interval is reached.
+// Must be called with s.mu held.
+func (s *FileStorage) append(entry journalEntry) {
+	// The mutation is already applied in memory, so the next snapshot covers it
+	// even when it cannot be written
+	s.seq++
+	entry.Seq = s.seq
+
+	// Entries after a missing one cannot be replayed, nothing is appended
+	// until a snapshot is written
+	if s.failed != nil {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		s.fail(fmt.Errorf("failed to encode journal entry %s: %w", entry.Op, err))
+		return
+	}
+
+	if _, err := s.journal.Write(append(data, '\n')); err != nil {
+		s.fail(fmt.Errorf("failed to write journal entry %s: %w", entry.Op, err))
+		return
+	}
+	if err := s.journal.Sync(); err != nil {
+		s.fail(fmt.Errorf("failed to sync journal: %w", err))
+		return
+	}
+
+	s.entries++
+	if s.entries >= s.snapshotInterval {
+		if err := s.compact(); err != nil {
+			s.logger.Printf("Failed to compact journal: %v", err)
+		}
+	}
+}
+
+// fail marks the journal as missing mutations. Must be called with s.mu held.
+func (s *FileStorage) fail(err error) {
+	s.logger.Printf("File storage failed, keeping mutations in memory until the next snapshot: %v", err)
+	s.failed = err
+}
+
+// apply replays a journal entry against the in-memory state
+func (s *FileStorage) apply(entry journalEntry) error {
+	switch entry.Op {
+	case opSubscribe:
+		if entry.Subscription == nil {
+			return fmt.Errorf("journal entry %s without subscription", entry.Op)
+		}
+		s.mem.AddSubscription(*entry.Subscription)
+	case opUnsubscribe:
+		s.mem.Unsubscribe(entry.Tenant, entry.Address, entry.Purge)
+	case opAddTransaction:
+		if entry.Transaction == nil {
+			return fmt.Errorf("journal entry %s without transaction", entry.Op)
+		}
+		s.mem.AddTransaction(*entry.Transaction)
+	case opAddTransfer:
+		if entry.Transfer == nil {
+			return fmt.Errorf("journal entry %s without transfer", entry.Op)
+		}
+		s.mem.AddTokenTransfer(*entry.Transfer)
+	case opAddInternalTx:
+		if entry.InternalTx == nil {
+			return fmt.Errorf("journal entry %s without internal transaction", entry.Op)
+		}
+		s.mem.AddInternalTransaction(*entry.InternalTx)
+	case opAddNFTTransfer:
+		if entry.NFTTransfer == nil {
+			return fmt.Errorf("journal entry %s without NFT transfer", entry.Op)
+		}
+		s.mem.AddNFTTransfer(*entry.NFTTransfer)
+	case opSetCurrentBlock:
+		s.mem.SetCurrentBlock(entry.Block)
+	case opRemoveFrom:
+		s.mem.RemoveTransactionsFrom(entry.Block)
+	case opUpdateStatuses:
+		s.mem.UpdateStatuses(entry.Block, entry.Finalized)
+	case opBlockFailure:
+		s.mem.RecordBlockFailure(entry.Block, entry.Reason, entry.At)
+	case opRemoveFailed:
+		s.mem.RemoveFailedBlock(entry.Block)
+	case opAddDelivery, opUpdateDelivery:
+		if entry.Delivery == nil {
+			return fmt.Errorf("journal entry %s without delivery", entry.Op)
+		}
+		if entry.Op == opAddDelivery {
+			s.mem.AddWebhookDelivery(*entry.Delivery)
+		} else {
+			s.mem.UpdateWebhookDelivery(*entry.Delivery)
+		}
+	case opRemoveDelivery:
+		s.mem.RemoveWebhookDelivery(entry.DeliveryID)
+	case opAddAPIKey:
+		if entry.APIKey == nil {
+			return fmt.Errorf("journal entry %s without API key", entry.Op)
+		}
+		s.mem.AddAPIKey(*entry.APIKey)
+	case opRemoveAPIKey:
+		s.mem.RemoveAPIKey(entry.APIKeyID)
+	default:
+		return fmt.Errorf("unknown journal operation %q", entry.Op)
+	}
+	return nil
+}
+
+// compact writes the current state to a new snapshot and truncates the journal.
+// Must be called with s.mu held.
+func (s *FileStorage) compact() error {
+	data, err := json.Marshal(s.snapshot())
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	tmp := s.path(snapshotFileName + ".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, s.path(snapshotFileName)); err != nil {
+		return fmt.Errorf("failed to replace snapshot: %w", err)
+	}
+
+	// Entries left behind by a crash before the truncation are skipped on
+	// replay, the snapshot records the last one it covers
+	if err := s.journal.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate journal: %w", err)
+	}
+	s.entries = 0
+	s.failed = nil
+
+	s.logger.Printf("Compacted storage snapshot at block %d", s.mem.GetCurrentBlock())
+	return nil
+}
+
+func (s *FileStorage) snapshot() snapshotState {
+	s.mem.mu.RLock()
+	defer s.mem.mu.RUnlock()
+
+	state := snapshotState{
+		Seq:            s.seq,
+		Subscriptions:  make([]types.Subscription, 0, len(s.mem.subscriptions)),
+		RetainedRanges: make([]retainedRange, 0),
+		Transactions:   s.mem.transactions,
+		TokenTransfers: s.mem.tokenTransfers,
+		NFTTransfers:   s.mem.nftTransfers,
+		InternalTxs:    s.mem.internalTxs,
+		FailedBlocks:   make([]types.FailedBlock, 0, len(s.mem.failedBlocks)),
+		Deliveries:     make([]types.WebhookDelivery, 0, len(s.mem.deliveries)),
+		LastDelivery:   s.mem.lastDeliveryID,
+		APIKeys:        make([]types.APIKey, 0, len(s.mem.apiKeys)),
+		LastAPIKey:     s.mem.lastAPIKeyID,
+		CurrentBlock:   s.mem.currentBlock,
+	}
+	for _, tenants := range s.mem.subscriptions {
+		for _, sub := range tenants {
+			state.Subscriptions = append(state.Subscriptions, sub)
+		}
+	}
+	for address, tenants := range s.mem.retained {
+		for tenant, r := range tenants {
+			state.RetainedRanges = append(state.RetainedRanges, retainedRange{Tenant: tenant, Address: address, blockRange: r})
+		}
+	}
+	for _, failed := range s.mem.failedBlocks {
+		state.FailedBlocks = append(state.FailedBlocks, failed)
+	}
+	for _, delivery := range s.mem.deliveries {
+		state.Deliveries = append(state.Deliveries, delivery)
+	}
+	for _, key := range s.mem.apiKeys {
+		state.APIKeys = append(state.APIKeys, key)
+	}
+	return state
+}
+
+func (s *FileStorage) loadSnapshot() error {
+	data, err := os.ReadFile(s.path(snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var state snapshotState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+
+	for _, sub := range state.Subscriptions {
+		s.mem.AddSubscription(sub)
+	}
+	for _, r := range state.RetainedRanges {
+		s.mem.retain(r.Tenant, r.Address, r.blockRange)
+	}
+	for address, txs := range state.Transactions {
+		for _, tx := range txs {
+			s.mem.storeTransaction(address, tx)
+		}
+	}
+	for address, transfers := range state.TokenTransfers {
+		for _, transfer := range transfers {
+			s.mem.storeTokenTransfer(address, transfer)
+		}
+	}
+	for address, txs := range state.InternalTxs {
+		for _, tx := range txs {
+			s.mem.storeInternalTransaction(address, tx)
+		}
+	}
+	for address, transfers := range state.NFTTransfers {
+		for _, transfer := range transfers {
+			s.mem.storeNFTTransfer(address, transfer)
+		}
+	}
+	for _, failed := range state.FailedBlocks {
+		s.mem.failedBlocks[failed.Number] = failed
+	}
+	for _, delivery := range state.Deliveries {
+		s.mem.deliveries[delivery.ID] = delivery
+	}
+	for _, key := range state.APIKeys {
+		s.mem.AddAPIKey(key)
+	}
+	s.mem.lastDeliveryID = state.LastDelivery
+	s.mem.lastAPIKeyID = max(s.mem.lastAPIKeyID, state.LastAPIKey)
+	s.mem.currentBlock = state.CurrentBlock
+	s.seq = state.Seq
+	return nil
+}
+
+// replayJournal applies the journal entries the snapshot does not cover. It
+// reports false when the journal ends with an entry that could not be decoded.
+func (s *FileStorage) replayJournal() (bool, error) {
+	f, err := os.Open(s.path(logFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer f.Close()
+
+	covered := s.seq
+	decoder := json.NewDecoder(f)
+	for {
+		var entry journalEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			s.logger.Printf("Journal truncated after %d entries: %v", s.entries, err)
+			return false, nil
+		}
+
+		if entry.Seq <= covered {
+			continue
+		}
+		if err := s.apply(entry); err != nil {
+			return false, err
+		}
+		s.seq = max(s.seq, entry.Seq)
+		s.entries++
+	}
+}
+
+func (s *FileStorage) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ethparser/pkg/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStorage(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	dir := t.TempDir()
+
+	tx := types.ParsedTransaction{
+		Hash:        "0xabc",
+		From:        "0x123",
+		To:          "0x456",
+		Value:       "0xde0b6b3a7640000",
+		BlockNumber: 1000,
+		Timestamp:   1612345678,
+	}
+
+	t.Run("RestoreFromJournal", func(t *testing.T) {
+		storage, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+
+		assert.True(t, storage.Subscribe("0x123"))
+		storage.AddTransaction(tx)
+		storage.AddTokenTransfer(types.TokenTransfer{TxHash: "0xdef", Token: "0xtoken", To: "0x123", Amount: "42", BlockNumber: 1000})
+		storage.AddInternalTransaction(types.InternalTransaction{TxHash: "0xdef", TraceAddress: "0.1", From: "0x456", To: "0x123", Value: "0x1", BlockNumber: 1000})
+		storage.AddNFTTransfer(types.NFTTransfer{TxHash: "0xdef", LogIndex: 1, Contract: "0xnft", From: "0x123", TokenID: "1", Amount: "1", BlockNumber: 1000})
+		storage.SetCurrentBlock(1000)
+		storage.RecordBlockFailure(1001, "timeout", 1700000000)
+		storage.RecordBlockFailure(1001, "timeout", 1700000005)
+		storage.RecordBlockFailure(1002, "timeout", 1700000000)
+		storage.RemoveFailedBlock(1002)
+
+		// Reopen without closing, as after a crash
+		restored, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+
+		assert.True(t, restored.IsSubscribed("0x123"))
+		assert.False(t, restored.Subscribe("0x123"))
+		assert.Equal(t, 1000, restored.GetCurrentBlock())
+		txs := restored.GetTransactions("", "0x123")
+		assert.Len(t, txs, 1)
+		assert.Equal(t, tx.Hash, txs[0].Hash)
+		assert.Len(t, restored.GetTokenTransfers("", "0x123"), 1)
+		assert.Len(t, restored.GetNFTTransfers("", "0x123"), 1)
+		assert.Len(t, restored.GetInternalTransactions("", "0x123"), 1)
+		assert.Equal(t, []types.FailedBlock{{
+			Number: 1001, Attempts: 2, LastError: "timeout", FirstFailedAt: 1700000000, LastAttemptAt: 1700000005,
+		}}, restored.GetFailedBlocks())
+		require.NoError(t, restored.Close())
+	})
+
+	t.Run("RestoreFromSnapshot", func(t *testing.T) {
+		storage, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+
+		storage.SetCurrentBlock(1001)
+		require.NoError(t, storage.Close())
+
+		// Close compacts everything into the snapshot
+		info, err := os.Stat(filepath.Join(dir, logFileName))
+		require.NoError(t, err)
+		assert.Zero(t, info.Size())
+
+		restored, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+
+		assert.True(t, restored.IsSubscribed("0x123"))
+		assert.Equal(t, 1001, restored.GetCurrentBlock())
+		assert.Len(t, restored.GetTransactions("", "0x123"), 1)
+		assert.Len(t, restored.GetTokenTransfers("", "0x123"), 1)
+		assert.Len(t, restored.GetNFTTransfers("", "0x123"), 1)
+		assert.Len(t, restored.GetInternalTransactions("", "0x123"), 1)
+		assert.Len(t, restored.GetFailedBlocks(), 1)
+		require.NoError(t, restored.Close())
+	})
+
+	t.Run("Subscriptions", func(t *testing.T) {
+		dir := t.TempDir()
+		storage, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+
+		sub := types.Subscription{Tenant: "acme", Address: "0xaaa", Label: "treasury", Tags: []string{"dao"}, StartBlock: 900, CreatedAt: 1700000000}
+		assert.True(t, storage.AddSubscription(sub))
+		assert.True(t, storage.Subscribe("0xbbb"))
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x1", From: "0xbbb", BlockNumber: 1000})
+		assert.True(t, storage.Unsubscribe(types.DefaultTenant, "0xbbb", true))
+
+		restored, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+		assert.Equal(t, []types.Subscription{sub}, restored.GetSubscriptions(""))
+		assert.Empty(t, restored.GetTransactions("", "0xbbb"))
+		require.NoError(t, restored.Close())
+
+		// The snapshot keeps the metadata too
+		again, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+		assert.Equal(t, []types.Subscription{sub}, again.GetSubscriptions(""))
+	})
+
+	t.Run("TenantsAndAPIKeys", func(t *testing.T) {
+		dir := t.TempDir()
+		storage, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+
+		storage.AddSubscription(types.Subscription{Tenant: "acme", Address: "0xccc", VisibleFrom: 900})
+		storage.AddSubscription(types.Subscription{Tenant: "globex", Address: "0xccc"})
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x0", To: "0xccc", BlockNumber: 800})
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x1", To: "0xccc", BlockNumber: 1000})
+		storage.SetCurrentBlock(1000)
+		assert.True(t, storage.Unsubscribe("acme", "0xccc", false))
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x2", To: "0xccc", BlockNumber: 1001})
+		assert.True(t, storage.Unsubscribe("globex", "0xccc", true))
+
+		kept := storage.AddAPIKey(types.APIKey{Tenant: "acme", Prefix: "ethp_aaaa", Hash: "hash-1"})
+		revoked := storage.AddAPIKey(types.APIKey{Tenant: "globex", Prefix: "ethp_bbbb", Hash: "hash-2"})
+		assert.True(t, storage.RemoveAPIKey(revoked.ID))
+
+		check := func(restored *FileStorage) {
+			// acme kept the blocks it saw while subscribed, globex purged only its own view
+			assert.Len(t, restored.GetTransactions("acme", "0xccc"), 1)
+			assert.Len(t, restored.GetTransactions("", "0xccc"), 3)
+			assert.Empty(t, restored.GetTransactions("globex", "0xccc"))
+			assert.False(t, restored.IsSubscribed("0xccc"))
+
+			assert.Equal(t, []types.APIKey{kept}, restored.GetAPIKeys())
+			_, ok := restored.GetAPIKeyByHash("hash-2")
+			assert.False(t, ok)
+			assert.Equal(t, revoked.ID+1, restored.AddAPIKey(types.APIKey{Hash: "hash-3"}).ID)
+		}
+
+		restored, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+		check(restored)
+		require.NoError(t, restored.Close())
+
+		// The snapshot, written on close, keeps the same state
+		again, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+		assert.Len(t, again.GetTransactions("acme", "0xccc"), 1)
+		assert.Len(t, again.GetAPIKeys(), 2)
+	})
+
+	t.Run("WebhookDeliveries", func(t *testing.T) {
+		dir := t.TempDir()
+		storage, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+
+		first := storage.AddWebhookDelivery(types.WebhookDelivery{URL: "http://hook", Address: "0xaaa", Status: types.DeliveryPending})
+		second := storage.AddWebhookDelivery(types.WebhookDelivery{URL: "http://hook", Address: "0xaaa", Status: types.DeliveryPending})
+		third := storage.AddWebhookDelivery(types.WebhookDelivery{URL: "http://hook", Address: "0xaaa", Status: types.DeliveryPending})
+		assert.Equal(t, []int{1, 2, 3}, []int{first.ID, second.ID, third.ID})
+
+		second.Status = types.DeliveryDead
+		second.Attempts = 8
+		second.LastError = "webhook answered 500 Internal Server Error"
+		storage.UpdateWebhookDelivery(second)
+		storage.RemoveWebhookDelivery(first.ID)
+
+		restored, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+		assert.Equal(t, []types.WebhookDelivery{third}, restored.GetWebhookDeliveries("", types.DeliveryPending))
+		assert.Equal(t, []types.WebhookDelivery{second}, restored.GetWebhookDeliveries("", types.DeliveryDead))
+		assert.Len(t, restored.GetWebhookDeliveries("", ""), 2)
+		require.NoError(t, restored.Close())
+
+		// IDs are not reused after a restart
+		again, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+		assert.Equal(t, 4, again.AddWebhookDelivery(types.WebhookDelivery{Status: types.DeliveryPending}).ID)
+	})
+
+	t.Run("CompactOnInterval", func(t *testing.T) {
+		storage, err := NewFileStorage(t.TempDir(), logger)
+		require.NoError(t, err)
+		storage.SetSnapshotInterval(2)
+
+		storage.SetCurrentBlock(1)
+		storage.SetCurrentBlock(2)
+
+		info, err := os.Stat(filepath.Join(storage.dir, logFileName))
+		require.NoError(t, err)
+		assert.Zero(t, info.Size())
+		assert.FileExists(t, filepath.Join(storage.dir, snapshotFileName))
+	})
+
+	t.Run("TornJournalWrite", func(t *testing.T) {
+		dir := t.TempDir()
+		storage, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+		storage.SetCurrentBlock(5)
+
+		f, err := os.OpenFile(filepath.Join(dir, logFileName), os.O_WRONLY|os.O_APPEND, 0o644)
+		require.NoError(t, err)
+		_, err = f.WriteString(`{"op":"set_current_bl`)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		restored, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+		assert.Equal(t, 5, restored.GetCurrentBlock())
+
+		restored.SetCurrentBlock(6)
+		again, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+		assert.Equal(t, 6, again.GetCurrentBlock())
+	})
+
+	t.Run("CrashBeforeTruncate", func(t *testing.T) {
+		dir := t.TempDir()
+		storage, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+		storage.RecordBlockFailure(7, "timeout", 1)
+		storage.RecordBlockFailure(7, "timeout", 2)
+
+		// The snapshot is in place but the journal was not truncated yet
+		journal, err := os.ReadFile(filepath.Join(dir, logFileName))
+		require.NoError(t, err)
+		require.NoError(t, storage.compact())
+		require.NoError(t, os.WriteFile(filepath.Join(dir, logFileName), journal, 0o644))
+		storage.SetCurrentBlock(8)
+
+		restored, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+		require.Len(t, restored.GetFailedBlocks(), 1)
+		assert.Equal(t, 2, restored.GetFailedBlocks()[0].Attempts)
+		assert.Equal(t, 8, restored.GetCurrentBlock())
+	})
+
+	t.Run("JournalFailure", func(t *testing.T) {
+		dir := t.TempDir()
+		storage, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+		storage.SetCurrentBlock(5)
+
+		// Writes fail once the journal is closed underneath the storage
+		require.NoError(t, storage.journal.Close())
+		assert.True(t, storage.Subscribe("0xfff"))
+		assert.Error(t, storage.Err())
+
+		// The cursor is held until a snapshot records what was parsed
+		storage.SetCurrentBlock(6)
+		assert.Equal(t, 5, storage.GetCurrentBlock())
+
+		storage.journal, err = os.OpenFile(filepath.Join(dir, logFileName), os.O_WRONLY|os.O_APPEND, 0o644)
+		require.NoError(t, err)
+		storage.SetCurrentBlock(6)
+		assert.Equal(t, 6, storage.GetCurrentBlock())
+		assert.NoError(t, storage.Err())
+
+		restored, err := NewFileStorage(dir, logger)
+		require.NoError(t, err)
+		assert.True(t, restored.IsSubscribed("0xfff"))
+		assert.Equal(t, 6, restored.GetCurrentBlock())
+	})
+}
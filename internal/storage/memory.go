@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"fmt"
 	"log"
+	"sort"
 	"strings"
 	"sync"
 
@@ -9,19 +11,60 @@ import (
 )
 
 type MemoryStorage struct {
-	mu           sync.RWMutex
-	subscribers  map[string]bool
-	transactions map[string][]types.ParsedTransaction
-	currentBlock int
-	logger       *log.Logger
+	mu             sync.RWMutex
+	subscriptions  map[string]map[string]types.Subscription // address -> tenant -> subscription
+	retained       map[string]map[string]blockRange         // address -> tenant -> history kept after unsubscribing without purging
+	transactions   map[string][]types.ParsedTransaction
+	hashes         map[string]map[string]bool
+	txIndex        map[string]txLocation
+	blockIndex     map[int64]map[string]bool
+	tokenTransfers map[string][]types.TokenTransfer
+	transferKeys   map[string]map[string]bool
+	internalTxs    map[string][]types.InternalTransaction
+	internalKeys   map[string]map[string]bool
+	nftTransfers   map[string][]types.NFTTransfer
+	nftKeys        map[string]map[string]bool
+	statusBlocks   map[int64]map[string]bool // block -> addresses with records not finalized yet
+	confirmedBlock int                       // highest confirmed block records were promoted to
+	finalizedBlock int                       // highest finalized block records were promoted to
+	failedBlocks   map[int]types.FailedBlock
+	deliveries     map[int]types.WebhookDelivery
+	lastDeliveryID int
+	apiKeys        map[int]types.APIKey
+	keyHashes      map[string]int
+	lastAPIKeyID   int
+	currentBlock   int
+	logger         *log.Logger
+}
+
+// txLocation indexes a stored transaction by hash: its block and the addresses
+// whose histories hold it
+type txLocation struct {
+	cursor    types.TransactionCursor
+	addresses []string
 }
 
 func NewMemoryStorage(logger *log.Logger) *MemoryStorage {
 	return &MemoryStorage{
-		subscribers:  make(map[string]bool),
-		transactions: make(map[string][]types.ParsedTransaction),
-		currentBlock: 0,
-		logger:       logger,
+		subscriptions:  make(map[string]map[string]types.Subscription),
+		retained:       make(map[string]map[string]blockRange),
+		transactions:   make(map[string][]types.ParsedTransaction),
+		hashes:         make(map[string]map[string]bool),
+		txIndex:        make(map[string]txLocation),
+		blockIndex:     make(map[int64]map[string]bool),
+		tokenTransfers: make(map[string][]types.TokenTransfer),
+		transferKeys:   make(map[string]map[string]bool),
+		internalTxs:    make(map[string][]types.InternalTransaction),
+		internalKeys:   make(map[string]map[string]bool),
+		nftTransfers:   make(map[string][]types.NFTTransfer),
+		nftKeys:        make(map[string]map[string]bool),
+		statusBlocks:   make(map[int64]map[string]bool),
+		failedBlocks:   make(map[int]types.FailedBlock),
+		deliveries:     make(map[int]types.WebhookDelivery),
+		apiKeys:        make(map[int]types.APIKey),
+		keyHashes:      make(map[string]int),
+		currentBlock:   0,
+		logger:         logger,
 	}
 }
 
@@ -29,35 +72,188 @@ func (s *MemoryStorage) IsSubscribed(address string) bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	address = types.NormalizeAddress(address)
+
 	// Debug: Print all current subscribers
-	s.logger.Printf("Current subscribers: %+v", s.subscribers)
+	s.logger.Printf("Current subscribers: %d", len(s.subscriptions))
 
-	subscribed := s.subscribers[address]
+	subscribed := s.subscribed(address)
 	s.logger.Printf("Checking subscription for address %s: %v", address, subscribed)
 	return subscribed
 }
 
+// subscribed reports whether a normalized address is observed by any tenant.
+// Must be called with s.mu held.
+func (s *MemoryStorage) subscribed(address string) bool {
+	return len(s.subscriptions[address]) > 0
+}
+
+// blockRange is the part of the shared history of an address a tenant sees,
+// up to To once it is closed and without an upper bound before
+type blockRange struct {
+	From   int64 `json:"from"`
+	To     int64 `json:"to"`
+	Closed bool  `json:"closed"`
+}
+
+func (r blockRange) contains(block int64) bool {
+	return block >= r.From && (!r.Closed || block <= r.To)
+}
+
+// visible returns the blocks of the history of a normalized address a tenant
+// sees, all of them when the tenant is empty. Must be called with s.mu held.
+func (s *MemoryStorage) visible(tenant, address string) (blockRange, bool) {
+	if tenant == "" {
+		return blockRange{}, true
+	}
+	if sub, ok := s.subscriptions[address][tenant]; ok {
+		return blockRange{From: int64(sub.VisibleFrom)}, true
+	}
+	r, ok := s.retained[address][tenant]
+	return r, ok
+}
+
+// tenantOrDefault returns the tenant a subscription change applies to
+func tenantOrDefault(tenant string) string {
+	if tenant == "" {
+		return types.DefaultTenant
+	}
+	return tenant
+}
+
 func (s *MemoryStorage) Subscribe(address string) bool {
+	return s.AddSubscription(types.Subscription{Address: address})
+}
+
+func (s *MemoryStorage) AddSubscription(sub types.Subscription) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Convert address to lowercase for consistent comparison
-	address = strings.ToLower(address)
+	// Addresses are stored in lowercase, whatever case they are given in
+	sub.Address = types.NormalizeAddress(sub.Address)
+	sub.Tenant = tenantOrDefault(sub.Tenant)
 
-	s.logger.Printf("Attempting to subscribe address: %s", address)
-	s.logger.Printf("Current subscribers before: %+v", s.subscribers)
+	s.logger.Printf("Attempting to subscribe address %s for tenant %s", sub.Address, sub.Tenant)
 
-	if _, exists := s.subscribers[address]; exists {
-		s.logger.Printf("Address %s is already subscribed", address)
+	if _, ok := s.subscriptions[sub.Address][sub.Tenant]; ok {
+		s.logger.Printf("Address %s is already subscribed by tenant %s", sub.Address, sub.Tenant)
 		return false
 	}
 
-	s.subscribers[address] = true
-	s.logger.Printf("Current subscribers after: %+v", s.subscribers)
-	s.logger.Printf("Successfully subscribed address: %s", address)
+	// Subscribing again keeps the history the tenant retained visible
+	if r, ok := s.retained[sub.Address][sub.Tenant]; ok {
+		sub.VisibleFrom = min(sub.VisibleFrom, int(r.From))
+	}
+
+	if s.subscriptions[sub.Address] == nil {
+		s.subscriptions[sub.Address] = make(map[string]types.Subscription)
+	}
+	s.subscriptions[sub.Address][sub.Tenant] = sub
+	delete(s.retained[sub.Address], sub.Tenant)
+	s.logger.Printf("Successfully subscribed address: %s", sub.Address)
 	return true
 }
 
+func (s *MemoryStorage) Unsubscribe(tenant, address string, purge bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tenant = tenantOrDefault(tenant)
+	address = types.NormalizeAddress(address)
+	sub, ok := s.subscriptions[address][tenant]
+	if !ok {
+		return false
+	}
+	delete(s.subscriptions[address], tenant)
+	if len(s.subscriptions[address]) == 0 {
+		delete(s.subscriptions, address)
+	}
+
+	if !purge {
+		s.retain(tenant, address, blockRange{From: int64(sub.VisibleFrom), To: int64(s.currentBlock), Closed: true})
+	}
+
+	// The history is shared, it is only deleted once no tenant sees it anymore
+	if purge && !s.subscribed(address) && len(s.retained[address]) == 0 {
+		for _, tx := range s.transactions[address] {
+			s.unindexTransaction(address, tx)
+		}
+		delete(s.retained, address)
+		delete(s.transactions, address)
+		delete(s.hashes, address)
+		delete(s.tokenTransfers, address)
+		delete(s.transferKeys, address)
+		delete(s.internalTxs, address)
+		delete(s.internalKeys, address)
+		delete(s.nftTransfers, address)
+		delete(s.nftKeys, address)
+		for block, addresses := range s.statusBlocks {
+			delete(addresses, address)
+			if len(addresses) == 0 {
+				delete(s.statusBlocks, block)
+			}
+		}
+	}
+
+	s.logger.Printf("Unsubscribed address %s for tenant %s (purge: %v)", address, tenant, purge)
+	return true
+}
+
+// retain keeps the blocks r of the history of a normalized address visible to a
+// tenant that unsubscribed. Must be called with s.mu held.
+func (s *MemoryStorage) retain(tenant, address string, r blockRange) {
+	if s.retained[address] == nil {
+		s.retained[address] = make(map[string]blockRange)
+	}
+	s.retained[address][tenant] = r
+}
+
+func (s *MemoryStorage) GetSubscription(tenant, address string) (types.Subscription, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sub, ok := s.subscriptions[types.NormalizeAddress(address)][tenantOrDefault(tenant)]
+	return sub, ok
+}
+
+func (s *MemoryStorage) GetSubscribers(address string) []types.Subscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subs := make([]types.Subscription, 0, len(s.subscriptions[types.NormalizeAddress(address)]))
+	for _, sub := range s.subscriptions[types.NormalizeAddress(address)] {
+		subs = append(subs, sub)
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].Tenant < subs[j].Tenant
+	})
+	return subs
+}
+
+func (s *MemoryStorage) GetSubscriptions(tenant string) []types.Subscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subs := make([]types.Subscription, 0)
+	for _, tenants := range s.subscriptions {
+		for _, sub := range tenants {
+			if tenant == "" || sub.Tenant == tenant {
+				subs = append(subs, sub)
+			}
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		if subs[i].CreatedAt != subs[j].CreatedAt {
+			return subs[i].CreatedAt < subs[j].CreatedAt
+		}
+		if subs[i].Address != subs[j].Address {
+			return subs[i].Address < subs[j].Address
+		}
+		return subs[i].Tenant < subs[j].Tenant
+	})
+	return subs
+}
+
 //func (s *MemoryStorage) GetTransactions(address string) []types.Transaction {
 //	s.mu.RLock()
 //	defer s.mu.RUnlock()
@@ -85,33 +281,739 @@ func (s *MemoryStorage) Subscribe(address string) bool {
 //	}
 //}
 
-func (s *MemoryStorage) AddTransaction(tx types.ParsedTransaction) {
+func (s *MemoryStorage) AddTransaction(tx types.ParsedTransaction) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	s.logger.Printf("Adding transaction: Hash=%s, From=%s, To=%s", tx.Hash, tx.From, tx.To)
 
-	if tx.From != "" && s.subscribers[tx.From] {
-		s.transactions[tx.From] = append(s.transactions[tx.From], tx)
+	from, to := types.NormalizeAddress(tx.From), types.NormalizeAddress(tx.To)
+	added := false
+	if from != "" && s.subscribed(from) && s.storeTransaction(from, tx) {
 		s.logger.Printf("Added outgoing transaction for %s", tx.From)
+		added = true
 	}
 
-	if tx.To != "" && s.subscribers[tx.To] {
-		s.transactions[tx.To] = append(s.transactions[tx.To], tx)
+	if to != "" && s.subscribed(to) && s.storeTransaction(to, tx) {
 		s.logger.Printf("Added incoming transaction for %s", tx.To)
+		added = true
+	}
+
+	// A contract creation belongs to the history of the created contract
+	contract := types.NormalizeAddress(tx.ContractAddress)
+	if to == "" && contract != "" && s.subscribed(contract) && s.storeTransaction(contract, tx) {
+		s.logger.Printf("Added creation transaction for %s", contract)
+		added = true
 	}
+	return added
 }
 
-func (s *MemoryStorage) GetTransactions(address string) []types.ParsedTransaction {
+// storeTransaction appends tx to the address history unless it is already there.
+// Must be called with s.mu held.
+func (s *MemoryStorage) storeTransaction(address string, tx types.ParsedTransaction) bool {
+	if s.hashes[address] == nil {
+		s.hashes[address] = make(map[string]bool)
+	}
+	if s.hashes[address][tx.Hash] {
+		return false
+	}
+
+	s.hashes[address][tx.Hash] = true
+	tx.Status = s.trackStatus(address, tx.BlockNumber, tx.Status)
+
+	// Histories are kept ordered by block and hash so queries can binary search
+	// them. Live blocks arrive in order and are appended; backfilled ones are inserted.
+	txs := s.transactions[address]
+	i := sort.Search(len(txs), func(i int) bool {
+		return types.CursorOf(tx).Compare(txs[i]) < 0
+	})
+	txs = append(txs, types.ParsedTransaction{})
+	copy(txs[i+1:], txs[i:])
+	txs[i] = tx
+	s.transactions[address] = txs
+
+	s.indexTransaction(address, tx)
+	return true
+}
+
+// indexTransaction records that tx is stored for address. Must be called with s.mu held.
+func (s *MemoryStorage) indexTransaction(address string, tx types.ParsedTransaction) {
+	hash := strings.ToLower(tx.Hash)
+	location := s.txIndex[hash]
+	location.cursor = types.CursorOf(tx)
+	location.addresses = append(location.addresses, address)
+	s.txIndex[hash] = location
+
+	if s.blockIndex[tx.BlockNumber] == nil {
+		s.blockIndex[tx.BlockNumber] = make(map[string]bool)
+	}
+	s.blockIndex[tx.BlockNumber][hash] = true
+}
+
+// unindexTransaction records that tx is no longer stored for address. Must be called with s.mu held.
+func (s *MemoryStorage) unindexTransaction(address string, tx types.ParsedTransaction) {
+	hash := strings.ToLower(tx.Hash)
+	location := s.txIndex[hash]
+	kept := location.addresses[:0]
+	for _, a := range location.addresses {
+		if a != address {
+			kept = append(kept, a)
+		}
+	}
+	location.addresses = kept
+
+	if len(kept) > 0 {
+		s.txIndex[hash] = location
+		return
+	}
+	delete(s.txIndex, hash)
+	delete(s.blockIndex[tx.BlockNumber], hash)
+	if len(s.blockIndex[tx.BlockNumber]) == 0 {
+		delete(s.blockIndex, tx.BlockNumber)
+	}
+}
+
+func (s *MemoryStorage) GetTransaction(tenant, hash string) (types.TransactionLookup, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	return s.lookupTransaction(tenant, strings.ToLower(hash))
+}
+
+func (s *MemoryStorage) GetBlockTransactions(tenant string, block int) []types.TransactionLookup {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hashes := make([]string, 0, len(s.blockIndex[int64(block)]))
+	for hash := range s.blockIndex[int64(block)] {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	lookups := make([]types.TransactionLookup, 0, len(hashes))
+	for _, hash := range hashes {
+		if lookup, ok := s.lookupTransaction(tenant, hash); ok {
+			lookups = append(lookups, lookup)
+		}
+	}
+	return lookups
+}
+
+// lookupTransaction finds an indexed transaction in the history of the first
+// address it is stored for that the tenant sees. Must be called with s.mu held.
+func (s *MemoryStorage) lookupTransaction(tenant, hash string) (types.TransactionLookup, bool) {
+	location, ok := s.txIndex[hash]
+	if !ok {
+		return types.TransactionLookup{}, false
+	}
+
+	addresses := make([]string, 0, len(location.addresses))
+	for _, address := range location.addresses {
+		if r, ok := s.visible(tenant, address); ok && r.contains(location.cursor.BlockNumber) {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		return types.TransactionLookup{}, false
+	}
+
+	txs := s.transactions[addresses[0]]
+	i := sort.Search(len(txs), func(i int) bool {
+		return location.cursor.Compare(txs[i]) <= 0
+	})
+	if i == len(txs) || location.cursor.Compare(txs[i]) != 0 {
+		return types.TransactionLookup{}, false
+	}
+
+	sort.Strings(addresses)
+	return types.TransactionLookup{Transaction: txs[i], Addresses: addresses, Indexed: true}, true
+}
+
+func (s *MemoryStorage) GetTransactions(tenant, address string) []types.ParsedTransaction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	address = types.NormalizeAddress(address)
+	r, ok := s.visible(tenant, address)
+	if !ok {
+		return nil
+	}
+
 	s.logger.Printf("Getting transactions for address: %s", address)
-	txs := s.transactions[address]
+	var txs []types.ParsedTransaction
+	for _, tx := range s.transactions[address] {
+		if r.contains(tx.BlockNumber) {
+			txs = append(txs, tx)
+		}
+	}
 	s.logger.Printf("Found %d transactions for address %s", len(txs), address)
 	return txs
 }
 
+func (s *MemoryStorage) QueryTransactions(query types.TransactionQuery) types.TransactionPage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page := types.TransactionPage{Transactions: make([]types.ParsedTransaction, 0)}
+	address := types.NormalizeAddress(query.Address)
+	r, ok := s.visible(query.Tenant, address)
+	if !ok {
+		return page
+	}
+	txs := s.transactions[address]
+
+	// Narrow the history down to the block range the tenant sees, the queried
+	// range and the cursor before scanning
+	lo, hi := 0, len(txs)
+	if from := max(query.FromBlock, r.From); from > 0 {
+		lo = sort.Search(len(txs), func(i int) bool { return txs[i].BlockNumber >= from })
+	}
+	to := query.ToBlock
+	if r.Closed && (to == 0 || r.To < to) {
+		to = r.To
+	}
+	if to > 0 || r.Closed {
+		hi = sort.Search(len(txs), func(i int) bool { return txs[i].BlockNumber > to })
+	}
+	// Block timestamps only grow, so the time range is contiguous too
+	if query.FromTime > 0 {
+		lo = max(lo, sort.Search(len(txs), func(i int) bool { return txs[i].Timestamp >= query.FromTime }))
+	}
+	if query.ToTime > 0 {
+		hi = min(hi, sort.Search(len(txs), func(i int) bool { return txs[i].Timestamp > query.ToTime }))
+	}
+	if after := query.After; after != nil {
+		// The cursor transaction itself may have been removed by a reorg since
+		if query.Order == types.SortDesc {
+			hi = min(hi, sort.Search(len(txs), func(i int) bool { return after.Compare(txs[i]) <= 0 }))
+		} else {
+			lo = max(lo, sort.Search(len(txs), func(i int) bool { return after.Compare(txs[i]) < 0 }))
+		}
+	}
+
+	for n := 0; n < hi-lo; n++ {
+		i := lo + n
+		if query.Order == types.SortDesc {
+			i = hi - 1 - n
+		}
+		if !query.Matches(txs[i]) {
+			continue
+		}
+		if query.Limit > 0 && len(page.Transactions) == query.Limit {
+			next := types.CursorOf(page.Transactions[len(page.Transactions)-1])
+			page.Next = &next
+			break
+		}
+		page.Transactions = append(page.Transactions, txs[i])
+	}
+	return page
+}
+
+func (s *MemoryStorage) AddTokenTransfer(transfer types.TokenTransfer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from, to := types.NormalizeAddress(transfer.From), types.NormalizeAddress(transfer.To)
+	added := false
+	if from != "" && s.subscribed(from) && s.storeTokenTransfer(from, transfer) {
+		s.logger.Printf("Added outgoing %s transfer for %s", transfer.Token, transfer.From)
+		added = true
+	}
+
+	if to != "" && s.subscribed(to) && s.storeTokenTransfer(to, transfer) {
+		s.logger.Printf("Added incoming %s transfer for %s", transfer.Token, transfer.To)
+		added = true
+	}
+	return added
+}
+
+// storeTokenTransfer appends transfer to the address history unless it is already
+// there. Must be called with s.mu held.
+func (s *MemoryStorage) storeTokenTransfer(address string, transfer types.TokenTransfer) bool {
+	if s.transferKeys[address] == nil {
+		s.transferKeys[address] = make(map[string]bool)
+	}
+	key := transferKey(transfer)
+	if s.transferKeys[address][key] {
+		return false
+	}
+
+	s.transferKeys[address][key] = true
+	transfer.Status = s.trackStatus(address, transfer.BlockNumber, transfer.Status)
+
+	// Kept in block order so statuses can be promoted block by block; backfilled transfers are inserted
+	transfers := s.tokenTransfers[address]
+	i := sort.Search(len(transfers), func(i int) bool {
+		return transfers[i].BlockNumber > transfer.BlockNumber
+	})
+	transfers = append(transfers, types.TokenTransfer{})
+	copy(transfers[i+1:], transfers[i:])
+	transfers[i] = transfer
+	s.tokenTransfers[address] = transfers
+	return true
+}
+
+func (s *MemoryStorage) GetTokenTransfers(tenant, address string) []types.TokenTransfer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	address = types.NormalizeAddress(address)
+	r, ok := s.visible(tenant, address)
+	if !ok {
+		return nil
+	}
+
+	var transfers []types.TokenTransfer
+	for _, transfer := range s.tokenTransfers[address] {
+		if r.contains(transfer.BlockNumber) {
+			transfers = append(transfers, transfer)
+		}
+	}
+	return transfers
+}
+
+func (s *MemoryStorage) AddInternalTransaction(tx types.InternalTransaction) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from, to := types.NormalizeAddress(tx.From), types.NormalizeAddress(tx.To)
+	added := false
+	if from != "" && s.subscribed(from) && s.storeInternalTransaction(from, tx) {
+		s.logger.Printf("Added outgoing internal transaction for %s", tx.From)
+		added = true
+	}
+
+	if to != "" && s.subscribed(to) && s.storeInternalTransaction(to, tx) {
+		s.logger.Printf("Added incoming internal transaction for %s", tx.To)
+		added = true
+	}
+	return added
+}
+
+// storeInternalTransaction appends tx to the address history unless it is already
+// there. Must be called with s.mu held.
+func (s *MemoryStorage) storeInternalTransaction(address string, tx types.InternalTransaction) bool {
+	if s.internalKeys[address] == nil {
+		s.internalKeys[address] = make(map[string]bool)
+	}
+	key := internalKey(tx)
+	if s.internalKeys[address][key] {
+		return false
+	}
+
+	s.internalKeys[address][key] = true
+	tx.Status = s.trackStatus(address, tx.BlockNumber, tx.Status)
+
+	// Kept in block order so statuses can be promoted block by block; backfilled ones are inserted
+	txs := s.internalTxs[address]
+	i := sort.Search(len(txs), func(i int) bool {
+		return txs[i].BlockNumber > tx.BlockNumber
+	})
+	txs = append(txs, types.InternalTransaction{})
+	copy(txs[i+1:], txs[i:])
+	txs[i] = tx
+	s.internalTxs[address] = txs
+	return true
+}
+
+func (s *MemoryStorage) GetInternalTransactions(tenant, address string) []types.InternalTransaction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	address = types.NormalizeAddress(address)
+	r, ok := s.visible(tenant, address)
+	if !ok {
+		return nil
+	}
+
+	var txs []types.InternalTransaction
+	for _, tx := range s.internalTxs[address] {
+		if r.contains(tx.BlockNumber) {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+func (s *MemoryStorage) AddNFTTransfer(transfer types.NFTTransfer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from, to := types.NormalizeAddress(transfer.From), types.NormalizeAddress(transfer.To)
+	added := false
+	if from != "" && s.subscribed(from) && s.storeNFTTransfer(from, transfer) {
+		s.logger.Printf("Added outgoing NFT %s #%s for %s", transfer.Contract, transfer.TokenID, transfer.From)
+		added = true
+	}
+
+	if to != "" && s.subscribed(to) && s.storeNFTTransfer(to, transfer) {
+		s.logger.Printf("Added incoming NFT %s #%s for %s", transfer.Contract, transfer.TokenID, transfer.To)
+		added = true
+	}
+	return added
+}
+
+// storeNFTTransfer appends transfer to the address history unless it is already
+// there. Must be called with s.mu held.
+func (s *MemoryStorage) storeNFTTransfer(address string, transfer types.NFTTransfer) bool {
+	if s.nftKeys[address] == nil {
+		s.nftKeys[address] = make(map[string]bool)
+	}
+	key := nftKey(transfer)
+	if s.nftKeys[address][key] {
+		return false
+	}
+
+	s.nftKeys[address][key] = true
+	transfer.Status = s.trackStatus(address, transfer.BlockNumber, transfer.Status)
+
+	// Kept in chain order so holdings can be replayed; backfilled transfers are inserted
+	transfers := s.nftTransfers[address]
+	i := sort.Search(len(transfers), func(i int) bool {
+		return nftTransferBefore(transfer, transfers[i])
+	})
+	transfers = append(transfers, types.NFTTransfer{})
+	copy(transfers[i+1:], transfers[i:])
+	transfers[i] = transfer
+	s.nftTransfers[address] = transfers
+	return true
+}
+
+// nftTransferBefore reports whether a happened before b on chain
+func nftTransferBefore(a, b types.NFTTransfer) bool {
+	if a.BlockNumber != b.BlockNumber {
+		return a.BlockNumber < b.BlockNumber
+	}
+	if a.LogIndex != b.LogIndex {
+		return a.LogIndex < b.LogIndex
+	}
+	return a.BatchIndex < b.BatchIndex
+}
+
+func (s *MemoryStorage) GetNFTTransfers(tenant, address string) []types.NFTTransfer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	address = types.NormalizeAddress(address)
+	r, ok := s.visible(tenant, address)
+	if !ok {
+		return nil
+	}
+
+	var transfers []types.NFTTransfer
+	for _, transfer := range s.nftTransfers[address] {
+		if r.contains(transfer.BlockNumber) {
+			transfers = append(transfers, transfer)
+		}
+	}
+	return transfers
+}
+
+func (s *MemoryStorage) RemoveTransactionsFrom(block int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for address, txs := range s.transactions {
+		kept := txs[:0]
+		for _, tx := range txs {
+			if tx.BlockNumber >= int64(block) {
+				delete(s.hashes[address], tx.Hash)
+				s.unindexTransaction(address, tx)
+				removed++
+				continue
+			}
+			kept = append(kept, tx)
+		}
+		s.transactions[address] = kept
+	}
+
+	for address, transfers := range s.tokenTransfers {
+		kept := transfers[:0]
+		for _, transfer := range transfers {
+			if transfer.BlockNumber >= int64(block) {
+				delete(s.transferKeys[address], transferKey(transfer))
+				removed++
+				continue
+			}
+			kept = append(kept, transfer)
+		}
+		s.tokenTransfers[address] = kept
+	}
+
+	for address, txs := range s.internalTxs {
+		kept := txs[:0]
+		for _, tx := range txs {
+			if tx.BlockNumber >= int64(block) {
+				delete(s.internalKeys[address], internalKey(tx))
+				removed++
+				continue
+			}
+			kept = append(kept, tx)
+		}
+		s.internalTxs[address] = kept
+	}
+
+	for address, transfers := range s.nftTransfers {
+		kept := transfers[:0]
+		for _, transfer := range transfers {
+			if transfer.BlockNumber >= int64(block) {
+				delete(s.nftKeys[address], nftKey(transfer))
+				removed++
+				continue
+			}
+			kept = append(kept, transfer)
+		}
+		s.nftTransfers[address] = kept
+	}
+
+	for statusBlock := range s.statusBlocks {
+		if statusBlock >= int64(block) {
+			delete(s.statusBlocks, statusBlock)
+		}
+	}
+	// Blocks replacing the removed ones are confirmed again from scratch
+	s.confirmedBlock = min(s.confirmedBlock, block-1)
+
+	s.logger.Printf("Removed %d transactions from block %d onwards", removed, block)
+	return removed
+}
+
+// UpdateStatuses only visits the blocks holding records that are not finalized
+// yet, and of those only the ones that became confirmed since the last call or
+// are now finalized
+func (s *MemoryStorage) UpdateStatuses(confirmedBlock, finalizedBlock int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated := 0
+	for block, addresses := range s.statusBlocks {
+		newlyConfirmed := block > int64(s.confirmedBlock) && block <= int64(confirmedBlock)
+		if !newlyConfirmed && block > int64(finalizedBlock) {
+			continue
+		}
+		for address := range addresses {
+			updated += s.updateBlockStatuses(address, block, confirmedBlock, finalizedBlock)
+		}
+		if block <= int64(finalizedBlock) {
+			delete(s.statusBlocks, block)
+		}
+	}
+	s.confirmedBlock = max(s.confirmedBlock, confirmedBlock)
+	s.finalizedBlock = max(s.finalizedBlock, finalizedBlock)
+	return updated
+}
+
+// updateBlockStatuses promotes the records of an address in block, and returns
+// how many changed. Histories are ordered by block. Must be called with s.mu held.
+func (s *MemoryStorage) updateBlockStatuses(address string, block int64, confirmedBlock, finalizedBlock int) int {
+	updated := 0
+
+	txs := s.transactions[address]
+	for i := sort.Search(len(txs), func(i int) bool { return txs[i].BlockNumber >= block }); i < len(txs) && txs[i].BlockNumber == block; i++ {
+		if status := nextStatus(txs[i].Status, block, confirmedBlock, finalizedBlock); status != txs[i].Status {
+			txs[i].Status = status
+			updated++
+		}
+	}
+
+	transfers := s.tokenTransfers[address]
+	for i := sort.Search(len(transfers), func(i int) bool { return transfers[i].BlockNumber >= block }); i < len(transfers) && transfers[i].BlockNumber == block; i++ {
+		if status := nextStatus(transfers[i].Status, block, confirmedBlock, finalizedBlock); status != transfers[i].Status {
+			transfers[i].Status = status
+			updated++
+		}
+	}
+
+	internalTxs := s.internalTxs[address]
+	for i := sort.Search(len(internalTxs), func(i int) bool { return internalTxs[i].BlockNumber >= block }); i < len(internalTxs) && internalTxs[i].BlockNumber == block; i++ {
+		if status := nextStatus(internalTxs[i].Status, block, confirmedBlock, finalizedBlock); status != internalTxs[i].Status {
+			internalTxs[i].Status = status
+			updated++
+		}
+	}
+
+	nftTransfers := s.nftTransfers[address]
+	for i := sort.Search(len(nftTransfers), func(i int) bool { return nftTransfers[i].BlockNumber >= block }); i < len(nftTransfers) && nftTransfers[i].BlockNumber == block; i++ {
+		if status := nextStatus(nftTransfers[i].Status, block, confirmedBlock, finalizedBlock); status != nftTransfers[i].Status {
+			nftTransfers[i].Status = status
+			updated++
+		}
+	}
+	return updated
+}
+
+// trackStatus returns the status of a record stored for address in block,
+// promoted when the block was already confirmed or finalized, and remembers the
+// block until it is finalized. Must be called with s.mu held.
+func (s *MemoryStorage) trackStatus(address string, block int64, status string) string {
+	if block <= int64(s.confirmedBlock) || block <= int64(s.finalizedBlock) {
+		status = nextStatus(status, block, s.confirmedBlock, s.finalizedBlock)
+	}
+	if status != types.StatusFinalized {
+		if s.statusBlocks[block] == nil {
+			s.statusBlocks[block] = make(map[string]bool)
+		}
+		s.statusBlocks[block][address] = true
+	}
+	return status
+}
+
+// nextStatus returns the status of a record in block given the confirmed and finalized heads
+func nextStatus(status string, block int64, confirmedBlock, finalizedBlock int) string {
+	switch {
+	case block <= int64(finalizedBlock):
+		return types.StatusFinalized
+	case status == types.StatusFinalized:
+		// Finality is never revoked
+		return status
+	case block <= int64(confirmedBlock):
+		return types.StatusConfirmed
+	case status == "":
+		return types.StatusPending
+	}
+	return status
+}
+
+func (s *MemoryStorage) RecordBlockFailure(block int, reason string, at int64) types.FailedBlock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failed, exists := s.failedBlocks[block]
+	if !exists {
+		failed = types.FailedBlock{Number: block, FirstFailedAt: at}
+	}
+	failed.Attempts++
+	failed.LastError = reason
+	failed.LastAttemptAt = at
+	s.failedBlocks[block] = failed
+
+	s.logger.Printf("Recorded failure %d for block %d: %s", failed.Attempts, block, reason)
+	return failed
+}
+
+func (s *MemoryStorage) RemoveFailedBlock(block int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.failedBlocks, block)
+}
+
+func (s *MemoryStorage) GetFailedBlocks() []types.FailedBlock {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	failed := make([]types.FailedBlock, 0, len(s.failedBlocks))
+	for _, fb := range s.failedBlocks {
+		failed = append(failed, fb)
+	}
+	sort.Slice(failed, func(i, j int) bool {
+		return failed[i].Number < failed[j].Number
+	})
+	return failed
+}
+
+func (s *MemoryStorage) AddWebhookDelivery(delivery types.WebhookDelivery) types.WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Replayed deliveries keep the ID they were assigned
+	if delivery.ID == 0 {
+		delivery.ID = s.lastDeliveryID + 1
+	}
+	s.lastDeliveryID = max(s.lastDeliveryID, delivery.ID)
+
+	s.deliveries[delivery.ID] = delivery
+	return delivery
+}
+
+func (s *MemoryStorage) UpdateWebhookDelivery(delivery types.WebhookDelivery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.deliveries[delivery.ID]; exists {
+		s.deliveries[delivery.ID] = delivery
+	}
+}
+
+func (s *MemoryStorage) RemoveWebhookDelivery(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.deliveries, id)
+}
+
+func (s *MemoryStorage) GetWebhookDeliveries(tenant, status string) []types.WebhookDelivery {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deliveries := make([]types.WebhookDelivery, 0)
+	for _, delivery := range s.deliveries {
+		if (tenant == "" || delivery.Tenant == tenant) && (status == "" || delivery.Status == status) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID < deliveries[j].ID
+	})
+	return deliveries
+}
+
+func (s *MemoryStorage) AddAPIKey(key types.APIKey) types.APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Replayed keys keep the ID they were assigned
+	if key.ID == 0 {
+		key.ID = s.lastAPIKeyID + 1
+	}
+	s.lastAPIKeyID = max(s.lastAPIKeyID, key.ID)
+
+	s.apiKeys[key.ID] = key
+	s.keyHashes[key.Hash] = key.ID
+	s.logger.Printf("Added API key %d for tenant %s", key.ID, key.Tenant)
+	return key
+}
+
+func (s *MemoryStorage) GetAPIKeys() []types.APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]types.APIKey, 0, len(s.apiKeys))
+	for _, key := range s.apiKeys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+	return keys
+}
+
+func (s *MemoryStorage) GetAPIKeyByHash(hash string) (types.APIKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.keyHashes[hash]
+	if !ok {
+		return types.APIKey{}, false
+	}
+	return s.apiKeys[id], true
+}
+
+func (s *MemoryStorage) RemoveAPIKey(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.apiKeys[id]
+	if !ok {
+		return false
+	}
+	delete(s.apiKeys, id)
+	delete(s.keyHashes, key.Hash)
+	s.logger.Printf("Revoked API key %d of tenant %s", id, key.Tenant)
+	return true
+}
+
 func (s *MemoryStorage) SetCurrentBlock(block int) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -127,3 +1029,18 @@ func (s *MemoryStorage) GetCurrentBlock() int {
 	s.logger.Printf("Getting current block: %d", s.currentBlock)
 	return s.currentBlock
 }
+
+// transferKey identifies a token transfer by its transaction and log position
+func transferKey(transfer types.TokenTransfer) string {
+	return fmt.Sprintf("%s:%d", transfer.TxHash, transfer.LogIndex)
+}
+
+// internalKey identifies an internal transaction by its transaction and call position
+func internalKey(tx types.InternalTransaction) string {
+	return tx.TxHash + ":" + tx.TraceAddress
+}
+
+// nftKey identifies an NFT transfer by its transaction, log and position in a batch
+func nftKey(transfer types.NFTTransfer) string {
+	return fmt.Sprintf("%s:%d:%d", transfer.TxHash, transfer.LogIndex, transfer.BatchIndex)
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"log"
+	"math/big"
 	"os"
 	"testing"
 
@@ -44,9 +45,344 @@ func TestMemoryStorage(t *testing.T) {
 		storage.AddTransaction(tx)
 
 		// Get transactions
-		txs := storage.GetTransactions(address)
+		txs := storage.GetTransactions("", address)
 		assert.Len(t, txs, 1)
 		assert.Equal(t, tx.Hash, txs[0].Hash)
+
+		// Adding the same transaction again is a no-op
+		assert.False(t, storage.AddTransaction(tx))
+		assert.Len(t, storage.GetTransactions("", address), 1)
+	})
+
+	t.Run("ContractCreation", func(t *testing.T) {
+		contract := "0x5fbdb2315678afecb367f032d93f642f64180aa3"
+		storage.Subscribe(contract)
+
+		tx := types.ParsedTransaction{Hash: "0xdef", From: "0x999", ContractAddress: contract, BlockNumber: 1001}
+		assert.True(t, storage.AddTransaction(tx))
+
+		txs := storage.GetTransactions("", contract)
+		assert.Len(t, txs, 1)
+		assert.Empty(t, storage.GetTransactions("", ""))
+	})
+
+	t.Run("AddressCase", func(t *testing.T) {
+		checksummed := "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+		assert.True(t, storage.Subscribe(checksummed))
+		assert.False(t, storage.Subscribe("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"))
+		assert.True(t, storage.IsSubscribed(checksummed))
+		assert.True(t, storage.IsSubscribed("0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED"))
+
+		assert.True(t, storage.AddTransaction(types.ParsedTransaction{Hash: "0xcase", From: checksummed, BlockNumber: 1002}))
+		assert.Len(t, storage.GetTransactions("", checksummed), 1)
+		assert.Len(t, storage.GetTransactions("", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"), 1)
+	})
+
+	t.Run("Unsubscribe", func(t *testing.T) {
+		retained, purged := "0xaaa1", "0xaaa2"
+		storage.AddSubscription(types.Subscription{Address: retained, Label: "hot wallet", CreatedAt: 2})
+		storage.AddSubscription(types.Subscription{Address: purged, Tags: []string{"exchange"}, CreatedAt: 1})
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0xunsub", From: retained, To: purged, BlockNumber: 1003})
+
+		subs := storage.GetSubscriptions("")
+		assert.Equal(t, purged, subs[len(subs)-2].Address)
+		assert.Equal(t, "hot wallet", subs[len(subs)-1].Label)
+
+		assert.True(t, storage.Unsubscribe(types.DefaultTenant, retained, false))
+		assert.True(t, storage.Unsubscribe(types.DefaultTenant, purged, true))
+		assert.False(t, storage.Unsubscribe(types.DefaultTenant, purged, true))
+		assert.False(t, storage.IsSubscribed(retained))
+
+		// Retained history stays queryable, but nothing new is stored
+		assert.Len(t, storage.GetTransactions("", retained), 1)
+		assert.Empty(t, storage.GetTransactions("", purged))
+		assert.False(t, storage.AddTransaction(types.ParsedTransaction{Hash: "0xafter", From: retained, BlockNumber: 1004}))
+
+		// Purged records are no longer tracked for status updates
+		assert.Equal(t, map[string]bool{retained: true}, storage.statusBlocks[1003])
+	})
+
+	t.Run("RemoveTransactionsFrom", func(t *testing.T) {
+		address := "0x789"
+		storage.Subscribe(address)
+
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x1", From: address, BlockNumber: 2010})
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x2", From: address, BlockNumber: 2011})
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x3", To: address, BlockNumber: 2012})
+
+		assert.Equal(t, 2, storage.RemoveTransactionsFrom(2011))
+
+		txs := storage.GetTransactions("", address)
+		assert.Len(t, txs, 1)
+		assert.Equal(t, "0x1", txs[0].Hash)
+	})
+
+	t.Run("UpdateStatuses", func(t *testing.T) {
+		address := "0xaaa"
+		storage.Subscribe(address)
+
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x1", From: address, BlockNumber: 3000, Status: types.StatusPending})
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x2", From: address, BlockNumber: 3005, Status: types.StatusPending})
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x3", From: address, BlockNumber: 3010, Status: types.StatusPending})
+
+		storage.UpdateStatuses(3005, 3000)
+
+		txs := storage.GetTransactions("", address)
+		assert.Equal(t, types.StatusFinalized, txs[0].Status)
+		assert.Equal(t, types.StatusConfirmed, txs[1].Status)
+		assert.Equal(t, types.StatusPending, txs[2].Status)
+
+		// Nothing is visited again until the chain advances
+		assert.Equal(t, 0, storage.UpdateStatuses(3005, 3000))
+		assert.Equal(t, 2, storage.UpdateStatuses(3010, 3005))
+		assert.Len(t, storage.statusBlocks, 1)
+
+		// Records stored below the confirmed block are promoted right away
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x4", From: address, BlockNumber: 3008, Status: types.StatusPending})
+		storage.AddTokenTransfer(types.TokenTransfer{TxHash: "0x4", From: address, BlockNumber: 3008, Status: types.StatusPending})
+		storage.AddTokenTransfer(types.TokenTransfer{TxHash: "0x1", From: address, BlockNumber: 3000, Status: types.StatusPending})
+		assert.Equal(t, types.StatusConfirmed, storage.GetTransactions("", address)[2].Status)
+		transfers := storage.GetTokenTransfers("", address)
+		assert.Equal(t, []int64{3000, 3008}, []int64{transfers[0].BlockNumber, transfers[1].BlockNumber})
+		assert.Equal(t, types.StatusFinalized, transfers[0].Status)
+		assert.Equal(t, types.StatusConfirmed, transfers[1].Status)
+
+		assert.Equal(t, 3, storage.UpdateStatuses(3010, 3010))
+		assert.Empty(t, storage.statusBlocks)
+	})
+
+	t.Run("QueryTransactions", func(t *testing.T) {
+		storage := NewMemoryStorage(logger)
+		address := "0xbbb1"
+		storage.Subscribe(address)
+
+		// Backfilled blocks arrive after live ones and are inserted in order
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x4", From: address, Value: "0x64", BlockNumber: 6003, Timestamp: 300})
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x2", To: address, Value: "0xa", BlockNumber: 6001, Timestamp: 100})
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x3", To: address, Value: "0x0", BlockNumber: 6002, Timestamp: 200})
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x1", From: address, Value: "0x1", BlockNumber: 6001, Timestamp: 100})
+
+		hashes := func(page types.TransactionPage) []string {
+			result := make([]string, 0, len(page.Transactions))
+			for _, tx := range page.Transactions {
+				result = append(result, tx.Hash)
+			}
+			return result
+		}
+
+		all := storage.QueryTransactions(types.TransactionQuery{Address: address})
+		assert.Equal(t, []string{"0x1", "0x2", "0x3", "0x4"}, hashes(all))
+		assert.Nil(t, all.Next)
+
+		assert.Equal(t, []string{"0x2", "0x3"}, hashes(storage.QueryTransactions(types.TransactionQuery{Address: address, Direction: types.DirectionIn})))
+		assert.Equal(t, []string{"0x3", "0x4"}, hashes(storage.QueryTransactions(types.TransactionQuery{Address: address, FromBlock: 6002, ToBlock: 6003})))
+		assert.Equal(t, []string{"0x1", "0x2"}, hashes(storage.QueryTransactions(types.TransactionQuery{Address: address, ToTime: 150})))
+		assert.Equal(t, []string{"0x2", "0x4"}, hashes(storage.QueryTransactions(types.TransactionQuery{Address: address, MinValue: big.NewInt(10)})))
+
+		// Pages follow each other through the cursor, in both orders
+		var asc []string
+		query := types.TransactionQuery{Address: address, Limit: 3}
+		for {
+			page := storage.QueryTransactions(query)
+			asc = append(asc, hashes(page)...)
+			if page.Next == nil {
+				break
+			}
+			query.After = page.Next
+		}
+		assert.Equal(t, []string{"0x1", "0x2", "0x3", "0x4"}, asc)
+
+		desc := storage.QueryTransactions(types.TransactionQuery{Address: address, Order: types.SortDesc, Limit: 2})
+		assert.Equal(t, []string{"0x4", "0x3"}, hashes(desc))
+		desc = storage.QueryTransactions(types.TransactionQuery{Address: address, Order: types.SortDesc, Limit: 2, After: desc.Next})
+		assert.Equal(t, []string{"0x2", "0x1"}, hashes(desc))
+		assert.Nil(t, desc.Next)
+
+		// A cursor still works after its transaction was removed by a reorg
+		cursor := types.TransactionCursor{BlockNumber: 6003, Hash: "0x4"}
+		storage.RemoveTransactionsFrom(6003)
+		assert.Equal(t, []string{"0x3", "0x2", "0x1"}, hashes(storage.QueryTransactions(types.TransactionQuery{Address: address, Order: types.SortDesc, After: &cursor})))
+	})
+
+	t.Run("LookupByHashAndBlock", func(t *testing.T) {
+		storage := NewMemoryStorage(logger)
+		sender, recipient := "0xccc1", "0xccc2"
+		storage.Subscribe(sender)
+		storage.Subscribe(recipient)
+
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x2", From: sender, To: recipient, BlockNumber: 7000})
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x1", From: sender, BlockNumber: 7000})
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x3", To: recipient, BlockNumber: 7001})
+
+		lookup, ok := storage.GetTransaction("", "0x2")
+		assert.True(t, ok)
+		assert.True(t, lookup.Indexed)
+		assert.Equal(t, []string{sender, recipient}, lookup.Addresses)
+
+		block := storage.GetBlockTransactions("", 7000)
+		assert.Len(t, block, 2)
+		assert.Equal(t, "0x1", block[0].Transaction.Hash)
+		assert.Equal(t, "0x2", block[1].Transaction.Hash)
+
+		// Reorgs and purges drop transactions from the indexes
+		storage.RemoveTransactionsFrom(7001)
+		_, ok = storage.GetTransaction("", "0x3")
+		assert.False(t, ok)
+		assert.Empty(t, storage.GetBlockTransactions("", 7001))
+
+		storage.Unsubscribe(types.DefaultTenant, recipient, true)
+		lookup, ok = storage.GetTransaction("", "0x2")
+		assert.True(t, ok)
+		assert.Equal(t, []string{sender}, lookup.Addresses)
+		storage.Unsubscribe(types.DefaultTenant, sender, true)
+		assert.Empty(t, storage.GetBlockTransactions("", 7000))
+	})
+
+	t.Run("Tenants", func(t *testing.T) {
+		storage := NewMemoryStorage(logger)
+		shared, private := "0xddd1", "0xddd2"
+		assert.True(t, storage.AddSubscription(types.Subscription{Tenant: "acme", Address: shared, Label: "acme wallet"}))
+		assert.True(t, storage.AddSubscription(types.Subscription{Tenant: "globex", Address: shared, Label: "globex wallet"}))
+		assert.False(t, storage.AddSubscription(types.Subscription{Tenant: "globex", Address: shared}))
+		assert.True(t, storage.AddSubscription(types.Subscription{Tenant: "acme", Address: private}))
+
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x1", From: shared, To: private, BlockNumber: 8000})
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x2", To: private, BlockNumber: 8001})
+
+		// Subscriptions and histories are only visible to the tenants that subscribed
+		assert.Len(t, storage.GetSubscriptions("acme"), 2)
+		assert.Len(t, storage.GetSubscriptions("globex"), 1)
+		assert.Len(t, storage.GetSubscribers(shared), 2)
+		assert.Len(t, storage.GetTransactions("globex", shared), 1)
+		assert.Empty(t, storage.GetTransactions("globex", private))
+		assert.Empty(t, storage.QueryTransactions(types.TransactionQuery{Tenant: "globex", Address: private}).Transactions)
+		assert.Len(t, storage.QueryTransactions(types.TransactionQuery{Tenant: "acme", Address: private}).Transactions, 2)
+
+		lookup, ok := storage.GetTransaction("globex", "0x1")
+		assert.True(t, ok)
+		assert.Equal(t, []string{shared}, lookup.Addresses)
+		_, ok = storage.GetTransaction("globex", "0x2")
+		assert.False(t, ok)
+		assert.Empty(t, storage.GetBlockTransactions("globex", 8001))
+
+		// Unsubscribing is per tenant, and a purge keeps what others still see
+		assert.False(t, storage.Unsubscribe("globex", private, true))
+		assert.True(t, storage.Unsubscribe("globex", shared, true))
+		assert.True(t, storage.IsSubscribed(shared))
+		assert.Empty(t, storage.GetTransactions("globex", shared))
+		assert.Len(t, storage.GetTransactions("acme", shared), 1)
+
+		storage.SetCurrentBlock(8001)
+		assert.True(t, storage.Unsubscribe("acme", shared, false))
+		assert.False(t, storage.IsSubscribed(shared))
+		assert.Len(t, storage.GetTransactions("acme", shared), 1)
+		assert.True(t, storage.Unsubscribe("acme", private, true))
+		_, ok = storage.GetTransaction("", "0x2")
+		assert.False(t, ok)
+	})
+
+	t.Run("VisibleFrom", func(t *testing.T) {
+		storage := NewMemoryStorage(logger)
+		address := "0xeee1"
+		storage.AddSubscription(types.Subscription{Tenant: "acme", Address: address})
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x1", To: address, BlockNumber: 100})
+		storage.AddTokenTransfer(types.TokenTransfer{TxHash: "0x1", To: address, BlockNumber: 100})
+
+		// A later tenant does not see the shared history from before its subscription
+		storage.AddSubscription(types.Subscription{Tenant: "globex", Address: address, VisibleFrom: 150})
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x2", To: address, BlockNumber: 200})
+		storage.AddTokenTransfer(types.TokenTransfer{TxHash: "0x2", To: address, BlockNumber: 200})
+
+		assert.Len(t, storage.GetTransactions("acme", address), 2)
+		txs := storage.GetTransactions("globex", address)
+		assert.Len(t, txs, 1)
+		assert.Equal(t, "0x2", txs[0].Hash)
+		assert.Len(t, storage.QueryTransactions(types.TransactionQuery{Tenant: "globex", Address: address}).Transactions, 1)
+		assert.Len(t, storage.GetTokenTransfers("globex", address), 1)
+		_, ok := storage.GetTransaction("globex", "0x1")
+		assert.False(t, ok)
+		assert.Empty(t, storage.GetBlockTransactions("globex", 100))
+
+		// After unsubscribing it keeps what it saw, but nothing later
+		storage.SetCurrentBlock(250)
+		assert.True(t, storage.Unsubscribe("globex", address, false))
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x3", To: address, BlockNumber: 300})
+		assert.Len(t, storage.GetTransactions("globex", address), 1)
+		assert.Len(t, storage.GetTransactions("acme", address), 3)
+
+		// Subscribing again keeps the retained blocks visible
+		storage.AddSubscription(types.Subscription{Tenant: "globex", Address: address, VisibleFrom: 280})
+		assert.Len(t, storage.GetTransactions("globex", address), 2)
+
+		// Unsubscribing before any block was parsed keeps nothing visible
+		storage = NewMemoryStorage(logger)
+		storage.AddSubscription(types.Subscription{Tenant: "acme", Address: address})
+		storage.AddSubscription(types.Subscription{Tenant: "globex", Address: address})
+		assert.True(t, storage.Unsubscribe("globex", address, false))
+		storage.AddTransaction(types.ParsedTransaction{Hash: "0x1", To: address, BlockNumber: 100})
+		assert.Empty(t, storage.GetTransactions("globex", address))
+		assert.Empty(t, storage.QueryTransactions(types.TransactionQuery{Tenant: "globex", Address: address}).Transactions)
+		assert.Len(t, storage.GetTransactions("acme", address), 1)
+	})
+
+	t.Run("APIKeys", func(t *testing.T) {
+		storage := NewMemoryStorage(logger)
+		first := storage.AddAPIKey(types.APIKey{Tenant: "acme", Name: "ci", Hash: "hash-1"})
+		second := storage.AddAPIKey(types.APIKey{Tenant: "acme", Hash: "hash-2"})
+		assert.Equal(t, []int{1, 2}, []int{first.ID, second.ID})
+
+		key, ok := storage.GetAPIKeyByHash("hash-1")
+		assert.True(t, ok)
+		assert.Equal(t, first, key)
+
+		assert.True(t, storage.RemoveAPIKey(first.ID))
+		assert.False(t, storage.RemoveAPIKey(first.ID))
+		_, ok = storage.GetAPIKeyByHash("hash-1")
+		assert.False(t, ok)
+		assert.Equal(t, []types.APIKey{second}, storage.GetAPIKeys())
+	})
+
+	t.Run("TokenTransfers", func(t *testing.T) {
+		address := "0xbbb"
+		storage.Subscribe(address)
+
+		transfer := types.TokenTransfer{TxHash: "0x1", LogIndex: 0, Token: "0xtoken", To: address, Amount: "10", BlockNumber: 4000}
+		assert.True(t, storage.AddTokenTransfer(transfer))
+		assert.False(t, storage.AddTokenTransfer(transfer))
+
+		// Another log of the same transaction is a separate transfer
+		transfer.LogIndex = 1
+		transfer.BlockNumber = 4001
+		assert.True(t, storage.AddTokenTransfer(transfer))
+		assert.False(t, storage.AddTokenTransfer(types.TokenTransfer{TxHash: "0x2", From: "0xccc", To: "0xddd"}))
+
+		transfers := storage.GetTokenTransfers("", address)
+		assert.Len(t, transfers, 2)
+		assert.Equal(t, 1, storage.RemoveTransactionsFrom(4001))
+		assert.Len(t, storage.GetTokenTransfers("", address), 1)
+
+		// Callers get a copy that later updates do not touch
+		storage.UpdateStatuses(4000, 4000)
+		assert.Equal(t, int64(4001), transfers[1].BlockNumber)
+		assert.Empty(t, transfers[0].Status)
+	})
+
+	t.Run("NFTTransfers", func(t *testing.T) {
+		address := "0xeee"
+		storage.Subscribe(address)
+
+		transfer := types.NFTTransfer{TxHash: "0x1", Contract: "0xnft", Standard: types.StandardERC1155, To: address, TokenID: "7", Amount: "2", BlockNumber: 5000}
+		assert.True(t, storage.AddNFTTransfer(transfer))
+		assert.False(t, storage.AddNFTTransfer(transfer))
+
+		// The next entry of the same batch is a separate transfer
+		transfer.BatchIndex = 1
+		transfer.TokenID = "9"
+		assert.True(t, storage.AddNFTTransfer(transfer))
+
+		assert.Len(t, storage.GetNFTTransfers("", address), 2)
+		assert.Equal(t, 2, storage.RemoveTransactionsFrom(5000))
+		assert.Empty(t, storage.GetNFTTransfers("", address))
 	})
 
 	t.Run("CurrentBlock", func(t *testing.T) {
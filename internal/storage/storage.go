@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"ethparser/pkg/types"
+)
+
+// Storage defines the persistence operations the parser depends on.
+//
+// Subscriptions belong to tenants, while the history of an address is stored
+// once for all of them. A tenant sees the history of the addresses it subscribes
+// and of those it unsubscribed without purging. Methods taking a tenant return
+// what that tenant sees, or everything when the tenant is empty.
+type Storage interface {
+	// Subscribe - add address to observer for DefaultTenant, false if already subscribed
+	Subscribe(address string) bool
+
+	// AddSubscription - add address to observer for sub.Tenant (DefaultTenant when empty)
+	// with its metadata, false if that tenant already subscribed it
+	AddSubscription(sub types.Subscription) bool
+
+	// Unsubscribe - stop observing an address for a tenant, deleting everything stored for it
+	// when purge is set and no other tenant sees it; false if the tenant did not subscribe it
+	Unsubscribe(tenant, address string, purge bool) bool
+
+	// GetSubscriptions - addresses observed by a tenant, oldest subscription first
+	GetSubscriptions(tenant string) []types.Subscription
+
+	// GetSubscription - subscription of an address by a tenant, false if it did not subscribe it
+	GetSubscription(tenant, address string) (types.Subscription, bool)
+
+	// GetSubscribers - subscriptions of an address by every tenant, ordered by tenant
+	GetSubscribers(address string) []types.Subscription
+
+	// IsSubscribed - whether the address is being observed by any tenant
+	IsSubscribed(address string) bool
+
+	// AddTransaction - store a transaction for its subscribed sender and/or recipient,
+	// false if it was already stored for all of them
+	AddTransaction(tx types.ParsedTransaction) bool
+
+	// GetTransactions - list of stored transactions for an address
+	GetTransactions(tenant, address string) []types.ParsedTransaction
+
+	// QueryTransactions - page of the transactions of an address matching a query, served
+	// from the history ordered by block and hash; empty when query.Tenant does not see it
+	QueryTransactions(query types.TransactionQuery) types.TransactionPage
+
+	// GetTransaction - a stored transaction by hash with the addresses it was stored for
+	// that the tenant sees, false if it is not stored for any of them
+	GetTransaction(tenant, hash string) (types.TransactionLookup, bool)
+
+	// GetBlockTransactions - transactions stored from a block with the addresses they were
+	// stored for that the tenant sees, ordered by hash
+	GetBlockTransactions(tenant string, block int) []types.TransactionLookup
+
+	// AddTokenTransfer - store a token transfer for its subscribed sender and/or recipient,
+	// false if it was already stored for all of them
+	AddTokenTransfer(transfer types.TokenTransfer) bool
+
+	// GetTokenTransfers - list of stored token transfers for an address
+	GetTokenTransfers(tenant, address string) []types.TokenTransfer
+
+	// AddInternalTransaction - store an internal transaction for its subscribed sender and/or
+	// recipient, false if it was already stored for all of them
+	AddInternalTransaction(tx types.InternalTransaction) bool
+
+	// GetInternalTransactions - list of stored internal transactions for an address
+	GetInternalTransactions(tenant, address string) []types.InternalTransaction
+
+	// AddNFTTransfer - store an NFT transfer for its subscribed sender and/or recipient,
+	// false if it was already stored for all of them
+	AddNFTTransfer(transfer types.NFTTransfer) bool
+
+	// GetNFTTransfers - list of stored NFT transfers for an address
+	GetNFTTransfers(tenant, address string) []types.NFTTransfer
+
+	// RemoveTransactionsFrom - drop transactions, internal transactions and token and NFT
+	// transfers at or above a block,
+	// returns how many were removed
+	RemoveTransactionsFrom(block int) int
+
+	// UpdateStatuses - mark all stored records up to confirmedBlock as confirmed and up to
+	// finalizedBlock as finalized, returns how many changed
+	UpdateStatuses(confirmedBlock, finalizedBlock int) int
+
+	// RecordBlockFailure - queue a block for a retry or count another failed attempt
+	RecordBlockFailure(block int, reason string, at int64) types.FailedBlock
+
+	// RemoveFailedBlock - drop a block from the retry queue
+	RemoveFailedBlock(block int)
+
+	// GetFailedBlocks - blocks queued for a retry, lowest first
+	GetFailedBlocks() []types.FailedBlock
+
+	// AddWebhookDelivery - queue a webhook delivery, returned with its assigned ID
+	AddWebhookDelivery(delivery types.WebhookDelivery) types.WebhookDelivery
+
+	// UpdateWebhookDelivery - record the outcome of a failed delivery attempt
+	UpdateWebhookDelivery(delivery types.WebhookDelivery)
+
+	// RemoveWebhookDelivery - drop a delivered webhook from the queue
+	RemoveWebhookDelivery(id int)
+
+	// GetWebhookDeliveries - queued deliveries of a tenant with a status, all of them when empty,
+	// oldest first
+	GetWebhookDeliveries(tenant, status string) []types.WebhookDelivery
+
+	// AddAPIKey - store an API key, returned with its assigned ID
+	AddAPIKey(key types.APIKey) types.APIKey
+
+	// GetAPIKeys - stored API keys, oldest first
+	GetAPIKeys() []types.APIKey
+
+	// GetAPIKeyByHash - the API key with a hash, false if there is none
+	GetAPIKeyByHash(hash string) (types.APIKey, bool)
+
+	// RemoveAPIKey - revoke an API key, false if it does not exist
+	RemoveAPIKey(id int) bool
+
+	// SetCurrentBlock - move the last parsed block cursor
+	SetCurrentBlock(block int)
+
+	// GetCurrentBlock - last parsed block
+	GetCurrentBlock() int
+}
+
+var (
+	_ Storage = (*MemoryStorage)(nil)
+	_ Storage = (*FileStorage)(nil)
+)
@@ -0,0 +1,352 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"ethparser/internal/storage"
+	"ethparser/pkg/types"
+)
+
+// Headers set on every delivery
+const (
+	SignatureHeader = "X-Ethparser-Signature"
+	TimestampHeader = "X-Ethparser-Timestamp"
+	DeliveryHeader  = "X-Ethparser-Delivery"
+)
+
+// EventTransaction is the event of a payload announcing a new transaction
+const EventTransaction = "transaction"
+
+// ErrForbiddenAddress is returned for deliveries to a webhook resolving to a
+// loopback, link-local or private address
+var ErrForbiddenAddress = errors.New("webhook address is not public")
+
+// errNoSecret fails deliveries queued without a key to sign them, which are
+// dead-lettered instead of being sent unsigned
+var errNoSecret = errors.New("no secret to sign the delivery with")
+
+// Config holds the delivery settings of a Dispatcher
+type Config struct {
+	// Secret - key of the HMAC-SHA256 signature of payloads to subscriptions that
+	// have no secret of their own, i.e. created before each got one. Their
+	// deliveries are dead-lettered when it is empty, as nothing is sent unsigned.
+	Secret string
+
+	// AllowPrivateNetworks - let webhooks reach loopback, link-local and private
+	// addresses, which are refused by default so subscribers cannot probe the
+	// network the service runs in
+	AllowPrivateNetworks bool
+
+	// MaxAttempts - deliveries failing this many times are moved to the dead-letter list
+	MaxAttempts int
+
+	// BaseBackoff - delay before the first retry, doubled for every following one
+	BaseBackoff time.Duration
+
+	// MaxBackoff - upper bound for a single retry delay
+	MaxBackoff time.Duration
+
+	// Timeout - limit for a single HTTP request
+	Timeout time.Duration
+
+	// PollInterval - how often the queue is checked for deliveries due for a retry
+	PollInterval time.Duration
+}
+
+// DefaultConfig returns the settings used when nothing is configured
+func DefaultConfig() Config {
+	return Config{
+		MaxAttempts:  8,
+		BaseBackoff:  time.Second,
+		MaxBackoff:   10 * time.Minute,
+		Timeout:      10 * time.Second,
+		PollInterval: time.Second,
+	}
+}
+
+// Payload is the JSON body posted to a webhook
+type Payload struct {
+	DeliveryID  int                     `json:"deliveryId"`
+	Event       string                  `json:"event"`
+	Address     string                  `json:"address"`
+	Transaction types.ParsedTransaction `json:"transaction"`
+}
+
+// Dispatcher posts the transactions of subscribed addresses to their webhooks.
+// Deliveries are queued in storage first, so they survive a restart, and are
+// retried with exponential backoff until they succeed or run out of attempts.
+type Dispatcher struct {
+	storage storage.Storage
+	client  *http.Client
+	config  Config
+	logger  *log.Logger
+
+	wake     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func NewDispatcher(store storage.Storage, config Config, logger *log.Logger) *Dispatcher {
+	return &Dispatcher{
+		storage: store,
+		client:  newClient(config),
+		config:  config,
+		logger:  logger,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// newClient returns the HTTP client of the deliveries. Unless private networks
+// are allowed it checks every address it connects to, after DNS resolution and
+// on redirects too, and it ignores proxy settings so the check applies to the
+// webhook itself.
+func newClient(config Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !config.AllowPrivateNetworks {
+		dialer := &net.Dialer{
+			Timeout: config.Timeout,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				addrPort, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				if !isPublic(addrPort.Addr()) {
+					return fmt.Errorf("%w: %s", ErrForbiddenAddress, addrPort.Addr())
+				}
+				return nil
+			},
+		}
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		}
+	}
+	return &http.Client{Timeout: config.Timeout, Transport: transport}
+}
+
+// isPublic reports whether addr is a globally routable unicast address
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !addr.IsLoopback() && !addr.IsLinkLocalUnicast() &&
+		!cgnat.Contains(addr)
+}
+
+// cgnat is the shared address space of carrier-grade NAT, not reachable publicly
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// Notify queues a delivery of tx for every subscription of an address it involves
+// that has a webhook, once per tenant. It only writes to the queue and is safe to
+// use as a parser listener.
+func (d *Dispatcher) Notify(tx types.ParsedTransaction) {
+	queued := false
+	for _, address := range involvedAddresses(tx) {
+		for _, sub := range d.storage.GetSubscribers(address) {
+			if sub.WebhookURL == "" {
+				continue
+			}
+
+			secret := sub.WebhookSecret
+			if secret == "" {
+				secret = d.config.Secret
+			}
+
+			now := time.Now().Unix()
+			delivery := d.storage.AddWebhookDelivery(types.WebhookDelivery{
+				Tenant:        sub.Tenant,
+				URL:           sub.WebhookURL,
+				Address:       sub.Address,
+				Transaction:   tx,
+				Status:        types.DeliveryPending,
+				CreatedAt:     now,
+				NextAttemptAt: now,
+				Secret:        secret,
+			})
+			d.logger.Printf("Queued webhook delivery %d of %s for %s (tenant %s)", delivery.ID, tx.Hash, sub.Address, sub.Tenant)
+			queued = true
+		}
+	}
+
+	if queued {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// involvedAddresses returns the distinct addresses a transaction belongs to
+func involvedAddresses(tx types.ParsedTransaction) []string {
+	var addresses []string
+	for _, address := range []string{tx.From, tx.To, tx.ContractAddress} {
+		address = types.NormalizeAddress(address)
+		if address == "" {
+			continue
+		}
+		duplicate := false
+		for _, seen := range addresses {
+			duplicate = duplicate || seen == address
+		}
+		if !duplicate {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// Start delivers queued webhooks in the background until Stop is called
+func (d *Dispatcher) Start() {
+	go d.run()
+}
+
+// Stop ends background delivery started by Start and waits for it to return
+func (d *Dispatcher) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.stop)
+	})
+	<-d.done
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+
+	interval := d.config.PollInterval
+	if interval <= 0 {
+		interval = DefaultConfig().PollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		d.DeliverDue(time.Now())
+
+		select {
+		case <-d.stop:
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue attempts every pending delivery due at now, and returns how many succeeded
+func (d *Dispatcher) DeliverDue(now time.Time) int {
+	delivered := 0
+	for _, delivery := range d.storage.GetWebhookDeliveries("", types.DeliveryPending) {
+		if delivery.NextAttemptAt > now.Unix() {
+			continue
+		}
+
+		err := d.deliver(delivery, now)
+		if err == nil {
+			d.storage.RemoveWebhookDelivery(delivery.ID)
+			d.logger.Printf("Delivered webhook %d to %s", delivery.ID, delivery.URL)
+			delivered++
+			continue
+		}
+
+		delivery.Attempts++
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= d.config.MaxAttempts || errors.Is(err, errNoSecret) {
+			delivery.Status = types.DeliveryDead
+			d.logger.Printf("Webhook %d to %s failed %d times, moved to dead letters: %v", delivery.ID, delivery.URL, delivery.Attempts, err)
+		} else {
+			delay := d.backoff(delivery.Attempts - 1)
+			delivery.NextAttemptAt = now.Add(delay).Unix()
+			d.logger.Printf("Webhook %d to %s failed (attempt %d/%d), retrying in %v: %v", delivery.ID, delivery.URL, delivery.Attempts, d.config.MaxAttempts, delay, err)
+		}
+		d.storage.UpdateWebhookDelivery(delivery)
+	}
+	return delivered
+}
+
+// deliver posts a delivery once, failing unless the webhook answers with a 2xx status
+func (d *Dispatcher) deliver(delivery types.WebhookDelivery, now time.Time) error {
+	if delivery.Secret == "" {
+		return errNoSecret
+	}
+
+	body, err := json.Marshal(Payload{
+		DeliveryID:  delivery.ID,
+		Event:       EventTransaction,
+		Address:     types.ChecksumAddress(delivery.Address),
+		Transaction: delivery.Transaction,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, strconv.Itoa(delivery.ID))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, "sha256="+Sign(delivery.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// backoff returns the exponential delay before retry number attempt+1, with
+// jitter spreading it over the upper half
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.config.BaseBackoff << attempt
+	if delay <= 0 || (d.config.MaxBackoff > 0 && delay > d.config.MaxBackoff) {
+		delay = d.config.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// Sign returns the hex HMAC-SHA256 of "timestamp.body" with secret. Receivers
+// recompute it to check that a payload came from this service, and reject old
+// timestamps to prevent replays.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature, as sent in the signature header, matches body
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	expected := "sha256=" + Sign(secret, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
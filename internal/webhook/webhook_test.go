@@ -0,0 +1,319 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"ethparser/internal/storage"
+	"ethparser/pkg/types"
+)
+
+const (
+	secret  = "s3cret"
+	wallet  = "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"
+	checked = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+)
+
+// receiver is a local stand-in for a webhook endpoint that fails the first
+// failures requests and records the payloads it accepts
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	requests int
+	payloads []Payload
+	errors   []string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests++
+	if r.requests <= r.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	body, _ := io.ReadAll(req.Body)
+	timestamp, _ := strconv.ParseInt(req.Header.Get(TimestampHeader), 10, 64)
+	if !Verify(secret, timestamp, body, req.Header.Get(SignatureHeader)) {
+		r.errors = append(r.errors, "invalid signature")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		r.errors = append(r.errors, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if req.Header.Get(DeliveryHeader) != strconv.Itoa(payload.DeliveryID) {
+		r.errors = append(r.errors, "delivery header does not match payload")
+	}
+	r.payloads = append(r.payloads, payload)
+}
+
+func setup(t *testing.T, failures int) (*Dispatcher, *storage.MemoryStorage, *receiver) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	store := storage.NewMemoryStorage(logger)
+
+	recv := &receiver{failures: failures}
+	server := httptest.NewServer(recv)
+	t.Cleanup(server.Close)
+
+	store.AddSubscription(types.Subscription{Address: wallet, WebhookURL: server.URL, WebhookSecret: secret})
+	store.Subscribe("0xbbb")
+
+	// Subscriptions with a secret of their own are not signed with the global one
+	config := DefaultConfig()
+	config.Secret = "global"
+	config.AllowPrivateNetworks = true
+	config.MaxAttempts = 3
+	return NewDispatcher(store, config, logger), store, recv
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"event":"transaction"}`)
+	signature := "sha256=" + Sign(secret, 1700000000, body)
+
+	if !Verify(secret, 1700000000, body, signature) {
+		t.Error("Expected signature to verify")
+	}
+	if Verify(secret, 1700000001, body, signature) {
+		t.Error("Expected signature of another timestamp to be rejected")
+	}
+	if Verify("other", 1700000000, body, signature) {
+		t.Error("Expected signature with another secret to be rejected")
+	}
+	if Verify(secret, 1700000000, []byte(`{"event":"other"}`), signature) {
+		t.Error("Expected signature of another body to be rejected")
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	tx := types.ParsedTransaction{Hash: "0xabc", From: "0xbbb", To: wallet, Value: "0x1", BlockNumber: 100}
+
+	t.Run("Deliver", func(t *testing.T) {
+		dispatcher, store, recv := setup(t, 0)
+
+		// Only the subscription with a webhook gets a delivery
+		dispatcher.Notify(tx)
+		if pending := store.GetWebhookDeliveries("", types.DeliveryPending); len(pending) != 1 {
+			t.Fatalf("Expected 1 pending delivery, got %d", len(pending))
+		}
+
+		if delivered := dispatcher.DeliverDue(time.Now()); delivered != 1 {
+			t.Errorf("Expected 1 delivery, got %d", delivered)
+		}
+		if len(recv.errors) != 0 {
+			t.Errorf("Receiver rejected the delivery: %v", recv.errors)
+		}
+		if len(recv.payloads) != 1 {
+			t.Fatalf("Expected 1 payload, got %d", len(recv.payloads))
+		}
+		payload := recv.payloads[0]
+		if payload.Event != EventTransaction || payload.Address != checked || payload.Transaction.Hash != tx.Hash {
+			t.Errorf("Unexpected payload %+v", payload)
+		}
+		if remaining := store.GetWebhookDeliveries("", ""); len(remaining) != 0 {
+			t.Errorf("Expected delivered webhook to leave the queue, got %+v", remaining)
+		}
+	})
+
+	t.Run("PerTenant", func(t *testing.T) {
+		dispatcher, store, _ := setup(t, 0)
+		store.AddSubscription(types.Subscription{Tenant: "acme", Address: wallet, WebhookURL: "https://acme.example.com/hook"})
+
+		// Each tenant with a webhook on the address gets its own delivery
+		dispatcher.Notify(tx)
+		if pending := store.GetWebhookDeliveries("acme", types.DeliveryPending); len(pending) != 1 || pending[0].URL != "https://acme.example.com/hook" {
+			t.Errorf("Expected 1 delivery for acme, got %+v", pending)
+		}
+		if pending := store.GetWebhookDeliveries(types.DefaultTenant, types.DeliveryPending); len(pending) != 1 {
+			t.Errorf("Expected 1 delivery for the default tenant, got %d", len(pending))
+		}
+	})
+
+	t.Run("PrivateNetworkRefused", func(t *testing.T) {
+		dispatcher, store, recv := setup(t, 0)
+		dispatcher.client = newClient(DefaultConfig())
+		dispatcher.Notify(tx)
+
+		delivery := store.GetWebhookDeliveries("", types.DeliveryPending)[0]
+		if err := dispatcher.deliver(delivery, time.Now()); !errors.Is(err, ErrForbiddenAddress) {
+			t.Errorf("Expected a loopback webhook to be refused, got %v", err)
+		}
+		if recv.requests != 0 {
+			t.Errorf("Expected no request to reach the webhook, got %d", recv.requests)
+		}
+	})
+
+	t.Run("RetryWithBackoff", func(t *testing.T) {
+		dispatcher, store, recv := setup(t, 1)
+		dispatcher.config.BaseBackoff = time.Minute
+		dispatcher.Notify(tx)
+
+		now := time.Now()
+		if delivered := dispatcher.DeliverDue(now); delivered != 0 {
+			t.Errorf("Expected failed delivery, got %d delivered", delivered)
+		}
+		pending := store.GetWebhookDeliveries("", types.DeliveryPending)
+		if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].LastError == "" {
+			t.Fatalf("Expected failed delivery to stay queued with its error, got %+v", pending)
+		}
+		if pending[0].NextAttemptAt < now.Add(30*time.Second).Unix() || pending[0].NextAttemptAt > now.Add(time.Minute).Unix() {
+			t.Errorf("Expected retry within the base backoff, got %d at %d", pending[0].NextAttemptAt, now.Unix())
+		}
+
+		// Not due before its backoff elapsed
+		dispatcher.DeliverDue(now.Add(10 * time.Second))
+		if recv.requests != 1 {
+			t.Errorf("Expected delivery to wait for its backoff, got %d requests", recv.requests)
+		}
+
+		if delivered := dispatcher.DeliverDue(now.Add(time.Minute)); delivered != 1 {
+			t.Errorf("Expected retry to succeed, got %d delivered", delivered)
+		}
+		if len(recv.payloads) != 1 {
+			t.Errorf("Expected 1 payload, got %d", len(recv.payloads))
+		}
+	})
+
+	t.Run("DeadLetter", func(t *testing.T) {
+		dispatcher, store, recv := setup(t, 100)
+		dispatcher.Notify(tx)
+
+		now := time.Now()
+		for i := 0; i < 3; i++ {
+			dispatcher.DeliverDue(now)
+			now = now.Add(time.Hour)
+		}
+
+		if recv.requests != 3 {
+			t.Errorf("Expected 3 attempts, got %d", recv.requests)
+		}
+		dead := store.GetWebhookDeliveries("", types.DeliveryDead)
+		if len(dead) != 1 || dead[0].Attempts != 3 {
+			t.Fatalf("Expected delivery to be dead-lettered after 3 attempts, got %+v", dead)
+		}
+
+		// Dead deliveries are not retried
+		dispatcher.DeliverDue(now)
+		if recv.requests != 3 {
+			t.Errorf("Expected no more attempts, got %d", recv.requests)
+		}
+	})
+
+	t.Run("SecretOutlivesUnsubscribe", func(t *testing.T) {
+		dispatcher, store, recv := setup(t, 0)
+		dispatcher.Notify(tx)
+		store.Unsubscribe(types.DefaultTenant, wallet, false)
+
+		// Queued with the secret of the subscription, not the global one
+		if delivered := dispatcher.DeliverDue(time.Now()); delivered != 1 {
+			t.Errorf("Expected 1 delivery, got %d", delivered)
+		}
+		if len(recv.errors) != 0 {
+			t.Errorf("Receiver rejected the delivery: %v", recv.errors)
+		}
+	})
+
+	t.Run("NoSecret", func(t *testing.T) {
+		dispatcher, store, recv := setup(t, 0)
+		dispatcher.config.Secret = ""
+		store.AddSubscription(types.Subscription{Tenant: "acme", Address: wallet, WebhookURL: "https://acme.example.com/hook"})
+		dispatcher.Notify(tx)
+
+		// The delivery without a key is dead-lettered right away, never sent unsigned
+		dispatcher.DeliverDue(time.Now())
+		if dead := store.GetWebhookDeliveries("acme", types.DeliveryDead); len(dead) != 1 || dead[0].Attempts != 1 {
+			t.Errorf("Expected the unsigned delivery to be dead-lettered, got %+v", dead)
+		}
+		if recv.requests != 1 || len(recv.payloads) != 1 {
+			t.Errorf("Expected only the signed delivery to be sent, got %d requests", recv.requests)
+		}
+	})
+
+	t.Run("Background", func(t *testing.T) {
+		dispatcher, _, recv := setup(t, 0)
+		dispatcher.Start()
+		defer dispatcher.Stop()
+
+		dispatcher.Notify(tx)
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			recv.mu.Lock()
+			delivered := len(recv.payloads)
+			recv.mu.Unlock()
+			if delivered == 1 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Error("Expected background delivery")
+	})
+}
+
+func TestPersistentQueue(t *testing.T) {
+	logger := log.New(os.Stdout, "test: ", log.LstdFlags)
+	dir := t.TempDir()
+
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	store, err := storage.NewFileStorage(dir, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.AddSubscription(types.Subscription{Address: wallet, WebhookURL: server.URL})
+
+	config := DefaultConfig()
+	config.Secret = secret
+	config.AllowPrivateNetworks = true
+	NewDispatcher(store, config, logger).Notify(types.ParsedTransaction{Hash: "0xabc", From: wallet, BlockNumber: 100})
+
+	// Restart before the delivery went out
+	restored, err := storage.NewFileStorage(dir, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivered := NewDispatcher(restored, config, logger).DeliverDue(time.Now()); delivered != 1 {
+		t.Errorf("Expected queued delivery to survive a restart, got %d delivered", delivered)
+	}
+	if len(recv.payloads) != 1 || recv.payloads[0].Transaction.Hash != "0xabc" {
+		t.Errorf("Unexpected payloads %+v", recv.payloads)
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	for address, public := range map[string]bool{
+		"93.184.215.14":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"192.168.0.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if got := isPublic(netip.MustParseAddr(address)); got != public {
+			t.Errorf("isPublic(%s) = %v, expected %v", address, got, public)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package websocket
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newEchoServer echoes every message back, pinging the client before each answer
+func newEchoServer(t *testing.T) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			opcode, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(PingMessage, []byte("ping")); err != nil {
+				return
+			}
+			if err := conn.WriteMessage(opcode, data); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestConn(t *testing.T) {
+	url := newEchoServer(t)
+
+	conn, err := Dial(url, time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	messages := [][]byte{
+		[]byte(`{"jsonrpc":"2.0","id":1}`),
+		bytes.Repeat([]byte("a"), 300),
+		bytes.Repeat([]byte("b"), 70000),
+	}
+	for _, message := range messages {
+		require.NoError(t, conn.WriteMessage(TextMessage, message))
+
+		opcode, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, TextMessage, opcode)
+		assert.Equal(t, message, data)
+	}
+}
+
+func TestUpgradeRejectsPlainRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = Upgrade(w, r)
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestDialErrors(t *testing.T) {
+	_, err := Dial("http://localhost:1", time.Second)
+	assert.ErrorContains(t, err, "unsupported websocket scheme")
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	_, err = Dial("ws"+strings.TrimPrefix(server.URL, "http"), time.Second)
+	assert.ErrorContains(t, err, "status 404")
+}
@@ -0,0 +1,100 @@
+package types
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Address is a 20-byte Ethereum account or contract address
+type Address [20]byte
+
+// ParseAddress decodes a 0x-prefixed 40 digit hex address. All-lowercase and
+// all-uppercase addresses are accepted as is; mixed-case addresses must carry
+// a valid EIP-55 checksum.
+func ParseAddress(s string) (Address, error) {
+	var a Address
+
+	if !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+		return a, fmt.Errorf("invalid address %q: missing 0x prefix", s)
+	}
+	digits := s[2:]
+	if len(digits) != 2*len(a) {
+		return a, fmt.Errorf("invalid address %q: expected %d hex digits", s, 2*len(a))
+	}
+	if _, err := hex.Decode(a[:], []byte(digits)); err != nil {
+		return a, fmt.Errorf("invalid address %q: not hex", s)
+	}
+
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) && digits != a.Checksum()[2:] {
+		return a, fmt.Errorf("invalid address %q: bad EIP-55 checksum", s)
+	}
+	return a, nil
+}
+
+// IsValidAddress reports whether s parses as an address
+func IsValidAddress(s string) bool {
+	_, err := ParseAddress(s)
+	return err == nil
+}
+
+// NormalizeAddress returns the lowercase form addresses are stored and compared in
+func NormalizeAddress(s string) string {
+	return strings.ToLower(s)
+}
+
+// ChecksumAddress returns the EIP-55 form of an address, or s unchanged when it
+// is not a valid address, e.g. the empty recipient of a contract creation
+func ChecksumAddress(s string) string {
+	a, err := ParseAddress(s)
+	if err != nil {
+		return s
+	}
+	return a.Checksum()
+}
+
+// Hex returns the address as lowercase hex
+func (a Address) Hex() string {
+	return "0x" + hex.EncodeToString(a[:])
+}
+
+// Checksum returns the address in EIP-55 mixed case: a letter is uppercased
+// when the matching nibble of the Keccak-256 hash of the lowercase hex is 8 or more
+func (a Address) Checksum() string {
+	digits := []byte(hex.EncodeToString(a[:]))
+	hash := Keccak256(digits)
+
+	for i, c := range digits {
+		nibble := hash[i/2] >> 4
+		if i%2 == 1 {
+			nibble = hash[i/2] & 0x0f
+		}
+		if c >= 'a' && nibble >= 8 {
+			digits[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(digits)
+}
+
+func (a Address) String() string {
+	return a.Checksum()
+}
+
+func (a Address) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.Checksum())
+}
+
+func (a *Address) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid address %s: not a string", data)
+	}
+
+	parsed, err := ParseAddress(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
@@ -0,0 +1,13 @@
+package types
+
+import "golang.org/x/crypto/sha3"
+
+// Keccak256 returns the Keccak-256 hash of data as used by Ethereum. It uses the
+// original Keccak padding, not the one of the standardized SHA3-256.
+func Keccak256(data []byte) [32]byte {
+	var hash [32]byte
+	h := sha3.NewLegacyKeccak256()
+	h.Write(data)
+	h.Sum(hash[:0])
+	return hash
+}
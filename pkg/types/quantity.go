@@ -0,0 +1,142 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// maxQuantityBits bounds quantities to the EVM word size
+const maxQuantityBits = 256
+
+// etherDecimals is the number of decimals of an amount in wei
+const etherDecimals = 18
+
+// Quantity is an unsigned integer encoded as 0x-prefixed hex, as JSON-RPC returns
+// values, balances, gas and block numbers. The zero value is 0.
+type Quantity struct {
+	n *big.Int
+}
+
+// ParseQuantity decodes a 0x-prefixed hex quantity, rejecting anything that is
+// not a hex number of at most 256 bits
+func ParseQuantity(s string) (Quantity, error) {
+	if !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+		return Quantity{}, fmt.Errorf("invalid quantity %q: missing 0x prefix", s)
+	}
+
+	digits := s[2:]
+	if digits == "" {
+		return Quantity{}, fmt.Errorf("invalid quantity %q: no digits", s)
+	}
+	for _, c := range digits {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return Quantity{}, fmt.Errorf("invalid quantity %q: invalid hex digit %q", s, c)
+		}
+	}
+
+	n, _ := new(big.Int).SetString(digits, 16)
+	if n.BitLen() > maxQuantityBits {
+		return Quantity{}, fmt.Errorf("invalid quantity %q: exceeds %d bits", s, maxQuantityBits)
+	}
+	return Quantity{n: n}, nil
+}
+
+// NewQuantity returns the quantity of a non-negative integer
+func NewQuantity(n *big.Int) Quantity {
+	return Quantity{n: new(big.Int).Set(n)}
+}
+
+// Big returns a copy of the quantity as a big.Int
+func (q Quantity) Big() *big.Int {
+	if q.n == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(q.n)
+}
+
+// Sign returns 0 for a zero quantity and 1 otherwise
+func (q Quantity) Sign() int {
+	if q.n == nil {
+		return 0
+	}
+	return q.n.Sign()
+}
+
+// Int64 returns the quantity as an int64, failing when it does not fit
+func (q Quantity) Int64() (int64, error) {
+	if q.n == nil {
+		return 0, nil
+	}
+	if !q.n.IsInt64() {
+		return 0, fmt.Errorf("quantity %s overflows int64", q.Hex())
+	}
+	return q.n.Int64(), nil
+}
+
+// Int returns the quantity as an int, failing when it does not fit, for values
+// such as block numbers, timestamps and log indexes
+func (q Quantity) Int() (int, error) {
+	n, err := q.Int64()
+	if err != nil || n > math.MaxInt {
+		return 0, fmt.Errorf("quantity %s out of range", q.Hex())
+	}
+	return int(n), nil
+}
+
+// Hex returns the quantity as 0x-prefixed hex without leading zeros
+func (q Quantity) Hex() string {
+	return "0x" + q.Big().Text(16)
+}
+
+// Decimal returns the quantity in base 10, e.g. an amount in wei
+func (q Quantity) Decimal() string {
+	return q.Big().String()
+}
+
+// Ether formats an amount in wei as ether, e.g. "1.5" for 0x14d1120d7b160000
+func (q Quantity) Ether() string {
+	return FormatUnits(q.Big(), etherDecimals)
+}
+
+func (q Quantity) String() string {
+	return q.Hex()
+}
+
+func (q Quantity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(q.Hex())
+}
+
+func (q *Quantity) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid quantity %s: not a string", data)
+	}
+
+	parsed, err := ParseQuantity(s)
+	if err != nil {
+		return err
+	}
+	*q = parsed
+	return nil
+}
+
+// FormatUnits formats an integer amount with the given number of decimals,
+// without trailing zeros: FormatUnits(1500, 3) is "1.5"
+func FormatUnits(amount *big.Int, decimals int) string {
+	digits := new(big.Int).Abs(amount).String()
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+
+	whole, fraction := digits[:len(digits)-decimals], strings.TrimRight(digits[len(digits)-decimals:], "0")
+	if amount.Sign() < 0 {
+		whole = "-" + whole
+	}
+	if fraction == "" {
+		return whole
+	}
+	return whole + "." + fraction
+}
@@ -0,0 +1,143 @@
+package types
+
+import (
+	"cmp"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Transaction directions relative to the queried address
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+// Sort orders of a transaction query, by block number
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+// TransactionQuery selects a page of the transactions stored for an address.
+// Zero values leave a filter unset.
+type TransactionQuery struct {
+	Address string
+
+	// Tenant - only answer when the address is visible to this tenant, any tenant when empty
+	Tenant string
+
+	// Direction - DirectionIn for transactions received, DirectionOut for transactions sent
+	Direction string
+
+	// Status - finality status, one of StatusPending, StatusConfirmed, StatusFinalized
+	Status string
+
+	// FromBlock, ToBlock - inclusive block range
+	FromBlock int64
+	ToBlock   int64
+
+	// FromTime, ToTime - inclusive block timestamp range, in unix seconds
+	FromTime int64
+	ToTime   int64
+
+	// MinValue, MaxValue - inclusive value range in wei
+	MinValue *big.Int
+	MaxValue *big.Int
+
+	// Order - SortAsc (oldest first, the default) or SortDesc
+	Order string
+
+	// Limit - maximum number of transactions returned, unlimited when 0
+	Limit int
+
+	// After - continue after this transaction, as returned in TransactionPage.Next
+	After *TransactionCursor
+}
+
+// TransactionPage is the result of a TransactionQuery. Next is set when more
+// transactions match the query.
+type TransactionPage struct {
+	Transactions []ParsedTransaction
+	Next         *TransactionCursor
+}
+
+// TransactionCursor identifies a position in the transaction history of an
+// address, which is ordered by block number and then by hash
+type TransactionCursor struct {
+	BlockNumber int64
+	Hash        string
+}
+
+// ParseTransactionCursor decodes a cursor formatted by TransactionCursor.String
+func ParseTransactionCursor(s string) (TransactionCursor, error) {
+	block, hash, ok := strings.Cut(s, ":")
+	if !ok {
+		return TransactionCursor{}, fmt.Errorf("invalid cursor %q", s)
+	}
+	number, err := strconv.ParseInt(block, 10, 64)
+	if err != nil || number < 0 || !strings.HasPrefix(hash, "0x") {
+		return TransactionCursor{}, fmt.Errorf("invalid cursor %q", s)
+	}
+	return TransactionCursor{BlockNumber: number, Hash: hash}, nil
+}
+
+func (c TransactionCursor) String() string {
+	return strconv.FormatInt(c.BlockNumber, 10) + ":" + c.Hash
+}
+
+// Compare returns -1 when the cursor sorts before tx in ascending order, 0 when
+// it points at tx and +1 when it sorts after it
+func (c TransactionCursor) Compare(tx ParsedTransaction) int {
+	if c.BlockNumber != tx.BlockNumber {
+		return cmp.Compare(c.BlockNumber, tx.BlockNumber)
+	}
+	return cmp.Compare(c.Hash, tx.Hash)
+}
+
+// CursorOf returns the cursor of a transaction
+func CursorOf(tx ParsedTransaction) TransactionCursor {
+	return TransactionCursor{BlockNumber: tx.BlockNumber, Hash: tx.Hash}
+}
+
+// Matches reports whether tx, stored for the queried address, passes the
+// direction, status, block, time and value filters of the query. The cursor
+// is not taken into account.
+func (q TransactionQuery) Matches(tx ParsedTransaction) bool {
+	address := NormalizeAddress(q.Address)
+	switch q.Direction {
+	case DirectionIn:
+		if NormalizeAddress(tx.To) != address && NormalizeAddress(tx.ContractAddress) != address {
+			return false
+		}
+	case DirectionOut:
+		if NormalizeAddress(tx.From) != address {
+			return false
+		}
+	}
+
+	if q.Status != "" && tx.Status != q.Status {
+		return false
+	}
+	if (q.FromBlock > 0 && tx.BlockNumber < q.FromBlock) || (q.ToBlock > 0 && tx.BlockNumber > q.ToBlock) {
+		return false
+	}
+	if (q.FromTime > 0 && tx.Timestamp < q.FromTime) || (q.ToTime > 0 && tx.Timestamp > q.ToTime) {
+		return false
+	}
+
+	if q.MinValue != nil || q.MaxValue != nil {
+		value, err := ParseQuantity(tx.Value)
+		if err != nil {
+			return false
+		}
+		if q.MinValue != nil && value.Big().Cmp(q.MinValue) < 0 {
+			return false
+		}
+		if q.MaxValue != nil && value.Big().Cmp(q.MaxValue) > 0 {
+			return false
+		}
+	}
+	return true
+}
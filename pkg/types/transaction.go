@@ -0,0 +1,83 @@
+package types
+
+// Transaction types, as the hex `type` field returned by the node
+const (
+	TxTypeLegacy     = "0x0" // pre EIP-2718 transactions
+	TxTypeAccessList = "0x1" // EIP-2930
+	TxTypeDynamicFee = "0x2" // EIP-1559
+	TxTypeBlob       = "0x3" // EIP-4844
+	TxTypeSetCode    = "0x4" // EIP-7702
+)
+
+// Block represents an Ethereum block as returned by eth_getBlockByNumber with full transactions
+type Block struct {
+	Number        Quantity      `json:"number"`
+	Hash          string        `json:"hash"`
+	ParentHash    string        `json:"parentHash"`
+	Timestamp     Quantity      `json:"timestamp"`
+	Miner         string        `json:"miner,omitempty"`
+	GasLimit      string        `json:"gasLimit,omitempty"`
+	GasUsed       string        `json:"gasUsed,omitempty"`
+	BaseFeePerGas string        `json:"baseFeePerGas,omitempty"`
+	BlobGasUsed   string        `json:"blobGasUsed,omitempty"`
+	ExcessBlobGas string        `json:"excessBlobGas,omitempty"`
+	Transactions  []Transaction `json:"transactions"`
+}
+
+// Transaction represents the raw transaction from Ethereum RPC. Fields that
+// only exist for some transaction types are left empty, or nil, for the others.
+// Numeric fields are validated as quantities when decoded.
+type Transaction struct {
+	Type             string    `json:"type"`
+	Hash             string    `json:"hash"`
+	BlockHash        string    `json:"blockHash,omitempty"`
+	BlockNumber      string    `json:"blockNumber"`
+	TransactionIndex string    `json:"transactionIndex,omitempty"`
+	From             string    `json:"from"`
+	To               string    `json:"to"` // empty for contract creation
+	Value            Quantity  `json:"value"`
+	Nonce            Quantity  `json:"nonce"`
+	Input            string    `json:"input"`
+	Gas              Quantity  `json:"gas"`
+	ChainID          *Quantity `json:"chainId,omitempty"`
+
+	// GasPrice - set for legacy and access list transactions; for the others
+	// nodes report the effective price paid
+	GasPrice *Quantity `json:"gasPrice,omitempty"`
+
+	// EIP-1559 fee caps, types 0x2 and later
+	MaxFeePerGas         *Quantity `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *Quantity `json:"maxPriorityFeePerGas,omitempty"`
+
+	// AccessList - EIP-2930, types 0x1 and later
+	AccessList []AccessTuple `json:"accessList,omitempty"`
+
+	// EIP-4844 blob fields, type 0x3
+	MaxFeePerBlobGas    *Quantity `json:"maxFeePerBlobGas,omitempty"`
+	BlobVersionedHashes []string  `json:"blobVersionedHashes,omitempty"`
+
+	// AuthorizationList - EIP-7702 delegations, type 0x4
+	AuthorizationList []Authorization `json:"authorizationList,omitempty"`
+
+	// Signature
+	V       string `json:"v"`
+	R       string `json:"r"`
+	S       string `json:"s"`
+	YParity string `json:"yParity,omitempty"`
+}
+
+// AccessTuple is an address and the storage slots a transaction declares it will access
+type AccessTuple struct {
+	Address     string   `json:"address"`
+	StorageKeys []string `json:"storageKeys"`
+}
+
+// Authorization is a signed EIP-7702 delegation of an account to contract code
+type Authorization struct {
+	ChainID string `json:"chainId"`
+	Address string `json:"address"`
+	Nonce   string `json:"nonce"`
+	YParity string `json:"yParity"`
+	R       string `json:"r"`
+	S       string `json:"s"`
+}
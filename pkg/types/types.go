@@ -1,32 +1,260 @@
 package types
 
-// Block represents an Ethereum block structure
-type Block struct {
-	Number       string        `json:"number"`
-	Hash         string        `json:"hash"`
-	Timestamp    string        `json:"timestamp"`
-	Transactions []Transaction `json:"transactions"`
+import "errors"
+
+// Transaction statuses, advancing as blocks are built on top of the transaction
+const (
+	StatusPending   = "pending"
+	StatusConfirmed = "confirmed"
+	StatusFinalized = "finalized"
+)
+
+// Execution results of a transaction, from its receipt
+const (
+	ExecutionSuccess  = "success"
+	ExecutionReverted = "reverted"
+)
+
+// ParsedTransaction represents our processed transaction with converted values.
+// Status is the finality of its block; ExecutionStatus and the fee fields come
+// from its receipt. Value is hex as returned by the node, the other amounts are
+// in wei as decimal strings, with Eth variants formatted in ether.
+type ParsedTransaction struct {
+	Hash              string `json:"hash"`
+	From              string `json:"from"`
+	To                string `json:"to"`
+	Value             string `json:"value"`
+	ValueWei          string `json:"valueWei"`
+	ValueEth          string `json:"valueEth"`
+	BlockNumber       int64  `json:"blockNumber"`
+	Timestamp         int64  `json:"timestamp"`
+	Status            string `json:"status"`
+	ExecutionStatus   string `json:"executionStatus,omitempty"`
+	GasUsed           int64  `json:"gasUsed,omitempty"`
+	EffectiveGasPrice string `json:"effectiveGasPrice,omitempty"`
+	Fee               string `json:"fee,omitempty"`
+	FeeEth            string `json:"feeEth,omitempty"`
+	ContractAddress   string `json:"contractAddress,omitempty"`
+	LogCount          int    `json:"logCount"`
 }
 
-// Transaction represents the raw transaction from Ethereum RPC
-type Transaction struct {
-	Hash        string `json:"hash"`
+// TokenTransfer is an ERC-20 Transfer event emitted by a token contract
+type TokenTransfer struct {
+	TxHash      string `json:"txHash"`
+	LogIndex    int    `json:"logIndex"`
+	BlockNumber int64  `json:"blockNumber"`
+	Timestamp   int64  `json:"timestamp"`
+	Token       string `json:"token"`
 	From        string `json:"from"`
 	To          string `json:"to"`
-	Value       string `json:"value"`
-	BlockNumber string `json:"blockNumber"`
+	Amount      string `json:"amount"`
+	Status      string `json:"status"`
 }
 
-// ParsedTransaction represents our processed transaction with converted values
-type ParsedTransaction struct {
-	Hash        string `json:"hash"`
-	From        string `json:"from"`
-	To          string `json:"to"`
-	Value       string `json:"value"`
+// InternalTransaction is a value transfer made by a contract during the
+// execution of a transaction, found by tracing the block
+type InternalTransaction struct {
+	TxHash       string `json:"txHash"`
+	TraceAddress string `json:"traceAddress"`
+	BlockNumber  int64  `json:"blockNumber"`
+	Timestamp    int64  `json:"timestamp"`
+	Type         string `json:"type"`
+	From         string `json:"from"`
+	To           string `json:"to"`
+	Value        string `json:"value"`
+	ValueWei     string `json:"valueWei"`
+	ValueEth     string `json:"valueEth"`
+	Status       string `json:"status"`
+}
+
+// NFT standards
+const (
+	StandardERC721  = "erc721"
+	StandardERC1155 = "erc1155"
+)
+
+// NFTTransfer is a single token moved by an ERC-721 Transfer or an ERC-1155
+// TransferSingle/TransferBatch event. A batch yields one transfer per token id.
+type NFTTransfer struct {
+	TxHash      string `json:"txHash"`
+	LogIndex    int    `json:"logIndex"`
+	BatchIndex  int    `json:"batchIndex"`
 	BlockNumber int64  `json:"blockNumber"`
 	Timestamp   int64  `json:"timestamp"`
+	Contract    string `json:"contract"`
+	Standard    string `json:"standard"`
+	Operator    string `json:"operator,omitempty"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	TokenID     string `json:"tokenId"`
+	Amount      string `json:"amount"`
+	Status      string `json:"status"`
+}
+
+// NFTHolding is the balance of a token id held by an address, derived from its indexed transfers
+type NFTHolding struct {
+	Contract string `json:"contract"`
+	Standard string `json:"standard"`
+	TokenID  string `json:"tokenId"`
+	Amount   string `json:"amount"`
+}
+
+// IsValidStatus reports whether status is one of the known transaction statuses
+func IsValidStatus(status string) bool {
+	switch status {
+	case StatusPending, StatusConfirmed, StatusFinalized:
+		return true
+	}
+	return false
+}
+
+// DefaultTenant owns the subscriptions made without a tenant, such as every
+// subscription when API authentication is disabled
+const DefaultTenant = "default"
+
+// Subscription is an address watched by a tenant, with the metadata it was
+// subscribed with. Several tenants may subscribe the same address.
+type Subscription struct {
+	Tenant     string   `json:"tenant,omitempty"`
+	Address    string   `json:"address"`
+	Label      string   `json:"label,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	StartBlock int      `json:"startBlock,omitempty"` // block the history was backfilled from, 0 for none
+	WebhookURL string   `json:"webhookUrl,omitempty"`
+	CreatedAt  int64    `json:"createdAt"`
+
+	// VisibleFrom - first block of the history of the address, shared by all
+	// tenants following it, that this tenant sees; 0 shows all of it
+	VisibleFrom int `json:"visibleFrom,omitempty"`
+
+	// WebhookSecret - key signing the deliveries of this subscription, never
+	// returned by the API after the subscription was created
+	WebhookSecret string `json:"webhookSecret,omitempty"`
+}
+
+// SubscribeOptions are the optional settings of a new subscription
+type SubscribeOptions struct {
+	Tenant     string // DefaultTenant when empty
+	Label      string
+	Tags       []string
+	WebhookURL string
+
+	// WebhookSecret - key signing the webhook deliveries
+	WebhookSecret string
+
+	// FromBlock - block to backfill the history from, nil for the configured default
+	// and 0 for no backfill
+	FromBlock *int
+}
+
+// Webhook delivery states
+const (
+	DeliveryPending = "pending"
+	DeliveryDead    = "dead"
+)
+
+// WebhookDelivery is a transaction waiting to be posted to the webhook of a
+// subscription. Deliveries that keep failing end up dead, in the dead-letter list.
+type WebhookDelivery struct {
+	ID            int               `json:"id"`
+	Tenant        string            `json:"tenant,omitempty"`
+	URL           string            `json:"url"`
+	Address       string            `json:"address"`
+	Transaction   ParsedTransaction `json:"transaction"`
+	Status        string            `json:"status"`
+	Attempts      int               `json:"attempts"`
+	LastError     string            `json:"lastError,omitempty"`
+	CreatedAt     int64             `json:"createdAt"`
+	NextAttemptAt int64             `json:"nextAttemptAt"`
+
+	// Secret - key signing the delivery, taken from its subscription when it was
+	// queued so it outlives an unsubscribe; never returned by the API
+	Secret string `json:"secret,omitempty"`
 }
 
+// ReorgEvent describes a chain reorganization handled by the parser
+type ReorgEvent struct {
+	DetectedAt          int64 `json:"detectedAt"`
+	DetectedAtBlock     int   `json:"detectedAtBlock"`
+	CommonAncestor      int   `json:"commonAncestor"`
+	OldHead             int   `json:"oldHead"`
+	Depth               int   `json:"depth"`
+	RemovedTransactions int   `json:"removedTransactions"`
+
+	// Unresolved - no block matching the canonical chain was found within the
+	// tracked history, so records below CommonAncestor may be orphaned
+	Unresolved bool `json:"unresolved,omitempty"`
+}
+
+// Backfill job states
+const (
+	BackfillRunning   = "running"
+	BackfillCompleted = "completed"
+	BackfillFailed    = "failed"
+	BackfillCancelled = "cancelled"
+)
+
+// BackfillJob reports the progress of a historical scan for a subscribed address
+type BackfillJob struct {
+	ID                int    `json:"id"`
+	Tenant            string `json:"tenant,omitempty"`
+	Address           string `json:"address"`
+	FromBlock         int    `json:"fromBlock"`
+	ToBlock           int    `json:"toBlock"`
+	CurrentBlock      int    `json:"currentBlock"`
+	TransactionsFound int    `json:"transactionsFound"`
+	Status            string `json:"status"`
+	Error             string `json:"error,omitempty"`
+	StartedAt         int64  `json:"startedAt"`
+	FinishedAt        int64  `json:"finishedAt,omitempty"`
+}
+
+// FailedBlock is a block that could not be parsed and is queued for a retry
+type FailedBlock struct {
+	Number        int    `json:"number"`
+	Attempts      int    `json:"attempts"`
+	LastError     string `json:"lastError"`
+	FirstFailedAt int64  `json:"firstFailedAt"`
+	LastAttemptAt int64  `json:"lastAttemptAt"`
+}
+
+// APIKey grants a tenant access to the API. Only the SHA-256 hash of the key
+// is kept; Prefix is its start, shown to tell keys apart.
+type APIKey struct {
+	ID        int    `json:"id"`
+	Tenant    string `json:"tenant"`
+	Name      string `json:"name,omitempty"`
+	Prefix    string `json:"prefix"`
+	Hash      string `json:"hash"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// ErrNotFound is returned by lookups of a transaction or block the node does not know either
+var ErrNotFound = errors.New("not found")
+
+// TransactionLookup is a single transaction with the subscribed addresses it touched.
+// Indexed is false when it was not stored and was looked up on the node instead.
+type TransactionLookup struct {
+	Transaction ParsedTransaction `json:"transaction"`
+	Addresses   []string          `json:"addresses"`
+	Indexed     bool              `json:"indexed"`
+}
+
+// BlockLookup is a block with its transactions that touched subscribed addresses.
+// Indexed is false when the block was not parsed yet and was fetched from the node.
+type BlockLookup struct {
+	Number           int64               `json:"number"`
+	Hash             string              `json:"hash,omitempty"`
+	ParentHash       string              `json:"parentHash,omitempty"`
+	Timestamp        int64               `json:"timestamp,omitempty"`
+	TransactionCount int                 `json:"transactionCount,omitempty"` // all transactions, only known when fetched
+	Transactions     []TransactionLookup `json:"transactions"`
+	Indexed          bool                `json:"indexed"`
+}
+
+// Parser is the service behind the API. Methods taking a tenant only see the
+// addresses that tenant subscribed; Subscribe, SubscribeFrom and GetTransactions
+// act for DefaultTenant.
 type Parser interface {
 	// GetCurrentBlock - last parsed block
 	GetCurrentBlock() int
@@ -34,6 +262,54 @@ type Parser interface {
 	// Subscribe - add address to observer
 	Subscribe(address string) bool
 
+	// SubscribeFrom - add address to observer and backfill its history from a block
+	SubscribeFrom(address string, fromBlock int) bool
+
+	// SubscribeWith - add address to observer for a tenant with a label, tags and backfill start
+	SubscribeWith(address string, opts SubscribeOptions) bool
+
+	// Unsubscribe - stop observing an address for a tenant, deleting its stored history
+	// when purge is set; false if the tenant did not subscribe it
+	Unsubscribe(tenant, address string, purge bool) bool
+
+	// ListSubscriptions - addresses observed by a tenant, oldest subscription first
+	ListSubscriptions(tenant string) []Subscription
+
 	// GetTransactions - list of inbound or outbound transactions for an address
 	GetTransactions(address string) []ParsedTransaction
+
+	// QueryTransactions - page of the transactions of an address matching a query
+	QueryTransactions(query TransactionQuery) TransactionPage
+
+	// GetTransaction - a transaction by hash, from storage or else from the node;
+	// ErrNotFound when neither knows it
+	GetTransaction(tenant, hash string) (TransactionLookup, error)
+
+	// GetBlock - the subscribed transactions of a block, from storage when the block
+	// was parsed and else from the node; ErrNotFound when the block does not exist yet
+	GetBlock(tenant string, number int) (BlockLookup, error)
+
+	// GetTokenTransfers - list of ERC-20 transfers sent or received by an address
+	GetTokenTransfers(tenant, address string) []TokenTransfer
+
+	// GetInternalTransactions - list of contract-initiated value transfers sent or received by an address
+	GetInternalTransactions(tenant, address string) []InternalTransaction
+
+	// GetNFTTransfers - list of NFT transfers sent or received by an address
+	GetNFTTransfers(tenant, address string) []NFTTransfer
+
+	// GetNFTHoldings - NFTs currently held by an address according to its indexed transfers
+	GetNFTHoldings(tenant, address string) []NFTHolding
+
+	// GetReorgs - recent chain reorganizations handled by the parser
+	GetReorgs() []ReorgEvent
+
+	// GetBackfills - progress of the historical backfill jobs of a tenant
+	GetBackfills(tenant string) []BackfillJob
+
+	// GetFailedBlocks - blocks waiting to be retried
+	GetFailedBlocks() []FailedBlock
+
+	// GetWebhookDeliveries - queued webhook deliveries of a tenant with a status, all of them when empty
+	GetWebhookDeliveries(tenant, status string) []WebhookDelivery
 }
@@ -0,0 +1,412 @@
+package types
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustParseQuantity(s string) Quantity {
+	q, err := ParseQuantity(s)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+func quantityPtr(s string) *Quantity {
+	q := mustParseQuantity(s)
+	return &q
+}
+
+// loadTransaction decodes a transaction fixture, failing on fields the model does not cover
+func loadTransaction(t *testing.T, name string) Transaction {
+	t.Helper()
+
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Failed to open fixture: %v", err)
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+
+	var tx Transaction
+	if err := decoder.Decode(&tx); err != nil {
+		t.Fatalf("Failed to decode %s: %v", name, err)
+	}
+	return tx
+}
+
+func TestTransactionDecoding(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		expected Transaction
+	}{
+		{
+			fixture: "legacy.json",
+			expected: Transaction{
+				Type:             TxTypeLegacy,
+				Hash:             "0x5c504ed432cb51138bcf09aa5e8a410dd4a1e204ef84bfed1be16dfba1b22060",
+				BlockHash:        "0x4e3a3754410177e6937ef1f84bba68ea139e8d1a2258c5f85db9f1cd715a1bdd",
+				BlockNumber:      "0xb443",
+				TransactionIndex: "0x0",
+				From:             "0xa1e4380a3b1f749673e270229993ee55f35663b4",
+				To:               "0x5df9b87991262f6ba471f09758cde1c0fc1de734",
+				Value:            mustParseQuantity("0x7a69"),
+				Nonce:            mustParseQuantity("0x0"),
+				Input:            "0x",
+				Gas:              mustParseQuantity("0x5208"),
+				GasPrice:         quantityPtr("0x2d79883d2000"),
+				V:                "0x1c",
+				R:                "0x88ff6cf0fefd94db46111149ae4bfc179e9b94721fffd821d38d16464b3f71d0",
+				S:                "0x45e0aff800961cfce805daef7016b9b675c137a6a41a548f7b60a3484c06a33a",
+			},
+		},
+		{
+			fixture: "access_list.json",
+			expected: Transaction{
+				Type:             TxTypeAccessList,
+				Hash:             "0x2f4c8bc5a1e5b8d0ad1d4d9f3f7b6a2c1e0d9f8e7a6b5c4d3e2f1a0b9c8d7e6f",
+				BlockHash:        "0x8a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f9",
+				BlockNumber:      "0xc5d488",
+				TransactionIndex: "0x3",
+				From:             "0x8d97689c9818892b700e27f316cc3e41e17fbeb9",
+				To:               "0xd8da6bf26964af9d7eed9e03e53415d37aa96045",
+				Value:            mustParseQuantity("0x0"),
+				Nonce:            mustParseQuantity("0x2a"),
+				Input:            "0xa9059cbb000000000000000000000000d8da6bf26964af9d7eed9e03e53415d37aa960450000000000000000000000000000000000000000000000000de0b6b3a7640000",
+				Gas:              mustParseQuantity("0x9c40"),
+				ChainID:          quantityPtr("0x1"),
+				GasPrice:         quantityPtr("0x4a817c800"),
+				AccessList: []AccessTuple{{
+					Address: "0xdac17f958d2ee523a2206206994597c13d831ec7",
+					StorageKeys: []string{
+						"0x0000000000000000000000000000000000000000000000000000000000000002",
+						"0x7050c9e0f4ca769c69bd3a8ef740bc37934f8e2c036e5a723fd8ee048ed3f8c3",
+					},
+				}},
+				V:       "0x1",
+				R:       "0x1f2e3d4c5b6a79881726354453627180f9e8d7c6b5a4938271605f4e3d2c1b0a",
+				S:       "0x0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f9",
+				YParity: "0x1",
+			},
+		},
+		{
+			fixture: "dynamic_fee.json",
+			expected: Transaction{
+				Type:                 TxTypeDynamicFee,
+				Hash:                 "0x8e0a8a8b5c6e4b0f4b4c2a9d6e1f3b7c9d2e4f6a8b0c1d3e5f7a9b1c3d5e7f9a",
+				BlockHash:            "0x3c2b1a09f8e7d6c5b4a39281706f5e4d3c2b1a09f8e7d6c5b4a39281706f5e4d",
+				BlockNumber:          "0x1312d00",
+				TransactionIndex:     "0x5c",
+				From:                 "0x95222290dd7278aa3ddd389cc1e1d165cc4bafe5",
+				To:                   "0x388c818ca8b9251b393131c08a736a67ccb19297",
+				Value:                mustParseQuantity("0x1bc16d674ec80000"),
+				Nonce:                mustParseQuantity("0x1b2f4"),
+				Input:                "0x",
+				Gas:                  mustParseQuantity("0x565f"),
+				ChainID:              quantityPtr("0x1"),
+				GasPrice:             quantityPtr("0x2540be400"),
+				MaxFeePerGas:         quantityPtr("0x2540be400"),
+				MaxPriorityFeePerGas: quantityPtr("0x0"),
+				AccessList:           []AccessTuple{},
+				V:                    "0x0",
+				R:                    "0x3d5a4a5a7f1b0c9e8d7c6b5a49382716050f4e3d2c1b0a99887766554433221a",
+				S:                    "0x6b5a49382716050f4e3d2c1b0a9988776655443322110ffeeddccbbaa9988776",
+				YParity:              "0x0",
+			},
+		},
+		{
+			fixture: "blob.json",
+			expected: Transaction{
+				Type:                 TxTypeBlob,
+				Hash:                 "0x5d1d6d3b9a8c7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f",
+				BlockHash:            "0x9f8e7d6c5b4a39281706f5e4d3c2b1a09f8e7d6c5b4a39281706f5e4d3c2b1a0",
+				BlockNumber:          "0x12b9f3c",
+				TransactionIndex:     "0x1",
+				From:                 "0x5050f69a9786f081509234f1a7f4684b5e5b76c9",
+				To:                   "0xc662c410c0ecf747543f5ba90660f6abebd9c8c4",
+				Value:                mustParseQuantity("0x0"),
+				Nonce:                mustParseQuantity("0x10f3a"),
+				Input:                "0x3e5aa082000000000000000000000000000000000000000000000000000000000008a3c1",
+				Gas:                  mustParseQuantity("0x5208"),
+				ChainID:              quantityPtr("0x1"),
+				GasPrice:             quantityPtr("0x3b9aca08"),
+				MaxFeePerGas:         quantityPtr("0x174876e800"),
+				MaxPriorityFeePerGas: quantityPtr("0x3b9aca00"),
+				AccessList:           []AccessTuple{},
+				MaxFeePerBlobGas:     quantityPtr("0x3b9aca00"),
+				BlobVersionedHashes: []string{
+					"0x01a915e4d060149eb4365960e6a7a45f334393093061116b197e3240065ff2d8",
+					"0x0139b3a4a0d6f7c5e3b1f0e9d8c7b6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9",
+				},
+				V:       "0x1",
+				R:       "0x4f7e6d5c4b3a29180f7e6d5c4b3a29180f7e6d5c4b3a29180f7e6d5c4b3a2918",
+				S:       "0x29180f7e6d5c4b3a29180f7e6d5c4b3a29180f7e6d5c4b3a29180f7e6d5c4b3a",
+				YParity: "0x1",
+			},
+		},
+		{
+			fixture: "set_code.json",
+			expected: Transaction{
+				Type:                 TxTypeSetCode,
+				Hash:                 "0x7b2f3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f809",
+				BlockHash:            "0x2a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f70819",
+				BlockNumber:          "0x15a1b2c",
+				TransactionIndex:     "0x0",
+				From:                 "0x0c7e2c54b9a4b8cd6f9b1a6a1b7c4e2d3f5a6b7c",
+				To:                   "0x0c7e2c54b9a4b8cd6f9b1a6a1b7c4e2d3f5a6b7c",
+				Value:                mustParseQuantity("0x0"),
+				Nonce:                mustParseQuantity("0x7"),
+				Input:                "0x",
+				Gas:                  mustParseQuantity("0x186a0"),
+				ChainID:              quantityPtr("0x1"),
+				GasPrice:             quantityPtr("0x77359400"),
+				MaxFeePerGas:         quantityPtr("0xb2d05e00"),
+				MaxPriorityFeePerGas: quantityPtr("0x3b9aca00"),
+				AccessList:           []AccessTuple{},
+				AuthorizationList: []Authorization{{
+					ChainID: "0x1",
+					Address: "0x63c0c19a282a1b52b07dd5a65b58948a07dae32b",
+					Nonce:   "0x8",
+					YParity: "0x0",
+					R:       "0x5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b",
+					S:       "0x1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d",
+				}},
+				V:       "0x1",
+				R:       "0x6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f",
+				S:       "0x3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a",
+				YParity: "0x1",
+			},
+		},
+		{
+			fixture: "contract_creation.json",
+			expected: Transaction{
+				Type:                 TxTypeDynamicFee,
+				Hash:                 "0x1c9e3f5a7b9d1e3f5a7c9e1b3d5f7a9c1e3b5d7f9a1c3e5b7d9f1a3c5e7b9d1f",
+				BlockHash:            "0x6f5e4d3c2b1a09f8e7d6c5b4a39281706f5e4d3c2b1a09f8e7d6c5b4a3928170",
+				BlockNumber:          "0x1312d01",
+				TransactionIndex:     "0x12",
+				From:                 "0x4e59b44847b379578588920ca78fbf26c0b4956c",
+				Value:                mustParseQuantity("0x0"),
+				Nonce:                mustParseQuantity("0x3"),
+				Input:                "0x6080604052348015600f57600080fd5b50603f80601d6000396000f3fe6080604052600080fdfea164736f6c6343000813000a",
+				Gas:                  mustParseQuantity("0x2dc6c0"),
+				ChainID:              quantityPtr("0x1"),
+				GasPrice:             quantityPtr("0x4a817c800"),
+				MaxFeePerGas:         quantityPtr("0x6fc23ac00"),
+				MaxPriorityFeePerGas: quantityPtr("0x77359400"),
+				AccessList:           []AccessTuple{},
+				V:                    "0x0",
+				R:                    "0x2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e",
+				S:                    "0x4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c",
+				YParity:              "0x0",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			tx := loadTransaction(t, tt.fixture)
+			if !reflect.DeepEqual(tx, tt.expected) {
+				t.Errorf("Decoded transaction mismatch\ngot:  %+v\nwant: %+v", tx, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBlockDecoding(t *testing.T) {
+	data := `{
+		"number": "0x1312d00",
+		"hash": "0x3c2b1a09f8e7d6c5b4a39281706f5e4d3c2b1a09f8e7d6c5b4a39281706f5e4d",
+		"parentHash": "0x1a09f8e7d6c5b4a39281706f5e4d3c2b1a09f8e7d6c5b4a39281706f5e4d3c2b",
+		"timestamp": "0x6559a2c3",
+		"miner": "0x95222290dd7278aa3ddd389cc1e1d165cc4bafe5",
+		"gasLimit": "0x1c9c380",
+		"gasUsed": "0xe4e1c0",
+		"baseFeePerGas": "0x2540be400",
+		"blobGasUsed": "0x40000",
+		"excessBlobGas": "0x0",
+		"transactions": [{"type": "0x0", "hash": "0x5c50", "from": "0xa1e4", "to": "0x5df9", "value": "0x1"}]
+	}`
+
+	var block Block
+	if err := json.Unmarshal([]byte(data), &block); err != nil {
+		t.Fatalf("Failed to decode block: %v", err)
+	}
+
+	if block.ParentHash != "0x1a09f8e7d6c5b4a39281706f5e4d3c2b1a09f8e7d6c5b4a39281706f5e4d3c2b" ||
+		block.BaseFeePerGas != "0x2540be400" || block.BlobGasUsed != "0x40000" {
+		t.Errorf("Unexpected block header %+v", block)
+	}
+	if len(block.Transactions) != 1 || block.Transactions[0].Type != TxTypeLegacy || block.Transactions[0].To != "0x5df9" {
+		t.Errorf("Unexpected block transactions %+v", block.Transactions)
+	}
+}
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		input   string
+		decimal string
+		ether   string
+		wantErr bool
+	}{
+		{input: "0x0", decimal: "0", ether: "0"},
+		{input: "0x14d1120d7b160000", decimal: "1500000000000000000", ether: "1.5"},
+		{input: "0x8000000000000000", decimal: "9223372036854775808", ether: "9.223372036854775808"},
+		{input: "0x1", decimal: "1", ether: "0.000000000000000001"},
+		{input: "0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+			decimal: "115792089237316195423570985008687907853269984665640564039457584007913129639935",
+			ether:   "115792089237316195423570985008687907853269984665640564039457.584007913129639935"},
+		{input: "", wantErr: true},
+		{input: "0x", wantErr: true},
+		{input: "10", wantErr: true},
+		{input: "0xzz", wantErr: true},
+		{input: "0x-1", wantErr: true},
+		{input: "0x1ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		q, err := ParseQuantity(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseQuantity(%q): expected an error, got %s", tt.input, q)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseQuantity(%q): unexpected error: %v", tt.input, err)
+			continue
+		}
+		if q.Decimal() != tt.decimal || q.Ether() != tt.ether {
+			t.Errorf("ParseQuantity(%q) = %s wei, %s ETH, want %s wei, %s ETH", tt.input, q.Decimal(), q.Ether(), tt.decimal, tt.ether)
+		}
+	}
+}
+
+func TestQuantityInt64(t *testing.T) {
+	if n, err := mustParseQuantity("0x7fffffffffffffff").Int64(); err != nil || n != 1<<63-1 {
+		t.Errorf("Expected max int64, got %d (%v)", n, err)
+	}
+	if _, err := mustParseQuantity("0x8000000000000000").Int64(); err == nil {
+		t.Errorf("Expected an overflow error")
+	}
+}
+
+func TestQuantityJSON(t *testing.T) {
+	var q Quantity
+	if err := json.Unmarshal([]byte(`"0x00ff"`), &q); err != nil {
+		t.Fatalf("Failed to decode quantity: %v", err)
+	}
+	data, err := json.Marshal(q)
+	if err != nil || string(data) != `"0xff"` {
+		t.Errorf("Expected \"0xff\", got %s (%v)", data, err)
+	}
+
+	for _, input := range []string{`255`, `"ff"`, `null`} {
+		if err := json.Unmarshal([]byte(input), &q); err == nil {
+			t.Errorf("Expected an error decoding %s", input)
+		}
+	}
+}
+
+func TestKeccak256(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{"abc", "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+		{"Transfer(address,address,uint256)", "ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"},
+		// Around the 136 byte rate, where padding spills into a second block
+		{strings.Repeat("a", 135), "34367dc248bbd832f4e3e69dfaac2f92638bd0bbd18f2912ba4ef454919cf446"},
+		{strings.Repeat("a", 136), "a6c4d403279fe3e0af03729caada8374b5ca54d8065329a3ebcaeb4b60aa386e"},
+		{strings.Repeat("a", 200), "96ea54061def936c4be90b518992fdc6f12f535068a256229aca54267b4d084d"},
+		// Keccak team test vector of 1600 bits of 0xa3
+		{strings.Repeat("\xa3", 200), "3a57666b048777f2c953dc4456f45a2588e1cb6f2da760122d530ac2ce607d4a"},
+	}
+
+	for _, tt := range tests {
+		hash := Keccak256([]byte(tt.input))
+		if got := hex.EncodeToString(hash[:]); got != tt.expected {
+			t.Errorf("Keccak256(%q) = %s, want %s", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	// Checksum test vectors from EIP-55
+	checksummed := []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+		"0x52908400098527886E0F7030069857D2E4169EE7",
+		"0x8617E340B3D01FA5F11F306F4090FD50E238070D",
+		"0xde709f2102306220921060314715629080e2fb77",
+		"0x27b1fdb04752bbc536007a920d24acb045561c26",
+	}
+	for _, input := range checksummed {
+		for _, variant := range []string{input, strings.ToLower(input), "0x" + strings.ToUpper(input[2:])} {
+			a, err := ParseAddress(variant)
+			if err != nil {
+				t.Errorf("ParseAddress(%q): unexpected error: %v", variant, err)
+				continue
+			}
+			if a.Checksum() != input || a.Hex() != strings.ToLower(input) {
+				t.Errorf("ParseAddress(%q) = %s (%s), want %s", variant, a.Checksum(), a.Hex(), input)
+			}
+		}
+	}
+
+	invalid := []string{
+		"",
+		"0x",
+		"5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAe",
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAedd",
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeg",
+		// One letter with the wrong case
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD",
+	}
+	for _, input := range invalid {
+		if IsValidAddress(input) {
+			t.Errorf("ParseAddress(%q): expected an error", input)
+		}
+	}
+
+	if got := ChecksumAddress(""); got != "" {
+		t.Errorf("Expected an empty address to stay empty, got %q", got)
+	}
+}
+
+func TestTransactionCursor(t *testing.T) {
+	cursor, err := ParseTransactionCursor("14000000:0xabc")
+	if err != nil || cursor.BlockNumber != 14000000 || cursor.Hash != "0xabc" {
+		t.Fatalf("Unexpected cursor %+v, %v", cursor, err)
+	}
+	if cursor.String() != "14000000:0xabc" {
+		t.Errorf("Expected the cursor to round-trip, got %s", cursor)
+	}
+
+	for _, tx := range []ParsedTransaction{{BlockNumber: 14000001, Hash: "0x1"}, {BlockNumber: 14000000, Hash: "0xabd"}} {
+		if cursor.Compare(tx) >= 0 {
+			t.Errorf("Expected cursor to sort before %+v", tx)
+		}
+	}
+	if cursor.Compare(ParsedTransaction{BlockNumber: 14000000, Hash: "0xabc"}) != 0 {
+		t.Error("Expected cursor to point at its own transaction")
+	}
+
+	for _, invalid := range []string{"", "14000000", "-1:0xabc", "x:0xabc", "14000000:abc"} {
+		if _, err := ParseTransactionCursor(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
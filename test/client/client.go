@@ -37,15 +37,34 @@ type TransactionsResponse struct {
 	Transactions []Transaction `json:"transactions"`
 }
 
-func NewClient(baseURL string) *Client {
+// NewClient creates a client of the API at baseURL, sending apiKey with every
+// request when it is set
+func NewClient(baseURL, apiKey string) *Client {
+	var transport http.RoundTripper = http.DefaultTransport
+	if apiKey != "" {
+		transport = apiKeyTransport{apiKey: apiKey, next: transport}
+	}
 	return &Client{
 		baseURL: baseURL,
 		client: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   10 * time.Second,
+			Transport: transport,
 		},
 	}
 }
 
+// apiKeyTransport adds the API key to the requests it passes on
+type apiKeyTransport struct {
+	apiKey string
+	next   http.RoundTripper
+}
+
+func (t apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	return t.next.RoundTrip(req)
+}
+
 func (c *Client) Subscribe(address string) (*SubscribeResponse, error) {
 	reqBody, err := json.Marshal(SubscribeRequest{Address: address})
 	if err != nil {
@@ -53,7 +72,7 @@ func (c *Client) Subscribe(address string) (*SubscribeResponse, error) {
 	}
 
 	resp, err := c.client.Post(
-		c.baseURL+"/subscribe",
+		c.baseURL+"/v1/subscribe",
 		"application/json",
 		bytes.NewReader(reqBody),
 	)
@@ -76,7 +95,7 @@ func (c *Client) Subscribe(address string) (*SubscribeResponse, error) {
 }
 
 func (c *Client) GetTransactions(address string) (*TransactionsResponse, error) {
-	resp, err := c.client.Get(fmt.Sprintf("%s/transactions?address=%s", c.baseURL, address))
+	resp, err := c.client.Get(fmt.Sprintf("%s/v1/transactions?address=%s", c.baseURL, address))
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
@@ -96,7 +115,7 @@ func (c *Client) GetTransactions(address string) (*TransactionsResponse, error)
 }
 
 func (c *Client) GetCurrentBlock() (int, error) {
-	resp, err := c.client.Get(c.baseURL + "/current-block")
+	resp, err := c.client.Get(c.baseURL + "/v1/current-block")
 	if err != nil {
 		return 0, fmt.Errorf("failed to make request: %w", err)
 	}
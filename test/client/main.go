@@ -3,11 +3,12 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
 	"time"
 )
 
 func main() {
-	client := NewClient("http://localhost:8080")
+	client := NewClient("http://localhost:8080", os.Getenv("API_KEY"))
 
 	// Test addresses (using known active addresses)
 	addresses := []string{